@@ -56,7 +56,8 @@ var (
 // is only used for necessary consensus checks. The legacy consensus engine can be any
 // engine implements the consensus interface (except the beacon itself).
 type Beacon struct {
-	ethone consensus.Engine // Original consensus engine used in eth1, e.g. ethash or clique
+	ethone     consensus.Engine // Original consensus engine used in eth1, e.g. ethash or clique
+	transition *big.Int         // Block number of a fixed engine switch, overriding the TTD if set
 }
 
 // New creates a consensus engine with the given embedded eth1 engine.
@@ -67,6 +68,16 @@ func New(ethone consensus.Engine) *Beacon {
 	return &Beacon{ethone: ethone}
 }
 
+// NewWithTransition creates a consensus engine that switches from the embedded
+// eth1 engine to the proof-of-stake rules at a fixed block number, instead of
+// when the terminal total difficulty is reached. Headers below the transition
+// block are verified by the eth1 engine, the rest by the beacon rules.
+func NewWithTransition(ethone consensus.Engine, block uint64) *Beacon {
+	beacon := New(ethone)
+	beacon.transition = new(big.Int).SetUint64(block)
+	return beacon
+}
+
 // Author implements consensus.Engine, returning the verified author of the block.
 func (beacon *Beacon) Author(header *types.Header) (common.Address, error) {
 	if !beacon.IsPoSHeader(header) {
@@ -78,7 +89,7 @@ func (beacon *Beacon) Author(header *types.Header) (common.Address, error) {
 // VerifyHeader checks whether a header conforms to the consensus rules of the
 // stock Ethereum consensus engine.
 func (beacon *Beacon) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
-	reached, _ := IsTTDReached(chain, header.ParentHash, header.Number.Uint64()-1)
+	reached, _ := beacon.isPostMerge(chain, header.ParentHash, header.Number.Uint64()-1)
 	if !reached {
 		return beacon.ethone.VerifyHeader(chain, header, seal)
 	}
@@ -96,7 +107,7 @@ func (beacon *Beacon) VerifyHeader(chain consensus.ChainHeaderReader, header *ty
 // a results channel to retrieve the async verifications.
 // VerifyHeaders expect the headers to be ordered and continuous.
 func (beacon *Beacon) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
-	if !beacon.IsPoSHeader(headers[len(headers)-1]) {
+	if !beacon.isPoSBlock(headers[len(headers)-1]) {
 		return beacon.ethone.VerifyHeaders(chain, headers, seals)
 	}
 	var (
@@ -105,7 +116,7 @@ func (beacon *Beacon) VerifyHeaders(chain consensus.ChainHeaderReader, headers [
 		preSeals    []bool
 	)
 	for index, header := range headers {
-		if beacon.IsPoSHeader(header) {
+		if beacon.isPoSBlock(header) {
 			preHeaders = headers[:index]
 			postHeaders = headers[index:]
 			preSeals = seals[:index]
@@ -252,7 +263,7 @@ func (beacon *Beacon) verifyHeaders(chain consensus.ChainHeaderReader, headers [
 // header to conform to the beacon protocol. The changes are done inline.
 func (beacon *Beacon) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
 	// Transition isn't triggered yet, use the legacy rules for preparation.
-	reached, err := IsTTDReached(chain, header.ParentHash, header.Number.Uint64()-1)
+	reached, err := beacon.isPostMerge(chain, header.ParentHash, header.Number.Uint64()-1)
 	if err != nil {
 		return err
 	}
@@ -315,7 +326,7 @@ func (beacon *Beacon) SealHash(header *types.Header) common.Hash {
 // given the parent block's time and difficulty.
 func (beacon *Beacon) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
 	// Transition isn't triggered yet, use the legacy rules for calculation
-	if reached, _ := IsTTDReached(chain, parent.Hash(), parent.Number.Uint64()); !reached {
+	if reached, _ := beacon.isPostMerge(chain, parent.Hash(), parent.Number.Uint64()); !reached {
 		return beacon.ethone.CalcDifficulty(chain, time, parent)
 	}
 	return beaconDifficulty
@@ -341,6 +352,27 @@ func (beacon *Beacon) IsPoSHeader(header *types.Header) bool {
 	return header.Difficulty.Cmp(beaconDifficulty) == 0
 }
 
+// isPoSBlock reports whether a header is expected to follow the proof-of-stake
+// rules. With a fixed transition block this is decided by the block number, so
+// that headers on the wrong side of the switch are rejected by the right engine.
+// Otherwise it falls back to checking the header fields.
+func (beacon *Beacon) isPoSBlock(header *types.Header) bool {
+	if beacon.transition != nil {
+		return header.Number.Cmp(beacon.transition) >= 0
+	}
+	return beacon.IsPoSHeader(header)
+}
+
+// isPostMerge reports whether the child of the given parent block must follow
+// the proof-of-stake rules, either because it is at or past the fixed transition
+// block, or because the parent reached the terminal total difficulty.
+func (beacon *Beacon) isPostMerge(chain consensus.ChainHeaderReader, parentHash common.Hash, number uint64) (bool, error) {
+	if beacon.transition != nil {
+		return number+1 >= beacon.transition.Uint64(), nil
+	}
+	return IsTTDReached(chain, parentHash, number)
+}
+
 // InnerEngine returns the embedded eth1 consensus engine.
 func (beacon *Beacon) InnerEngine() consensus.Engine {
 	return beacon.ethone
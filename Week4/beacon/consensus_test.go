@@ -0,0 +1,121 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package beacon
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/faker"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// Tests that a batch straddling a fixed transition block is split between the
+// eth1 engine and the beacon rules by block number, whatever the headers claim.
+func TestVerifyHeadersTransition(t *testing.T) {
+	leakcheck.Check(t)
+
+	genesis := headerbuilder.New(headerbuilder.WithBaseFee(big.NewInt(params.InitialBaseFee)))
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, genesis)
+
+	// Blocks 1 and 2 look proof-of-work, 3 onwards proof-of-stake, but the engine
+	// switches at block 3 regardless
+	var (
+		pow   = headerbuilder.Chain(genesis, 2)
+		pos   = headerbuilder.Chain(pow[1], 3, headerbuilder.WithDifficulty(common.Big0))
+		valid = append(append([]*types.Header{}, pow...), pos...)
+		late  = append(append([]*types.Header{}, pow...), headerbuilder.New(headerbuilder.WithParent(pow[1])))
+		early = headerbuilder.Chain(genesis, 3, headerbuilder.WithDifficulty(common.Big0))
+	)
+	tests := []struct {
+		headers []*types.Header
+		fail    uint64 // Block number the eth1 engine fails, zero for none
+		errs    []bool // Whether each header is expected to fail
+	}{
+		{valid, 0, []bool{false, false, false, false, false}},
+		{valid, 2, []bool{false, true, false, false, false}},  // Pre-transition header goes to the eth1 engine
+		{valid, 3, []bool{false, false, false, false, false}}, // Transition block does not
+		{late, 0, []bool{false, false, true}},                 // Proof-of-work past the transition
+		{early, 1, []bool{true, false, false}},                // Proof-of-stake before the transition
+	}
+	for i, tt := range tests {
+		ethone := faker.New()
+		if tt.fail != 0 {
+			ethone.FailAt(tt.fail, nil)
+		}
+		engine := NewWithTransition(ethone, 3)
+
+		abort, results := engine.VerifyHeaders(chain, tt.headers, make([]bool, len(tt.headers)))
+		for j, header := range tt.headers {
+			if err := <-results; (err != nil) != tt.errs[j] {
+				t.Errorf("test %d: header #%d: error mismatch: have %v, want failure %v", i, header.Number, err, tt.errs[j])
+			}
+		}
+		close(abort)
+	}
+}
+
+// Tests the switch to the beacon rules on the terminal total difficulty: a parent
+// whose total difficulty equals the TTD exactly already has proof-of-stake
+// children, one below it still has proof-of-work ones.
+func TestTerminalTotalDifficulty(t *testing.T) {
+	config := *params.TestChainConfig
+	config.TerminalTotalDifficulty = big.NewInt(10)
+
+	// Genesis and 9 blocks of difficulty one, block 9 reaching the TTD exactly
+	genesis := headerbuilder.New(headerbuilder.WithBaseFee(big.NewInt(params.InitialBaseFee)))
+	headers := append([]*types.Header{genesis}, headerbuilder.Chain(genesis, 9)...)
+	chain := headerbuilder.NewMemoryChain(&config, headers...)
+
+	terminal, preTerminal := headers[9], headers[8]
+	if td := chain.GetTd(terminal.Hash(), 9); td.Cmp(config.TerminalTotalDifficulty) != 0 {
+		t.Fatalf("terminal td mismatch: have %v, want %v", td, config.TerminalTotalDifficulty)
+	}
+	ethone := faker.New()
+	engine := New(ethone)
+
+	if diff := engine.CalcDifficulty(chain, terminal.Time+1, terminal); diff.Cmp(beaconDifficulty) != 0 {
+		t.Errorf("post-terminal difficulty mismatch: have %v, want %v", diff, beaconDifficulty)
+	}
+	if diff := engine.CalcDifficulty(chain, preTerminal.Time+1, preTerminal); diff.Cmp(common.Big1) != 0 {
+		t.Errorf("pre-terminal difficulty mismatch: have %v, want %v", diff, common.Big1)
+	}
+	// Children of the terminal block follow the beacon rules
+	pos := headerbuilder.New(headerbuilder.WithParent(terminal), headerbuilder.WithDifficulty(common.Big0))
+	if err := engine.VerifyHeader(chain, pos, false); err != nil {
+		t.Errorf("post-terminal proof-of-stake header rejected: %v", err)
+	}
+	pow := headerbuilder.New(headerbuilder.WithParent(terminal))
+	if err := engine.VerifyHeader(chain, pow, false); err == nil || !strings.HasPrefix(err.Error(), "invalid difficulty") {
+		t.Errorf("post-terminal proof-of-work header: have %v, want invalid difficulty", err)
+	}
+	// Children of the block before are still left to the eth1 engine
+	ethone.FailAt(9, nil)
+	fork := headerbuilder.New(headerbuilder.WithParent(preTerminal), headerbuilder.WithCoinbase(common.Address{0x01}))
+	if err := engine.VerifyHeader(chain, fork, false); err == nil {
+		t.Errorf("pre-terminal header not verified by the eth1 engine")
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package beacon
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the beacon specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeTooManyUncles    = -40001
+	codeInvalidNonce     = -40002
+	codeInvalidUncleHash = -40003
+)
+
+// Register stable numeric codes for the beacon specific errors.
+func init() {
+	consensus.RegisterErrorCode(errTooManyUncles, codeTooManyUncles)
+	consensus.RegisterErrorCode(errInvalidNonce, codeInvalidNonce)
+	consensus.RegisterErrorCode(errInvalidUncleHash, codeInvalidUncleHash)
+}
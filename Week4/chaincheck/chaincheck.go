@@ -0,0 +1,177 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package chaincheck re-validates a locally stored chain from scratch, reporting
+// the first inconsistency between headers, total difficulties and block bodies.
+package chaincheck
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// batchSize is the number of headers handed to the engine in one go.
+const batchSize = 2048
+
+var (
+	errMissingHeader   = errors.New("missing header")
+	errMissingBody     = errors.New("missing block body")
+	errMissingTd       = errors.New("missing total difficulty")
+	errTdMismatch      = errors.New("total difficulty mismatch")
+	errTxRootMismatch  = errors.New("transaction root mismatch")
+	errUncleMismatch   = errors.New("uncle hash mismatch")
+	errReceiptMismatch = errors.New("receipt root mismatch")
+)
+
+// ReceiptReader is an optional interface a chain may implement to allow the
+// receipt roots to be checked too.
+type ReceiptReader interface {
+	// GetReceiptsByHash retrieves the receipts of all transactions in a block.
+	GetReceiptsByHash(hash common.Hash) types.Receipts
+}
+
+// Inconsistency describes the first problem found in a chain.
+type Inconsistency struct {
+	Number uint64      // Number of the offending block
+	Hash   common.Hash // Hash of the offending block (zero if the header is missing)
+	Err    error       // Description of the problem
+}
+
+// Error implements error, rendering the inconsistency in one line.
+func (i *Inconsistency) Error() string {
+	return fmt.Sprintf("block #%d [%s]: %v", i.Number, i.Hash.TerminalString(), i.Err)
+}
+
+// Unwrap returns the underlying cause of the inconsistency.
+func (i *Inconsistency) Unwrap() error {
+	return i.Err
+}
+
+// Verify walks the canonical chain from block 1 up to and including the head,
+// re-running the engine's header verification and checking the total difficulty
+// arithmetic, the transaction and uncle roots and, if the chain implements
+// ReceiptReader, the receipt roots of the blocks whose receipts are stored.
+// The first problem found is returned as an *Inconsistency, or nil if the
+// entire chain is consistent.
+func Verify(engine consensus.Engine, chain consensus.ChainReader) error {
+	head := chain.CurrentHeader()
+	if head == nil {
+		return &Inconsistency{Err: errMissingHeader}
+	}
+	var (
+		receipts, _ = chain.(ReceiptReader)
+		start       = time.Now()
+		logged      = time.Now()
+	)
+	for from := uint64(1); from <= head.Number.Uint64(); from += batchSize {
+		to := from + batchSize - 1
+		if to > head.Number.Uint64() {
+			to = head.Number.Uint64()
+		}
+		if err := verifyBatch(engine, chain, receipts, from, to); err != nil {
+			return err
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Verifying chain", "number", to, "head", head.Number, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	return nil
+}
+
+// verifyBatch checks the canonical blocks in the range [from, to].
+func verifyBatch(engine consensus.Engine, chain consensus.ChainReader, receipts ReceiptReader, from, to uint64) error {
+	parent := chain.GetHeaderByNumber(from - 1)
+	if parent == nil {
+		return &Inconsistency{Number: from - 1, Err: errMissingHeader}
+	}
+	headers := make([]*types.Header, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		header := chain.GetHeaderByNumber(n)
+		if header == nil {
+			return &Inconsistency{Number: n, Err: errMissingHeader}
+		}
+		headers = append(headers, header)
+	}
+	// Run the consensus checks against a view of the chain that ends at the
+	// parent, otherwise engines would short circuit on the already known headers
+	seals := make([]bool, len(headers))
+	for i := range seals {
+		seals[i] = true
+	}
+	abort, results := engine.VerifyHeaders(consensus.TruncateChain(chain, parent), headers, seals)
+	defer close(abort)
+
+	for i, header := range headers {
+		if err := <-results; err != nil {
+			return &Inconsistency{Number: header.Number.Uint64(), Hash: header.Hash(), Err: err}
+		}
+		if err := verifyBlock(chain, receipts, parent, header); err != nil {
+			return &Inconsistency{Number: header.Number.Uint64(), Hash: header.Hash(), Err: err}
+		}
+		parent = headers[i]
+	}
+	return nil
+}
+
+// verifyBlock checks the non-consensus invariants of a single stored block.
+func verifyBlock(chain consensus.ChainReader, receipts ReceiptReader, parent, header *types.Header) error {
+	var (
+		hash   = header.Hash()
+		number = header.Number.Uint64()
+	)
+	// Total difficulty must be the parent's plus the header's own difficulty
+	ptd := chain.GetTd(parent.Hash(), parent.Number.Uint64())
+	td := chain.GetTd(hash, number)
+	if ptd == nil || td == nil {
+		return errMissingTd
+	}
+	if want := new(big.Int).Add(ptd, header.Difficulty); td.Cmp(want) != 0 {
+		return fmt.Errorf("%w: have %v, want %v", errTdMismatch, td, want)
+	}
+	// The body must hash to the roots committed to by the header
+	block := chain.GetBlock(hash, number)
+	if block == nil {
+		return errMissingBody
+	}
+	if root := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); root != header.TxHash {
+		return fmt.Errorf("%w: have %x, want %x", errTxRootMismatch, root, header.TxHash)
+	}
+	if uncles := types.CalcUncleHash(block.Uncles()); uncles != header.UncleHash {
+		return fmt.Errorf("%w: have %x, want %x", errUncleMismatch, uncles, header.UncleHash)
+	}
+	if receipts == nil {
+		return nil
+	}
+	// Receipts may legitimately be absent, e.g. if never stored for the block
+	// or pruned since, which leaves nothing to check
+	stored := receipts.GetReceiptsByHash(hash)
+	if stored == nil {
+		return nil
+	}
+	if root := types.DeriveSha(stored, trie.NewStackTrie(nil)); root != header.ReceiptHash {
+		return fmt.Errorf("%w: have %x, want %x", errReceiptMismatch, root, header.ReceiptHash)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package chaincheck
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/faker"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// testChain is a canonical chain of blocks held in memory, along with their
+// receipts and total difficulties overriding the computed ones.
+type testChain struct {
+	*headerbuilder.MemoryChain
+	tds      map[common.Hash]*big.Int
+	receipts map[common.Hash]types.Receipts
+}
+
+// newTestChain creates a chain of the given length on top of a genesis block,
+// with one transaction and receipt per block.
+func newTestChain(n int) (*testChain, []*types.Block) {
+	var (
+		genesis = types.NewBlockWithHeader(&types.Header{Number: big.NewInt(0), Difficulty: big.NewInt(1)})
+		blocks  = []*types.Block{genesis}
+		chain   = &testChain{
+			tds:      make(map[common.Hash]*big.Int),
+			receipts: make(map[common.Hash]types.Receipts),
+		}
+	)
+	for i := 1; i <= n; i++ {
+		var (
+			parent   = blocks[i-1]
+			txs      = []*types.Transaction{types.NewTransaction(uint64(i), common.Address{0x01}, big.NewInt(1), 21000, big.NewInt(1), nil)}
+			receipts = types.Receipts{{Status: types.ReceiptStatusSuccessful, CumulativeGasUsed: 21000, Logs: []*types.Log{}}}
+		)
+		header := headerbuilder.New(headerbuilder.WithParent(parent.Header()))
+		block := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+		blocks = append(blocks, block)
+		chain.receipts[block.Hash()] = receipts
+	}
+	chain.MemoryChain = headerbuilder.NewMemoryChain(params.TestChainConfig)
+	chain.InsertBlocks(blocks...)
+	return chain, blocks
+}
+
+func (c *testChain) GetTd(hash common.Hash, number uint64) *big.Int {
+	if td, ok := c.tds[hash]; ok {
+		return td
+	}
+	return c.MemoryChain.GetTd(hash, number)
+}
+
+func (c *testChain) GetReceiptsByHash(hash common.Hash) types.Receipts {
+	return c.receipts[hash]
+}
+
+// Tests that each kind of inconsistency is reported at the offending block, and
+// that blocks without stored receipts are skipped rather than flagged.
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(chain *testChain, block *types.Block)
+		want   error
+	}{
+		{
+			name:   "consistent",
+			tamper: func(chain *testChain, block *types.Block) {},
+		},
+		{
+			name: "td mismatch",
+			tamper: func(chain *testChain, block *types.Block) {
+				chain.tds[block.Hash()] = new(big.Int).Add(chain.MemoryChain.GetTd(block.Hash(), block.NumberU64()), common.Big1)
+			},
+			want: errTdMismatch,
+		},
+		{
+			name: "tx root mismatch",
+			tamper: func(chain *testChain, block *types.Block) {
+				chain.InsertBlocks(block.WithBody(nil, block.Uncles()))
+			},
+			want: errTxRootMismatch,
+		},
+		{
+			name: "uncle hash mismatch",
+			tamper: func(chain *testChain, block *types.Block) {
+				uncle := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}
+				chain.InsertBlocks(block.WithBody(block.Transactions(), []*types.Header{uncle}))
+			},
+			want: errUncleMismatch,
+		},
+		{
+			name: "receipt root mismatch",
+			tamper: func(chain *testChain, block *types.Block) {
+				chain.receipts[block.Hash()] = types.Receipts{{Status: types.ReceiptStatusFailed, CumulativeGasUsed: 21000, Logs: []*types.Log{}}}
+			},
+			want: errReceiptMismatch,
+		},
+		{
+			name: "receipts not stored",
+			tamper: func(chain *testChain, block *types.Block) {
+				delete(chain.receipts, block.Hash())
+			},
+		},
+	}
+	for _, tt := range tests {
+		chain, blocks := newTestChain(5)
+		tampered := blocks[3]
+		tt.tamper(chain, tampered)
+
+		err := Verify(faker.New(), chain)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if tt.want == nil {
+			continue
+		}
+		var inconsistency *Inconsistency
+		if !errors.As(err, &inconsistency) {
+			t.Errorf("%s: error type mismatch: have %T, want *Inconsistency", tt.name, err)
+		} else if inconsistency.Number != 3 || inconsistency.Hash != tampered.Hash() {
+			t.Errorf("%s: block mismatch: have #%d [%x], want #3 [%x]", tt.name, inconsistency.Number, inconsistency.Hash, tampered.Hash())
+		}
+	}
+}
+
+// Tests that headers rejected by the engine are reported at the offending block.
+func TestVerifyHeaders(t *testing.T) {
+	leakcheck.Check(t)
+
+	chain, _ := newTestChain(5)
+
+	engine := faker.New()
+	engine.FailAt(2, nil)
+	if err := Verify(engine, chain); err == nil || err.(*Inconsistency).Number != 2 {
+		t.Errorf("engine failure: have %v, want failure at #2", err)
+	}
+}
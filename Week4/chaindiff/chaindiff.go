@@ -0,0 +1,213 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package chaindiff compares two local header chains, locating their common
+// ancestor and re-verifying the diverging headers on both sides.
+package chaindiff
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// defaultLimit is the number of diverging headers compared per side if the
+// caller does not request a specific amount.
+const defaultLimit = 64
+
+var (
+	// errMissingHead is returned if one of the chains has no current header.
+	errMissingHead = errors.New("missing chain head")
+
+	// errNoCommonAncestor is returned if the two chains don't even share the
+	// genesis block.
+	errNoCommonAncestor = errors.New("no common ancestor")
+)
+
+// Divergence is a pair of headers at the same height past the common ancestor,
+// along with the verdict of the consensus engine on each of them. Either header
+// may be nil if one chain is shorter than the other.
+type Divergence struct {
+	Number    uint64
+	Local     *types.Header
+	Remote    *types.Header
+	LocalErr  error
+	RemoteErr error
+}
+
+// Report is the outcome of comparing two header chains.
+type Report struct {
+	Ancestor   *types.Header // Last header both chains agree upon
+	LocalHead  *types.Header // Current head of the local chain
+	RemoteHead *types.Header // Current head of the remote chain
+	LocalTd    *big.Int      // Total difficulty of the local head (nil if unknown)
+	RemoteTd   *big.Int      // Total difficulty of the remote head (nil if unknown)
+
+	Diverged []Divergence // Headers past the ancestor, ascending by number
+}
+
+// Forked reports whether the two chains have diverged at all, as opposed to one
+// simply being a prefix of the other.
+func (r *Report) Forked() bool {
+	for _, d := range r.Diverged {
+		if d.Local != nil && d.Remote != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// FirstInvalid returns the lowest diverging entry for which either side failed
+// consensus verification, or nil if every header checked out.
+func (r *Report) FirstInvalid() *Divergence {
+	for i := range r.Diverged {
+		if r.Diverged[i].LocalErr != nil || r.Diverged[i].RemoteErr != nil {
+			return &r.Diverged[i]
+		}
+	}
+	return nil
+}
+
+// String renders the report in a concise, human readable form.
+func (r *Report) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "local head:  #%d [%s] td=%v\n", r.LocalHead.Number, abbrev(r.LocalHead.Hash()), r.LocalTd)
+	fmt.Fprintf(&b, "remote head: #%d [%s] td=%v\n", r.RemoteHead.Number, abbrev(r.RemoteHead.Hash()), r.RemoteTd)
+	fmt.Fprintf(&b, "ancestor:    #%d [%s]\n", r.Ancestor.Number, abbrev(r.Ancestor.Hash()))
+
+	if len(r.Diverged) == 0 {
+		b.WriteString("chains are identical\n")
+		return b.String()
+	}
+	if !r.Forked() {
+		b.WriteString("no fork, one chain is a prefix of the other\n")
+	}
+	for _, d := range r.Diverged {
+		fmt.Fprintf(&b, "#%-8d local %s  remote %s\n", d.Number, describe(d.Local, d.LocalErr), describe(d.Remote, d.RemoteErr))
+	}
+	return b.String()
+}
+
+// abbrev shortens a hash to something that fits into a report line.
+func abbrev(hash common.Hash) string {
+	return hash.TerminalString()
+}
+
+// describe formats one side of a divergence entry.
+func describe(header *types.Header, err error) string {
+	switch {
+	case header == nil:
+		return fmt.Sprintf("%-24s", "-")
+	case err != nil:
+		return fmt.Sprintf("%s INVALID (%v)", abbrev(header.Hash()), err)
+	default:
+		return fmt.Sprintf("%s ok%14s", abbrev(header.Hash()), "")
+	}
+}
+
+// Compare locates the common ancestor of the two canonical chains and verifies
+// up to limit headers past it on each side with the given consensus engine. A
+// non-positive limit selects a sensible default.
+func Compare(engine consensus.Engine, local, remote consensus.ChainHeaderReader, limit int) (*Report, error) {
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	report := &Report{
+		LocalHead:  local.CurrentHeader(),
+		RemoteHead: remote.CurrentHeader(),
+	}
+	if report.LocalHead == nil || report.RemoteHead == nil {
+		return nil, errMissingHead
+	}
+	report.LocalTd = local.GetTd(report.LocalHead.Hash(), report.LocalHead.Number.Uint64())
+	report.RemoteTd = remote.GetTd(report.RemoteHead.Hash(), report.RemoteHead.Number.Uint64())
+
+	// Walk back from the lower head until the canonical hashes match
+	number := report.LocalHead.Number.Uint64()
+	if n := report.RemoteHead.Number.Uint64(); n < number {
+		number = n
+	}
+	for {
+		a, b := local.GetHeaderByNumber(number), remote.GetHeaderByNumber(number)
+		if a != nil && b != nil && a.Hash() == b.Hash() {
+			report.Ancestor = a
+			break
+		}
+		if number == 0 {
+			return nil, errNoCommonAncestor
+		}
+		number--
+	}
+	// Gather the diverging segments and verify each against its own chain
+	localHeaders := collect(local, number+1, limit)
+	remoteHeaders := collect(remote, number+1, limit)
+
+	localErrs := verify(engine, local, report.Ancestor, localHeaders)
+	remoteErrs := verify(engine, remote, report.Ancestor, remoteHeaders)
+
+	for i := 0; i < len(localHeaders) || i < len(remoteHeaders); i++ {
+		d := Divergence{Number: number + 1 + uint64(i)}
+		if i < len(localHeaders) {
+			d.Local, d.LocalErr = localHeaders[i], localErrs[i]
+		}
+		if i < len(remoteHeaders) {
+			d.Remote, d.RemoteErr = remoteHeaders[i], remoteErrs[i]
+		}
+		report.Diverged = append(report.Diverged, d)
+	}
+	return report, nil
+}
+
+// collect retrieves at most limit consecutive canonical headers starting at
+// the given number.
+func collect(chain consensus.ChainHeaderReader, from uint64, limit int) []*types.Header {
+	var headers []*types.Header
+	for n := from; len(headers) < limit; n++ {
+		header := chain.GetHeaderByNumber(n)
+		if header == nil {
+			break
+		}
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+// verify runs the batch of headers through the consensus engine. The chain is
+// truncated at the ancestor so that engines don't short circuit on headers they
+// already know about, and instead check every diverging header from scratch.
+func verify(engine consensus.Engine, chain consensus.ChainHeaderReader, ancestor *types.Header, headers []*types.Header) []error {
+	errs := make([]error, len(headers))
+	if len(headers) == 0 {
+		return errs
+	}
+	seals := make([]bool, len(headers))
+	for i := range seals {
+		seals[i] = true
+	}
+	abort, results := engine.VerifyHeaders(consensus.TruncateChain(chain, ancestor), headers, seals)
+	defer close(abort)
+
+	for i := range headers {
+		errs[i] = <-results
+	}
+	return errs
+}
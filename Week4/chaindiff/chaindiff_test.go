@@ -0,0 +1,79 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package chaindiff
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// extend appends n headers on top of the given ones, tagging each with the
+// provided extra-data to make sibling branches distinct.
+func extend(headers []*types.Header, n int, extra byte) []*types.Header {
+	chain := append([]*types.Header{}, headers...)
+	return append(chain, headerbuilder.Chain(chain[len(chain)-1], n, headerbuilder.WithExtra([]byte{extra}))...)
+}
+
+func TestCompare(t *testing.T) {
+	genesis := []*types.Header{headerbuilder.New()}
+	shared := extend(genesis, 5, 0)
+
+	local := headerbuilder.NewMemoryChain(params.TestChainConfig, extend(shared, 3, 1)...)
+	remote := headerbuilder.NewMemoryChain(params.TestChainConfig, extend(shared, 4, 2)...)
+
+	report, err := Compare(ethash.NewFullFaker(), local, remote, 0)
+	if err != nil {
+		t.Fatalf("failed to compare chains: %v", err)
+	}
+	if have, want := report.Ancestor.Hash(), shared[len(shared)-1].Hash(); have != want {
+		t.Errorf("ancestor mismatch: have %x, want %x", have, want)
+	}
+	if len(report.Diverged) != 4 {
+		t.Fatalf("diverged length mismatch: have %d, want %d", len(report.Diverged), 4)
+	}
+	if !report.Forked() {
+		t.Errorf("fork not detected")
+	}
+	if last := report.Diverged[3]; last.Local != nil || last.Remote == nil {
+		t.Errorf("tail entry mismatch: local %v, remote %v", last.Local, last.Remote)
+	}
+	if invalid := report.FirstInvalid(); invalid != nil {
+		t.Errorf("unexpected invalid header at #%d", invalid.Number)
+	}
+}
+
+func TestComparePrefix(t *testing.T) {
+	genesis := []*types.Header{headerbuilder.New()}
+	headers := extend(genesis, 5, 0)
+	local := headerbuilder.NewMemoryChain(params.TestChainConfig, headers...)
+	remote := headerbuilder.NewMemoryChain(params.TestChainConfig, headers[:3]...)
+
+	report, err := Compare(ethash.NewFullFaker(), local, remote, 0)
+	if err != nil {
+		t.Fatalf("failed to compare chains: %v", err)
+	}
+	if report.Forked() {
+		t.Errorf("prefix reported as fork")
+	}
+	if len(report.Diverged) != 3 {
+		t.Errorf("diverged length mismatch: have %d, want %d", len(report.Diverged), 3)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// truncatedChain is a header reader that hides every header above a given head.
+type truncatedChain struct {
+	ChainHeaderReader
+	head *types.Header
+}
+
+// TruncateChain returns a view of the chain that ends at the given head, hiding
+// every header above it. This is useful to re-verify headers that are already
+// stored locally, since engines short circuit on headers they already know.
+func TruncateChain(chain ChainHeaderReader, head *types.Header) ChainHeaderReader {
+	return &truncatedChain{ChainHeaderReader: chain, head: head}
+}
+
+func (c *truncatedChain) visible(number uint64) bool {
+	return number <= c.head.Number.Uint64()
+}
+
+// CurrentHeader implements ChainHeaderReader, returning the truncation point.
+func (c *truncatedChain) CurrentHeader() *types.Header {
+	return c.head
+}
+
+// GetHeader implements ChainHeaderReader, hiding headers above the head.
+func (c *truncatedChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if !c.visible(number) {
+		return nil
+	}
+	return c.ChainHeaderReader.GetHeader(hash, number)
+}
+
+// GetHeaderByNumber implements ChainHeaderReader, hiding headers above the head.
+func (c *truncatedChain) GetHeaderByNumber(number uint64) *types.Header {
+	if !c.visible(number) {
+		return nil
+	}
+	return c.ChainHeaderReader.GetHeaderByNumber(number)
+}
+
+// GetHeaderByHash implements ChainHeaderReader, hiding headers above the head.
+func (c *truncatedChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	header := c.ChainHeaderReader.GetHeaderByHash(hash)
+	if header == nil || !c.visible(header.Number.Uint64()) {
+		return nil
+	}
+	return header
+}
+
+// GetTd implements ChainHeaderReader, hiding difficulties above the head.
+func (c *truncatedChain) GetTd(hash common.Hash, number uint64) *big.Int {
+	if !c.visible(number) {
+		return nil
+	}
+	return c.ChainHeaderReader.GetTd(hash, number)
+}
+
+// batchChain is a header reader that overlays a batch of headers not yet stored
+// in the chain, making them resolvable as ancestors.
+type batchChain struct {
+	ChainHeaderReader
+	headers map[common.Hash]*types.Header
+}
+
+// WithHeaders returns a view of the chain in which the given headers, typically
+// a batch being verified, can be looked up by hash even if they are not stored
+// yet. This is needed by rules that look further back than the direct parent.
+func WithHeaders(chain ChainHeaderReader, headers []*types.Header) ChainHeaderReader {
+	batch := &batchChain{
+		ChainHeaderReader: chain,
+		headers:           make(map[common.Hash]*types.Header, len(headers)),
+	}
+	for _, header := range headers {
+		batch.headers[header.Hash()] = header
+	}
+	return batch
+}
+
+// GetHeader implements ChainHeaderReader, resolving batch headers first.
+func (c *batchChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header, ok := c.headers[hash]; ok && header.Number.Uint64() == number {
+		return header
+	}
+	return c.ChainHeaderReader.GetHeader(hash, number)
+}
+
+// GetHeaderByHash implements ChainHeaderReader, resolving batch headers first.
+func (c *batchChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	if header, ok := c.headers[hash]; ok {
+		return header
+	}
+	return c.ChainHeaderReader.GetHeaderByHash(hash)
+}
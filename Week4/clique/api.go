@@ -46,18 +46,20 @@ func (api *API) GetSnapshot(number *rpc.BlockNumber) (*Snapshot, error) {
 	}
 	// Ensure we have an actually valid block and return its snapshot
 	if header == nil {
-		return nil, errUnknownBlock
+		return nil, consensus.WithErrorCode(errUnknownBlock)
 	}
-	return api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	snap, err := api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	return snap, consensus.WithErrorCode(err)
 }
 
 // GetSnapshotAtHash retrieves the state snapshot at a given block.
 func (api *API) GetSnapshotAtHash(hash common.Hash) (*Snapshot, error) {
 	header := api.chain.GetHeaderByHash(hash)
 	if header == nil {
-		return nil, errUnknownBlock
+		return nil, consensus.WithErrorCode(errUnknownBlock)
 	}
-	return api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	snap, err := api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
+	return snap, consensus.WithErrorCode(err)
 }
 
 // GetSigners retrieves the list of authorized signers at the specified block.
@@ -71,11 +73,11 @@ func (api *API) GetSigners(number *rpc.BlockNumber) ([]common.Address, error) {
 	}
 	// Ensure we have an actually valid block and return the signers from its snapshot
 	if header == nil {
-		return nil, errUnknownBlock
+		return nil, consensus.WithErrorCode(errUnknownBlock)
 	}
 	snap, err := api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
 	if err != nil {
-		return nil, err
+		return nil, consensus.WithErrorCode(err)
 	}
 	return snap.signers(), nil
 }
@@ -84,11 +86,11 @@ func (api *API) GetSigners(number *rpc.BlockNumber) ([]common.Address, error) {
 func (api *API) GetSignersAtHash(hash common.Hash) ([]common.Address, error) {
 	header := api.chain.GetHeaderByHash(hash)
 	if header == nil {
-		return nil, errUnknownBlock
+		return nil, consensus.WithErrorCode(errUnknownBlock)
 	}
 	snap, err := api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
 	if err != nil {
-		return nil, err
+		return nil, consensus.WithErrorCode(err)
 	}
 	return snap.signers(), nil
 }
@@ -142,7 +144,7 @@ func (api *API) Status() (*status, error) {
 	)
 	snap, err := api.clique.snapshot(api.chain, header.Number.Uint64(), header.Hash(), nil)
 	if err != nil {
-		return nil, err
+		return nil, consensus.WithErrorCode(err)
 	}
 	var (
 		signers = snap.signers()
@@ -168,7 +170,7 @@ func (api *API) Status() (*status, error) {
 		diff += h.Difficulty.Uint64()
 		sealer, err := api.clique.Author(h)
 		if err != nil {
-			return nil, err
+			return nil, consensus.WithErrorCode(err)
 		}
 		signStatus[sealer]++
 	}
@@ -221,15 +223,18 @@ func (api *API) GetSigner(rlpOrBlockNr *blockNumberOrHashOrRLP) (common.Address,
 		if header == nil {
 			return common.Address{}, fmt.Errorf("missing block %v", blockNrOrHash.String())
 		}
-		return api.clique.Author(header)
+		signer, err := api.clique.Author(header)
+		return signer, consensus.WithErrorCode(err)
 	}
 	block := new(types.Block)
 	if err := rlp.DecodeBytes(rlpOrBlockNr.RLP, block); err == nil {
-		return api.clique.Author(block.Header())
+		signer, err := api.clique.Author(block.Header())
+		return signer, consensus.WithErrorCode(err)
 	}
 	header := new(types.Header)
 	if err := rlp.DecodeBytes(rlpOrBlockNr.RLP, header); err != nil {
 		return common.Address{}, err
 	}
-	return api.clique.Author(header)
+	signer, err := api.clique.Author(header)
+	return signer, consensus.WithErrorCode(err)
 }
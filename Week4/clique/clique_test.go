@@ -17,18 +17,25 @@
 package clique
 
 import (
+	"bytes"
 	"math/big"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
 // This test case is a repro of an annoying bug that took us forever to catch.
 // In Clique PoA networks (Rinkeby, Görli, etc), consecutive blocks might have
 // the same state root (no block subsidy, empty block). If a node crashes, the
@@ -125,3 +132,95 @@ func TestSealHash(t *testing.T) {
 		t.Errorf("have %x, want %x", have, want)
 	}
 }
+
+// Tests that the clique signing payload stays in sync with the consensus
+// encoding of types.Header: every header field must be present in the same
+// order, with only the seal stripped from the extra-data. This catches drift if
+// fields are ever added to the header without updating the sealing logic.
+func TestSealRLPMatchesHeader(t *testing.T) {
+	for _, baseFee := range []*big.Int{nil, big.NewInt(params.InitialBaseFee)} {
+		header := &types.Header{
+			ParentHash:  common.HexToHash("0x01"),
+			UncleHash:   types.EmptyUncleHash,
+			Coinbase:    common.HexToAddress("0x02"),
+			Root:        common.HexToHash("0x03"),
+			TxHash:      types.EmptyRootHash,
+			ReceiptHash: types.EmptyRootHash,
+			Difficulty:  big.NewInt(2),
+			Number:      big.NewInt(100),
+			GasLimit:    8000000,
+			GasUsed:     21000,
+			Time:        1600000000,
+			Extra:       append(make([]byte, extraVanity), make([]byte, extraSeal)...),
+			MixDigest:   common.HexToHash("0x04"),
+			Nonce:       types.EncodeNonce(5),
+			BaseFee:     baseFee,
+		}
+		var full, seal []rlp.RawValue
+		if err := rlp.DecodeBytes(mustEncode(t, header), &full); err != nil {
+			t.Fatalf("failed to decode header: %v", err)
+		}
+		if err := rlp.DecodeBytes(CliqueRLP(header), &seal); err != nil {
+			t.Fatalf("failed to decode seal payload: %v", err)
+		}
+		if len(full) != len(seal) {
+			t.Fatalf("field count mismatch: header %d, seal payload %d", len(full), len(seal))
+		}
+		extraIndex := 12
+		for i := range full {
+			if i == extraIndex {
+				continue
+			}
+			if !bytes.Equal(full[i], seal[i]) {
+				t.Errorf("field %d mismatch: header %x, seal payload %x", i, full[i], seal[i])
+			}
+		}
+		if want := mustEncode(t, header.Extra[:extraVanity]); !bytes.Equal(seal[extraIndex], want) {
+			t.Errorf("extra-data mismatch: have %x, want %x", seal[extraIndex], want)
+		}
+	}
+}
+
+// Tests that signed clique headers and blocks survive an RLP round trip
+// through the upstream types unchanged.
+func TestHeaderRLPRoundTrip(t *testing.T) {
+	key, _ := crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+
+	header := &types.Header{
+		UncleHash:  types.EmptyUncleHash,
+		Difficulty: diffInTurn,
+		Number:     big.NewInt(1),
+		GasLimit:   8000000,
+		Time:       1600000000,
+		Extra:      make([]byte, extraVanity+extraSeal),
+		BaseFee:    big.NewInt(params.InitialBaseFee),
+	}
+	sig, _ := crypto.Sign(SealHash(header).Bytes(), key)
+	copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+
+	decoded := new(types.Header)
+	if err := rlp.DecodeBytes(mustEncode(t, header), decoded); err != nil {
+		t.Fatalf("failed to decode header: %v", err)
+	}
+	if decoded.Hash() != header.Hash() {
+		t.Errorf("header hash mismatch: have %x, want %x", decoded.Hash(), header.Hash())
+	}
+	if SealHash(decoded) != SealHash(header) {
+		t.Errorf("seal hash mismatch: have %x, want %x", SealHash(decoded), SealHash(header))
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(mustEncode(t, types.NewBlockWithHeader(header)), block); err != nil {
+		t.Fatalf("failed to decode block: %v", err)
+	}
+	if block.Hash() != header.Hash() {
+		t.Errorf("block hash mismatch: have %x, want %x", block.Hash(), header.Hash())
+	}
+}
+
+func mustEncode(t *testing.T, val interface{}) []byte {
+	blob, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		t.Fatalf("failed to encode %T: %v", val, err)
+	}
+	return blob
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the clique specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeUnknownBlock                 = -39101
+	codeInvalidCheckpointBeneficiary = -39102
+	codeInvalidVote                  = -39103
+	codeInvalidCheckpointVote        = -39104
+	codeMissingVanity                = -39105
+	codeMissingSignature             = -39106
+	codeExtraSigners                 = -39107
+	codeInvalidCheckpointSigners     = -39108
+	codeMismatchingCheckpointSigners = -39109
+	codeInvalidMixDigest             = -39110
+	codeInvalidUncleHash             = -39111
+	codeInvalidDifficulty            = -39112
+	codeWrongDifficulty              = -39113
+	codeInvalidTimestamp             = -39114
+	codeInvalidVotingChain           = -39115
+	codeUnauthorizedSigner           = -39116
+	codeRecentlySigned               = -39117
+)
+
+// Register stable numeric codes for the clique specific errors.
+func init() {
+	consensus.RegisterErrorCode(errUnknownBlock, codeUnknownBlock)
+	consensus.RegisterErrorCode(errInvalidCheckpointBeneficiary, codeInvalidCheckpointBeneficiary)
+	consensus.RegisterErrorCode(errInvalidVote, codeInvalidVote)
+	consensus.RegisterErrorCode(errInvalidCheckpointVote, codeInvalidCheckpointVote)
+	consensus.RegisterErrorCode(errMissingVanity, codeMissingVanity)
+	consensus.RegisterErrorCode(errMissingSignature, codeMissingSignature)
+	consensus.RegisterErrorCode(errExtraSigners, codeExtraSigners)
+	consensus.RegisterErrorCode(errInvalidCheckpointSigners, codeInvalidCheckpointSigners)
+	consensus.RegisterErrorCode(errMismatchingCheckpointSigners, codeMismatchingCheckpointSigners)
+	consensus.RegisterErrorCode(errInvalidMixDigest, codeInvalidMixDigest)
+	consensus.RegisterErrorCode(errInvalidUncleHash, codeInvalidUncleHash)
+	consensus.RegisterErrorCode(errInvalidDifficulty, codeInvalidDifficulty)
+	consensus.RegisterErrorCode(errWrongDifficulty, codeWrongDifficulty)
+	consensus.RegisterErrorCode(errInvalidTimestamp, codeInvalidTimestamp)
+	consensus.RegisterErrorCode(errInvalidVotingChain, codeInvalidVotingChain)
+	consensus.RegisterErrorCode(errUnauthorizedSigner, codeUnauthorizedSigner)
+	consensus.RegisterErrorCode(errRecentlySigned, codeRecentlySigned)
+}
@@ -455,6 +455,8 @@ func TestClique(t *testing.T) {
 			t.Errorf("test %d: failed to create test chain: %v", i, err)
 			continue
 		}
+		defer chain.Stop()
+
 		failed := false
 		for j := 0; j < len(batches)-1; j++ {
 			if k, err := chain.InsertChain(batches[j]); err != nil {
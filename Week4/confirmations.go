@@ -0,0 +1,278 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Confirmations returns how many canonical blocks have been built on top of the
+// block with the given hash, along with the total difficulty accumulated by
+// those blocks. A block that is the current head has zero confirmations.
+func Confirmations(chain ChainHeaderReader, hash common.Hash) (uint64, *big.Int, error) {
+	header := chain.GetHeaderByHash(hash)
+	if header == nil {
+		return 0, nil, ErrUnknownBlock
+	}
+	number := header.Number.Uint64()
+	if canon := chain.GetHeaderByNumber(number); canon == nil || canon.Hash() != hash {
+		return 0, nil, ErrNonCanonical
+	}
+	head := chain.CurrentHeader()
+	if head.Number.Uint64() < number {
+		return 0, nil, ErrNonCanonical
+	}
+	var (
+		headTd  = chain.GetTd(head.Hash(), head.Number.Uint64())
+		blockTd = chain.GetTd(hash, number)
+	)
+	if headTd == nil || blockTd == nil {
+		return 0, nil, ErrUnknownBlock
+	}
+	return head.Number.Uint64() - number, new(big.Int).Sub(headTd, blockTd), nil
+}
+
+// maxConfirmations caps the depth searched by RequiredConfirmations, beyond which
+// a block is considered impossible to secure against the attacker.
+const maxConfirmations = 4096
+
+// AttackProbability returns the probability that an attacker controlling the
+// given share of the total hashrate ever catches up with the honest chain from
+// depth confirmations behind, i.e. manages to replace a block confirmed that
+// deep. It's the calculation of section 11 of the Bitcoin whitepaper: the
+// attacker's progress while the honest miners produce the confirmations is
+// Poisson distributed, after which it's a gambler's ruin.
+func AttackProbability(share float64, depth uint64) float64 {
+	if share <= 0 {
+		return 0
+	}
+	honest := 1 - share
+	if share >= honest || depth == 0 {
+		return 1
+	}
+	var (
+		ratio   = share / honest
+		lambda  = float64(depth) * ratio
+		success = 1.0
+	)
+	for k := uint64(0); k <= depth; k++ {
+		// Probability of the attacker having mined k blocks meanwhile, evaluated
+		// in log space not to underflow at large depths
+		lgamma, _ := math.Lgamma(float64(k + 1))
+		poisson := math.Exp(float64(k)*math.Log(lambda) - lambda - lgamma)
+
+		success -= poisson * (1 - math.Pow(ratio, float64(depth-k)))
+	}
+	if success < 0 {
+		return 0
+	}
+	return success
+}
+
+// RequiredConfirmations returns the least confirmation depth at which the
+// probability of an attacker with the given hashrate share replacing a block
+// drops below risk. Majority attackers can't be outrun, for which the method
+// returns false.
+func RequiredConfirmations(share float64, risk float64) (uint64, bool) {
+	for depth := uint64(0); depth <= maxConfirmations; depth++ {
+		if AttackProbability(share, depth) < risk {
+			return depth, true
+		}
+	}
+	return 0, false
+}
+
+// confirmationWatch is a single pending request to be notified once a block
+// reaches a given confirmation depth.
+type confirmationWatch struct {
+	depth uint64
+	ch    chan uint64
+}
+
+// ConfirmationWatcher tracks blocks that are awaiting a certain confirmation
+// depth. It doesn't subscribe to chain events by itself, rather the owner has to
+// feed it each new chain head through Notify.
+type ConfirmationWatcher struct {
+	chain   ChainHeaderReader
+	watches map[common.Hash][]*confirmationWatch
+	lock    sync.Mutex
+}
+
+// NewConfirmationWatcher creates a watcher resolving confirmations against the
+// given chain.
+func NewConfirmationWatcher(chain ChainHeaderReader) *ConfirmationWatcher {
+	return &ConfirmationWatcher{
+		chain:   chain,
+		watches: make(map[common.Hash][]*confirmationWatch),
+	}
+}
+
+// Watch registers interest in the block with the given hash reaching depth
+// confirmations. The returned channel receives the confirmation count once and
+// is then closed. If the block is already deep enough, it fires immediately.
+func (w *ConfirmationWatcher) Watch(hash common.Hash, depth uint64) <-chan uint64 {
+	// Evaluate under the lock, so that a head notified concurrently is either
+	// seen here or sees the registered watch
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	ch := make(chan uint64, 1)
+	if confs, _, err := Confirmations(w.chain, hash); err == nil && confs >= depth {
+		ch <- confs
+		close(ch)
+		return ch
+	}
+	w.watches[hash] = append(w.watches[hash], &confirmationWatch{depth: depth, ch: ch})
+	return ch
+}
+
+// Unwatch drops every pending watch on the given block, closing their channels
+// without delivering a value.
+func (w *ConfirmationWatcher) Unwatch(hash common.Hash) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, watch := range w.watches[hash] {
+		close(watch.ch)
+	}
+	delete(w.watches, hash)
+}
+
+// cancel drops a single pending watch on the given block, closing its channel
+// without delivering a value. Watches already fired are ignored.
+func (w *ConfirmationWatcher) cancel(hash common.Hash, ch <-chan uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	watches := w.watches[hash]
+	for i, watch := range watches {
+		if (<-chan uint64)(watch.ch) == ch {
+			close(watch.ch)
+			watches = append(watches[:i], watches[i+1:]...)
+			break
+		}
+	}
+	if len(watches) == 0 {
+		delete(w.watches, hash)
+	} else {
+		w.watches[hash] = watches
+	}
+}
+
+// Notify re-evaluates all pending watches against the new chain head. Blocks
+// that were reorged out simply stay pending until they become canonical again
+// or are unwatched.
+func (w *ConfirmationWatcher) Notify(head *types.Header) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for hash, watches := range w.watches {
+		header := w.chain.GetHeaderByHash(hash)
+		if header == nil || header.Number.Uint64() > head.Number.Uint64() {
+			continue
+		}
+		if canon := w.chain.GetHeaderByNumber(header.Number.Uint64()); canon == nil || canon.Hash() != hash {
+			continue
+		}
+		confs := head.Number.Uint64() - header.Number.Uint64()
+
+		pending := watches[:0]
+		for _, watch := range watches {
+			if confs >= watch.depth {
+				watch.ch <- confs
+				close(watch.ch)
+				continue
+			}
+			pending = append(pending, watch)
+		}
+		if len(pending) == 0 {
+			delete(w.watches, hash)
+		} else {
+			w.watches[hash] = pending
+		}
+	}
+}
+
+// APIs returns the RPC APIs exposing the confirmations of blocks, with their
+// subscriptions served by the watcher. The watcher still has to be fed the new
+// chain heads by its owner. A nil watcher has none.
+func (w *ConfirmationWatcher) APIs() []rpc.API {
+	if w == nil {
+		return nil
+	}
+	return []rpc.API{{
+		Namespace: "consensus",
+		Version:   "1.0",
+		Service:   &ConfirmationsAPI{watcher: w},
+		Public:    true,
+	}}
+}
+
+// ConfirmationsResult is the confirmation depth of a block, as reported over RPC.
+type ConfirmationsResult struct {
+	Confirmations   hexutil.Uint64 `json:"confirmations"`
+	TotalDifficulty *hexutil.Big   `json:"totalDifficulty"`
+}
+
+// ConfirmationsAPI exposes the confirmations of blocks for the RPC interface.
+type ConfirmationsAPI struct {
+	watcher *ConfirmationWatcher
+}
+
+// Confirmations returns how many canonical blocks have been built on top of the
+// block with the given hash, along with the total difficulty they accumulated.
+func (api *ConfirmationsAPI) Confirmations(hash common.Hash) (*ConfirmationsResult, error) {
+	confs, td, err := Confirmations(api.watcher.chain, hash)
+	if err != nil {
+		return nil, WithErrorCode(err)
+	}
+	return &ConfirmationsResult{Confirmations: hexutil.Uint64(confs), TotalDifficulty: (*hexutil.Big)(td)}, nil
+}
+
+// Confirmed creates a subscription notified once, with the confirmation count,
+// when the block with the given hash reaches depth confirmations. To wait for a
+// transaction, subscribe to the block hash of its receipt.
+func (api *ConfirmationsAPI) Confirmed(ctx context.Context, hash common.Hash, depth hexutil.Uint64) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	sub := notifier.CreateSubscription()
+	confirmed := api.watcher.Watch(hash, uint64(depth))
+
+	go func() {
+		defer api.watcher.cancel(hash, confirmed)
+
+		select {
+		case confs, ok := <-confirmed:
+			if ok {
+				notifier.Notify(sub.ID, hexutil.Uint64(confs))
+			}
+		case <-sub.Err():
+		case <-notifier.Closed():
+		}
+	}()
+	return sub, nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// extend adds n headers on top of parent to the chain, tagged with the given
+// extra-data to tell branches apart.
+func extend(chain *headerbuilder.MemoryChain, parent *types.Header, n int, tag byte) []*types.Header {
+	headers := headerbuilder.Chain(parent, n, headerbuilder.WithDifficulty(big.NewInt(2)), headerbuilder.WithExtra([]byte{tag}))
+	chain.Insert(headers...)
+	return headers
+}
+
+// Tests that confirmations count the canonical blocks on top of a block along
+// with their difficulty, and that reorged out blocks are reported.
+func TestConfirmations(t *testing.T) {
+	genesis := headerbuilder.New()
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, genesis)
+	blocks := extend(chain, genesis, 5, 0)
+
+	tests := []struct {
+		header *types.Header
+		confs  uint64
+		td     int64
+	}{
+		{blocks[4], 0, 0}, // Head
+		{blocks[2], 2, 4},
+		{blocks[0], 4, 8},
+		{genesis, 5, 10}, // Genesis
+	}
+	for i, tt := range tests {
+		confs, td, err := consensus.Confirmations(chain, tt.header.Hash())
+		if err != nil {
+			t.Fatalf("test %d: failed to count confirmations: %v", i, err)
+		}
+		if confs != tt.confs || td.Int64() != tt.td {
+			t.Errorf("test %d: confirmations mismatch: have %d/%v, want %d/%d", i, confs, td, tt.confs, tt.td)
+		}
+	}
+	if _, _, err := consensus.Confirmations(chain, common.Hash{0x01}); !errors.Is(err, consensus.ErrUnknownBlock) {
+		t.Errorf("unknown block: have %v, want %v", err, consensus.ErrUnknownBlock)
+	}
+	// Reorg the last three blocks out, they must no longer count as confirmed
+	side := extend(chain, blocks[1], 4, 1)
+	if _, _, err := consensus.Confirmations(chain, blocks[3].Hash()); !errors.Is(err, consensus.ErrNonCanonical) {
+		t.Errorf("reorged block: have %v, want %v", err, consensus.ErrNonCanonical)
+	}
+	if confs, _, err := consensus.Confirmations(chain, blocks[1].Hash()); err != nil || confs != 4 {
+		t.Errorf("fork point: have %d (%v), want 4", confs, err)
+	}
+	if confs, _, err := consensus.Confirmations(chain, side[0].Hash()); err != nil || confs != 3 {
+		t.Errorf("new branch: have %d (%v), want 3", confs, err)
+	}
+}
+
+// Tests that watches fire once their block is deep enough, not before, survive
+// reorgs until the block is canonical again, and close on Unwatch.
+func TestConfirmationWatcher(t *testing.T) {
+	genesis := headerbuilder.New()
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, genesis)
+	blocks := extend(chain, genesis, 3, 0)
+	watcher := consensus.NewConfirmationWatcher(chain)
+
+	// A block already deep enough fires immediately
+	if confs, ok := <-watcher.Watch(blocks[0].Hash(), 2); !ok || confs != 2 {
+		t.Errorf("immediate watch: have %d (%v), want 2", confs, ok)
+	}
+	deep := watcher.Watch(blocks[2].Hash(), 2)
+	dropped := watcher.Watch(blocks[2].Hash(), 5)
+
+	// One block short, nothing must be delivered
+	extend(chain, blocks[2], 1, 0)
+	watcher.Notify(chain.CurrentHeader())
+	select {
+	case confs := <-deep:
+		t.Fatalf("watch fired early with %d confirmations", confs)
+	default:
+	}
+	// Reorg the watched block out, still nothing must be delivered
+	side := extend(chain, blocks[1], 3, 1)
+	watcher.Notify(chain.CurrentHeader())
+	select {
+	case confs := <-deep:
+		t.Fatalf("watch fired on a reorged block with %d confirmations", confs)
+	default:
+	}
+	// Reorg back onto a longer original branch, the watch must fire once
+	extend(chain, blocks[2], 3, 0)
+	watcher.Notify(chain.CurrentHeader())
+	if confs, ok := <-deep; !ok || confs != 3 {
+		t.Errorf("watch after reorg: have %d (%v), want 3", confs, ok)
+	}
+	if _, ok := <-deep; ok {
+		t.Errorf("watch channel not closed after delivery")
+	}
+	// Unwatching closes the remaining watch without a value
+	watcher.Unwatch(blocks[2].Hash())
+	if confs, ok := <-dropped; ok {
+		t.Errorf("unwatched channel delivered %d", confs)
+	}
+	// Blocks of the abandoned branch stay pending
+	orphaned := watcher.Watch(side[2].Hash(), 0)
+	select {
+	case confs := <-orphaned:
+		t.Errorf("watch fired on a non-canonical block with %d confirmations", confs)
+	default:
+	}
+}
+
+// Tests that the confirmations and their subscriptions are served over RPC.
+func TestConfirmationsAPI(t *testing.T) {
+	leakcheck.Check(t)
+
+	genesis := headerbuilder.New()
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, genesis)
+	blocks := extend(chain, genesis, 3, 0)
+	watcher := consensus.NewConfirmationWatcher(chain)
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	for _, api := range watcher.APIs() {
+		if err := server.RegisterName(api.Namespace, api.Service); err != nil {
+			t.Fatalf("failed to register api: %v", err)
+		}
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var result consensus.ConfirmationsResult
+	if err := client.Call(&result, "consensus_confirmations", blocks[0].Hash()); err != nil {
+		t.Fatalf("failed to retrieve confirmations: %v", err)
+	}
+	if result.Confirmations != 2 || result.TotalDifficulty.ToInt().Int64() != 4 {
+		t.Errorf("confirmations mismatch: have %d (td %v), want 2 (td 4)", result.Confirmations, result.TotalDifficulty)
+	}
+	err := client.Call(&result, "consensus_confirmations", common.Hash{0x01})
+	if rpcErr, ok := err.(rpc.Error); !ok || rpcErr.ErrorCode() != consensus.ErrorCode(consensus.ErrUnknownBlock) {
+		t.Errorf("unknown block: have %v, want code %d", err, consensus.ErrorCode(consensus.ErrUnknownBlock))
+	}
+	// Subscribe to the head reaching two confirmations, and to a block that won't
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	confirmed := make(chan hexutil.Uint64, 1)
+	sub, err := client.Subscribe(ctx, "consensus", confirmed, "confirmed", blocks[2].Hash(), hexutil.Uint64(2))
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer sub.Unsubscribe()
+
+	pending, err := client.Subscribe(ctx, "consensus", make(chan hexutil.Uint64), "confirmed", blocks[2].Hash(), hexutil.Uint64(10))
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	pending.Unsubscribe()
+
+	extend(chain, blocks[2], 2, 0)
+	watcher.Notify(chain.CurrentHeader())
+	select {
+	case confs := <-confirmed:
+		if confs != 2 {
+			t.Errorf("notified confirmations mismatch: have %d, want 2", confs)
+		}
+	case err := <-sub.Err():
+		t.Fatalf("subscription failed: %v", err)
+	case <-ctx.Done():
+		t.Fatalf("confirmation not notified")
+	}
+}
+
+// Tests the attacker success probabilities against the results published in
+// section 11 of the Bitcoin whitepaper.
+func TestAttackProbability(t *testing.T) {
+	tests := []struct {
+		share float64
+		depth uint64
+		want  float64
+	}{
+		{0.1, 0, 1},
+		{0.1, 1, 0.2045873},
+		{0.1, 2, 0.0509779},
+		{0.1, 5, 0.0009137},
+		{0.1, 10, 0.0000012},
+		{0.3, 5, 0.1773523},
+		{0.3, 10, 0.0416605},
+		{0.3, 50, 0.0000006},
+		{0, 1, 0},
+		{0.5, 100, 1},
+		{0.6, 100, 1},
+	}
+	for i, tt := range tests {
+		if have := consensus.AttackProbability(tt.share, tt.depth); math.Abs(have-tt.want) > 5e-8 {
+			t.Errorf("test %d: probability mismatch: have %.7f, want %.7f", i, have, tt.want)
+		}
+	}
+}
+
+// Tests the confirmation depths required for a risk below 0.1%, as published in
+// section 11 of the Bitcoin whitepaper.
+func TestRequiredConfirmations(t *testing.T) {
+	tests := []struct {
+		share float64
+		depth uint64
+		ok    bool
+	}{
+		{0.10, 5, true},
+		{0.15, 8, true},
+		{0.20, 11, true},
+		{0.25, 15, true},
+		{0.30, 24, true},
+		{0.35, 41, true},
+		{0.40, 89, true},
+		{0.45, 340, true},
+		{0.50, 0, false},
+	}
+	for i, tt := range tests {
+		depth, ok := consensus.RequiredConfirmations(tt.share, 0.001)
+		if depth != tt.depth || ok != tt.ok {
+			t.Errorf("test %d: depth mismatch: have %d (%v), want %d (%v)", i, depth, ok, tt.depth, tt.ok)
+		}
+	}
+}
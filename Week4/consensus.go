@@ -119,3 +119,13 @@ type PoW interface {
 	// Hashrate mengembalikan hashrate penambangan saat ini dari mesin konsensus PoW.
 	Hashrate() float64
 }
+
+// Finalizer adalah mesin konsensus yang menyediakan finalitas, yaitu blok yang
+// tidak dapat dibatalkan lagi oleh reorganisasi rantai.
+type Finalizer interface {
+	Engine
+
+	// Finalized mengembalikan header terbaru yang sudah final, atau nil jika
+	// belum ada blok yang final.
+	Finalized(chain ChainHeaderReader) *types.Header
+}
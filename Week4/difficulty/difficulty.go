@@ -0,0 +1,286 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package difficulty implements difficulty adjustment algorithms shared by the
+// proof-of-work engines.
+package difficulty
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	expDiffPeriod = 100000 // Number of blocks after which the difficulty bomb doubles
+
+	defaultHomesteadPeriod = 10 // Adjustment step of the Homestead rules in seconds
+	defaultByzantiumPeriod = 9  // Adjustment step of the Byzantium rules in seconds
+	defaultLWMAPeriod      = 15 // Target seconds between blocks of the LWMA algorithm
+	defaultLWMAWindow      = 60 // Number of blocks averaged by the LWMA algorithm
+
+	defaultRetargetPeriod     = 15   // Target seconds between blocks of the windowed retargeting
+	defaultRetargetWindow     = 2016 // Number of blocks between retargets, as on Bitcoin
+	defaultRetargetAdjustment = 4    // Maximum factor of a single retarget, as on Bitcoin
+)
+
+// Some weird constants to avoid constant memory allocs for them.
+var (
+	big1       = big.NewInt(1)
+	big2       = big.NewInt(2)
+	bigMinus99 = big.NewInt(-99)
+)
+
+// Calculator is a difficulty adjustment algorithm. It returns the difficulty
+// that a new block should have when created at time on top of the given parent,
+// or nil if ancestors needed for the calculation are not available.
+type Calculator func(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int
+
+// Algorithm selects one of the difficulty adjustment algorithms.
+type Algorithm uint
+
+const (
+	AlgorithmHomestead Algorithm = iota // EIP-2 adjustment on the time since the parent
+	AlgorithmByzantium                  // EIP-100 adjustment, also accounting for the parent's uncles
+	AlgorithmLWMA                       // Linearly weighted moving average of recent solve times
+	AlgorithmRetarget                   // Bitcoin style retargeting at fixed windows of blocks
+)
+
+// Config are the configuration parameters of a difficulty adjustment algorithm.
+type Config struct {
+	Algorithm     Algorithm
+	Period        uint64   // Adjustment step (Homestead, Byzantium) or target block time (LWMA, Retarget) in seconds
+	BombDelay     *big.Int // Number of blocks to delay the difficulty bomb by, nil disables it
+	Window        uint64   // Number of blocks averaged (LWMA) or between retargets (Retarget)
+	MaxAdjustment uint64   // Maximum factor the difficulty may change by per retarget
+	MinDifficulty *big.Int // Lowest difficulty the adjustment may produce
+}
+
+// New creates the difficulty calculator selected by the config, filling in
+// defaults for any missing parameter.
+func New(config Config) Calculator {
+	if config.MinDifficulty == nil {
+		config.MinDifficulty = params.MinimumDifficulty
+	}
+	switch config.Algorithm {
+	case AlgorithmByzantium:
+		if config.Period == 0 {
+			config.Period = defaultByzantiumPeriod
+		}
+		return Byzantium(config.Period, config.BombDelay, config.MinDifficulty)
+	case AlgorithmLWMA:
+		if config.Period == 0 {
+			config.Period = defaultLWMAPeriod
+		}
+		if config.Window == 0 {
+			config.Window = defaultLWMAWindow
+		}
+		return LWMA(config.Window, config.Period, config.MinDifficulty)
+	case AlgorithmRetarget:
+		if config.Period == 0 {
+			config.Period = defaultRetargetPeriod
+		}
+		if config.Window < 2 {
+			config.Window = defaultRetargetWindow
+		}
+		if config.MaxAdjustment < 2 {
+			config.MaxAdjustment = defaultRetargetAdjustment
+		}
+		return Retarget(config.Window, config.Period, config.MaxAdjustment, config.MinDifficulty)
+	default:
+		if config.Period == 0 {
+			config.Period = defaultHomesteadPeriod
+		}
+		return Homestead(config.Period, config.BombDelay, config.MinDifficulty)
+	}
+}
+
+// Homestead returns the EIP-2 difficulty adjustment with the given step:
+//
+//	diff = max(parent_diff + parent_diff // 2048 * max(1 - (time - parent_time) // period, -99), floor) + bomb
+//
+// The difficulty bomb is delayed by the given number of blocks, or disabled if
+// the delay is nil. Ethereum's Homestead rules use a period of 10 seconds and
+// no delay.
+func Homestead(period uint64, bombDelay, floor *big.Int) Calculator {
+	return func(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+		return adjust(time, parent, period, big1, bombDelay, floor)
+	}
+}
+
+// Byzantium returns the EIP-100 difficulty adjustment with the given step,
+// which favours parents that included uncles:
+//
+//	diff = max(parent_diff + parent_diff // 2048 * max((2 if parent_uncles else 1) - (time - parent_time) // period, -99), floor) + bomb
+//
+// The difficulty bomb is delayed by the given number of blocks, or disabled if
+// the delay is nil. Ethereum's Byzantium and later rules use a period of 9
+// seconds, with ever longer delays.
+func Byzantium(period uint64, bombDelay, floor *big.Int) Calculator {
+	return func(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+		base := big1
+		if parent.UncleHash != types.EmptyUncleHash {
+			base = big2
+		}
+		return adjust(time, parent, period, base, bombDelay, floor)
+	}
+}
+
+// adjust implements the common body of the Homestead style adjustments. The
+// timestamps are subtracted as signed integers and divided with Euclidean
+// division, as in the reference implementation, so a timestamp before the
+// parent's (allowed by some timestamp rules) gives the same result everywhere.
+func adjust(time uint64, parent *types.Header, period uint64, base, bombDelay, floor *big.Int) *big.Int {
+	// max(base - (block_timestamp - parent_timestamp) // period, -99)
+	x := new(big.Int).Sub(new(big.Int).SetUint64(time), new(big.Int).SetUint64(parent.Time))
+	x.Div(x, new(big.Int).SetUint64(period))
+	x.Sub(base, x)
+	if x.Cmp(bigMinus99) < 0 {
+		x.Set(bigMinus99)
+	}
+	// Apply the step and the floor before the exponential factor
+	diff := misc.Clamp(misc.AdjustDifficulty(parent.Difficulty, params.DifficultyBoundDivisor, x), floor, nil)
+	if bombDelay != nil {
+		diff.Add(diff, bomb(parent, bombDelay))
+	}
+	return diff
+}
+
+// bomb returns the exponential factor of the difficulty, commonly referred to
+// as "the bomb", for the block after the given parent:
+//
+//	bomb = 2^(fake_number // 100000 - 2)
+//
+// where the fake block number lags behind the real one by the given delay, as
+// specified in https://eips.ethereum.org/EIPS/eip-1234.
+func bomb(parent *types.Header, delay *big.Int) *big.Int {
+	// The parent number is one below the block number, so remove one from the delay
+	delayFromParent := new(big.Int).Sub(delay, big1)
+
+	fakeBlockNumber := new(big.Int)
+	if parent.Number.Cmp(delayFromParent) >= 0 {
+		fakeBlockNumber.Sub(parent.Number, delayFromParent)
+	}
+	periodCount := fakeBlockNumber.Uint64() / expDiffPeriod
+	if periodCount < 2 {
+		return new(big.Int)
+	}
+	return misc.Exp2(periodCount - 2)
+}
+
+// LWMA returns a linearly weighted moving average adjustment, retargeting every
+// block on the solve times of the last window blocks, with recent blocks
+// weighing more:
+//
+//	diff = sum(difficulties) // window * spacing * k // sum(i * solvetime_i)
+//
+// where k = window * (window + 1) / 2 normalises the weights. Solve times are
+// clamped to [1, 6 * spacing] so that a single bogus timestamp cannot swing the
+// difficulty, and the result to ten times the average difficulty, bounding the
+// rise after a burst of fast blocks. Close to genesis the average is taken over
+// the available blocks.
+func LWMA(window, spacing uint64, floor *big.Int) Calculator {
+	return func(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+		n := window
+		if number := parent.Number.Uint64(); number < n {
+			n = number
+		}
+		if n == 0 {
+			return misc.Clamp(parent.Difficulty, floor, nil)
+		}
+		// Gather the last n blocks along with the one preceding them, oldest first
+		headers := make([]*types.Header, n+1)
+		headers[n] = parent
+		for i := n; i > 0; i-- {
+			headers[i-1] = chain.GetHeader(headers[i].ParentHash, headers[i].Number.Uint64()-1)
+			if headers[i-1] == nil {
+				return nil
+			}
+		}
+		var (
+			weighted = new(big.Int)
+			total    = new(big.Int)
+			limit    = 6 * spacing
+		)
+		for i := uint64(1); i <= n; i++ {
+			solvetime := uint64(1)
+			if headers[i].Time > headers[i-1].Time {
+				solvetime = headers[i].Time - headers[i-1].Time
+			}
+			if solvetime > limit {
+				solvetime = limit
+			}
+			weighted.Add(weighted, new(big.Int).Mul(new(big.Int).SetUint64(i), new(big.Int).SetUint64(solvetime)))
+			total.Add(total, headers[i].Difficulty)
+		}
+		// diff = total * k * spacing // (n * weighted), at most ten times the average
+		var (
+			k     = new(big.Int).SetUint64(n * (n + 1) / 2)
+			upper = misc.MulDiv(total, 10, n)
+		)
+		diff := new(big.Int).Mul(total, k)
+		diff.Mul(diff, new(big.Int).SetUint64(spacing))
+		diff.Quo(diff, weighted.Mul(weighted, new(big.Int).SetUint64(n)))
+		diff = misc.Clamp(diff, nil, upper)
+		return misc.Clamp(diff, floor, nil)
+	}
+}
+
+// Retarget returns a Bitcoin style adjustment, where the difficulty stays
+// constant within a window of blocks and is only retargeted at the first block
+// of a window, scaling the parent's difficulty by the ratio of expected to
+// actual time the previous window took:
+//
+//	diff = clamp(parent_diff * expected / actual, parent_diff / max, parent_diff * max)
+//
+// The result never drops below the given floor.
+func Retarget(window, spacing, maxAdjustment uint64, floor *big.Int) Calculator {
+	return func(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+		next := parent.Number.Uint64() + 1
+		if next%window != 0 || next < window {
+			return misc.Clamp(parent.Difficulty, floor, nil)
+		}
+		// Walk back to the first block of the window that just closed
+		first := parent
+		for i := uint64(1); i < window; i++ {
+			first = chain.GetHeader(first.ParentHash, first.Number.Uint64()-1)
+			if first == nil {
+				return nil
+			}
+		}
+		var (
+			expected = new(big.Int).Mul(new(big.Int).SetUint64(window-1), new(big.Int).SetUint64(spacing))
+			actual   = new(big.Int)
+			upper    = misc.MulDiv(parent.Difficulty, maxAdjustment, 1)
+			lower    = misc.MulDiv(parent.Difficulty, 1, maxAdjustment)
+		)
+		// Timestamps may go backwards under the median-time-past rule, which must
+		// not wrap around into a huge timespan
+		if parent.Time > first.Time {
+			actual.SetUint64(parent.Time - first.Time)
+		}
+		// Scale by the timespan ratio, clamping to avoid wild swings
+		diff := new(big.Int).Set(upper)
+		if actual.Sign() > 0 {
+			diff.Mul(parent.Difficulty, expected)
+			diff.Div(diff, actual)
+		}
+		return misc.Clamp(misc.Clamp(diff, lower, upper), floor, nil)
+	}
+}
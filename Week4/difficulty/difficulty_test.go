@@ -0,0 +1,194 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package difficulty
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests the Homestead and Byzantium adjustments against hand computed values.
+func TestHomesteadByzantium(t *testing.T) {
+	floor := big.NewInt(100000)
+	parent := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(2048000), Time: 1000, UncleHash: types.EmptyUncleHash}
+	uncled := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(2048000), Time: 1000, UncleHash: common.Hash{0x01}}
+
+	tests := []struct {
+		calc   Calculator
+		parent *types.Header
+		time   uint64
+		want   int64
+	}{
+		{Homestead(10, nil, floor), parent, 1005, 2049000},               // Fast block, +1 step
+		{Homestead(10, nil, floor), parent, 1010, 2048000},               // On target
+		{Homestead(10, nil, floor), parent, 1025, 2047000},               // Slow block, -1 step
+		{Homestead(10, nil, floor), parent, 5000, 1949000},               // Very slow block, capped at -99 steps
+		{Homestead(10, nil, floor), parent, 990, 2050000},                // Timestamp before the parent, signed arithmetic
+		{Homestead(20, nil, floor), parent, 1025, 2048000},               // Longer period
+		{Byzantium(9, nil, floor), parent, 1009, 2048000},                // On target
+		{Byzantium(9, nil, floor), uncled, 1009, 2049000},                // Uncles raise the difficulty
+		{Byzantium(9, nil, floor), uncled, 1018, 2048000},                // Uncles, on target
+		{Homestead(10, nil, big.NewInt(3000000)), parent, 1010, 3000000}, // Floor
+	}
+	for i, tt := range tests {
+		if have := tt.calc(nil, tt.time, tt.parent); have.Int64() != tt.want {
+			t.Errorf("test %d: difficulty mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// Tests that the difficulty bomb doubles every 100000 blocks after the delay.
+func TestBomb(t *testing.T) {
+	tests := []struct {
+		parent uint64
+		delay  int64
+		want   int64
+	}{
+		{0, 0, 0},
+		{199998, 0, 0},
+		{199999, 0, 1}, // Block 200000 sets the bomb off
+		{299999, 0, 2},
+		{999999, 0, 256},
+		{999999, 500000, 8}, // Fake number of 500000
+		{499999, 500000, 0}, // Still delayed
+	}
+	for i, tt := range tests {
+		parent := &types.Header{Number: new(big.Int).SetUint64(tt.parent)}
+		if have := bomb(parent, big.NewInt(tt.delay)); have.Int64() != tt.want {
+			t.Errorf("test %d: bomb mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// newTestChain creates a chain of the given length with a constant difficulty,
+// whose blocks are spaced by the given number of seconds.
+func newTestChain(n int, spacing uint64, diff int64) (*headerbuilder.MemoryChain, []*types.Header) {
+	headers := []*types.Header{{Number: big.NewInt(0), Difficulty: big.NewInt(diff), Time: 1000, UncleHash: types.EmptyUncleHash}}
+	for i := 1; i < n; i++ {
+		parent := headers[i-1]
+		headers = append(headers, &types.Header{
+			ParentHash: parent.Hash(),
+			UncleHash:  types.EmptyUncleHash,
+			Number:     big.NewInt(int64(i)),
+			Difficulty: big.NewInt(diff),
+			Time:       parent.Time + spacing,
+		})
+	}
+	return headerbuilder.NewMemoryChain(params.TestChainConfig, headers...), headers
+}
+
+// Tests that the moving average keeps the difficulty on target, scales it by the
+// ratio of target to actual solve times, and bounds the swings.
+func TestLWMA(t *testing.T) {
+	tests := []struct {
+		spacing uint64
+		want    int64
+	}{
+		{15, 1000000},  // On target, difficulty unchanged
+		{30, 500000},   // Twice as slow, difficulty halves
+		{5, 3000000},   // Three times as fast, difficulty triples
+		{1, 10000000},  // Way too fast, bounded to tenfold
+		{1000, 166666}, // Way too slow, solve times clamped to six times the target
+	}
+	calc := LWMA(10, 15, big.NewInt(1))
+	for i, tt := range tests {
+		chain, headers := newTestChain(20, tt.spacing, 1000000)
+		if have := calc(chain, 0, headers[19]); have.Int64() != tt.want {
+			t.Errorf("test %d: difficulty mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+	// Close to genesis, the available blocks are averaged
+	chain, headers := newTestChain(4, 30, 1000000)
+	if have := calc(chain, 0, headers[3]); have.Int64() != 500000 {
+		t.Errorf("short chain difficulty mismatch: have %v, want %v", have, 500000)
+	}
+	if have := calc(chain, 0, headers[0]); have.Int64() != 1000000 {
+		t.Errorf("genesis difficulty mismatch: have %v, want %v", have, 1000000)
+	}
+	// Missing ancestors must be reported instead of guessed
+	orphan := headerbuilder.NewMemoryChain(params.TestChainConfig, headers[3])
+	if have := calc(orphan, 0, headers[3]); have != nil {
+		t.Errorf("missing ancestors: have %v, want nil", have)
+	}
+}
+
+// Tests that windowed retargeting keeps the difficulty constant inside a window
+// and scales it by the expected/actual timespan ratio at the boundary.
+func TestRetarget(t *testing.T) {
+	tests := []struct {
+		spacing uint64
+		want    int64
+	}{
+		{10, 1000000}, // On target, difficulty unchanged
+		{5, 2000000},  // Twice as fast, difficulty doubles
+		{20, 500000},  // Twice as slow, difficulty halves
+		{1, 4000000},  // Way too fast, clamped to 4x
+		{100, 250000}, // Way too slow, clamped to 1/4x
+	}
+	calc := Retarget(4, 10, 4, params.MinimumDifficulty)
+	for i, tt := range tests {
+		chain, headers := newTestChain(4, tt.spacing, 1000000)
+
+		// Blocks inside the window inherit the parent's difficulty
+		if have := calc(chain, 0, headers[2]); have.Int64() != 1000000 {
+			t.Errorf("test %d: in-window difficulty mismatch: have %v, want %v", i, have, 1000000)
+		}
+		// The first block of the next window is retargeted
+		if have := calc(chain, 0, headers[3]); have.Int64() != tt.want {
+			t.Errorf("test %d: retarget difficulty mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+	// Missing ancestors must be reported instead of guessed
+	_, headers := newTestChain(4, 10, 1000000)
+	orphan := headerbuilder.NewMemoryChain(params.TestChainConfig, headers[3])
+	if have := calc(orphan, 0, headers[3]); have != nil {
+		t.Errorf("missing ancestors: have %v, want nil", have)
+	}
+}
+
+// Tests that the config selects the right algorithm and fills in defaults.
+func TestNew(t *testing.T) {
+	chain, headers := newTestChain(2, 12, 2048000)
+	parent := headers[1]
+
+	tests := []struct {
+		config Config
+		want   int64
+	}{
+		{Config{}, 2048000}, // Homestead, 10s period
+		{Config{Algorithm: AlgorithmByzantium}, 2048000}, // Byzantium, 9s period
+		{Config{Algorithm: AlgorithmByzantium, Period: 20}, 2049000},
+		{Config{Algorithm: AlgorithmLWMA}, 2560000}, // 15s target, 12s blocks
+		{Config{Algorithm: AlgorithmLWMA, Period: 12}, 2048000},
+		{Config{Algorithm: AlgorithmRetarget}, 2048000},                       // Inside the default window
+		{Config{Algorithm: AlgorithmRetarget, Window: 2, Period: 6}, 1024000}, // Twice as slow, halves
+		{Config{MinDifficulty: big.NewInt(5000000)}, 5000000},
+	}
+	for i, tt := range tests {
+		if have := New(tt.config)(chain, parent.Time+12, parent); have.Int64() != tt.want {
+			t.Errorf("test %d: difficulty mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+	if have := New(Config{})(chain, parent.Time+1000, &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1)}); have.Cmp(params.MinimumDifficulty) != 0 {
+		t.Errorf("default floor mismatch: have %v, want %v", have, params.MinimumDifficulty)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// API is a user facing RPC API to inspect the delegates and their performance
+// in the delegated proof-of-stake scheme.
+type API struct {
+	chain consensus.ChainHeaderReader
+	dpos  *DPoS
+}
+
+// Standing is the track record of a delegate within the current epoch.
+type Standing struct {
+	Delegate common.Address `json:"delegate"`
+	Produced uint64         `json:"produced"` // Blocks produced in the epoch so far
+	Missed   uint64         `json:"missed"`   // Slots left empty in the epoch so far
+}
+
+// header retrieves the requested block header (or the current if none requested).
+func (api *API) header(number *rpc.BlockNumber) (*types.Header, error) {
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	return header, nil
+}
+
+// GetDelegates retrieves the delegates producing the child of the specified block.
+func (api *API) GetDelegates(number *rpc.BlockNumber) ([]common.Address, error) {
+	header, err := api.header(number)
+	if err != nil {
+		return nil, consensus.WithErrorCode(err)
+	}
+	delegates, err := api.dpos.delegates(api.chain, header, nil)
+	return delegates, consensus.WithErrorCode(err)
+}
+
+// GetStandings retrieves the blocks produced and the slots missed by each of the
+// delegates, from the start of the epoch up to the specified block.
+func (api *API) GetStandings(number *rpc.BlockNumber) ([]Standing, error) {
+	header, err := api.header(number)
+	if err != nil {
+		return nil, consensus.WithErrorCode(err)
+	}
+	delegates, err := api.dpos.delegates(api.chain, header, nil)
+	if err != nil {
+		return nil, consensus.WithErrorCode(err)
+	}
+	var (
+		produced = make(map[common.Address]uint64)
+		missed   = make(map[common.Address]uint64)
+	)
+	for header.Number.Uint64()%api.dpos.config.Epoch != 0 {
+		parent := api.chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+		if parent == nil {
+			return nil, consensus.WithErrorCode(consensus.ErrUnknownAncestor)
+		}
+		if producer, err := api.dpos.Author(header); err == nil {
+			produced[producer]++
+		}
+		for delegate, n := range missedSlots(delegates, api.dpos.slot(parent), api.dpos.slot(header)) {
+			missed[delegate] += n
+		}
+		header = parent
+	}
+	standings := make([]Standing, len(delegates))
+	for i, delegate := range delegates {
+		standings[i] = Standing{Delegate: delegate, Produced: produced[delegate], Missed: missed[delegate]}
+	}
+	return standings, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// maxCandidates is the maximum number of candidates read from the election.
+const maxCandidates = 1024
+
+// errInvalidDelegates is returned if a delegate list cannot be decoded.
+var errInvalidDelegates = errors.New("invalid delegate list")
+
+// decodeDelegates parses the delegate list embedded in a checkpoint header.
+func decodeDelegates(data []byte) ([]common.Address, error) {
+	if len(data) == 0 || len(data)%common.AddressLength != 0 {
+		return nil, errInvalidDelegates
+	}
+	var (
+		delegates = make([]common.Address, len(data)/common.AddressLength)
+		seen      = make(map[common.Address]bool)
+	)
+	for i := range delegates {
+		copy(delegates[i][:], data[i*common.AddressLength:])
+		if seen[delegates[i]] {
+			return nil, errInvalidDelegates
+		}
+		seen[delegates[i]] = true
+	}
+	return delegates, nil
+}
+
+// encodeDelegates serializes the delegate list for embedding into a checkpoint
+// header.
+func encodeDelegates(delegates []common.Address) []byte {
+	data := make([]byte, 0, len(delegates)*common.AddressLength)
+	for _, delegate := range delegates {
+		data = append(data, delegate[:]...)
+	}
+	return data
+}
+
+// slotDelegate returns the delegate entitled to produce the block of a slot.
+func slotDelegate(delegates []common.Address, slot uint64) common.Address {
+	return delegates[slot%uint64(len(delegates))]
+}
+
+// missedSlots returns the number of slots each delegate failed to fill between
+// two consecutive blocks at the given slots.
+func missedSlots(delegates []common.Address, parentSlot, slot uint64) map[common.Address]uint64 {
+	missed := make(map[common.Address]uint64)
+	if slot <= parentSlot+1 {
+		return missed
+	}
+	var (
+		n    = uint64(len(delegates))
+		gap  = slot - parentSlot - 1
+		full = gap / n // Rounds in which every delegate missed its slot
+	)
+	for i := uint64(0); i < n && i < gap; i++ {
+		missed[slotDelegate(delegates, parentSlot+1+i)] = full
+	}
+	for i := uint64(0); i < gap%n; i++ {
+		missed[slotDelegate(delegates, parentSlot+1+i)]++
+	}
+	return missed
+}
+
+// The election is an account whose storage holds the delegate candidates along
+// with the votes of the token holders, typically tallied by a voting contract:
+//
+//	slot 0                    number of candidates n
+//	slot 1..n                 address of the i-th candidate
+//	slot keccak(address)      votes received by the candidate
+//	slot keccak(address, 1)   slots missed by the candidate as a delegate
+//
+// votesSlot returns the storage slot holding the votes of a candidate.
+func votesSlot(address common.Address) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(address[:], common.HashLength))
+}
+
+// missedSlot returns the storage slot holding the missed slots of a candidate.
+func missedSlot(address common.Address) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(address[:], common.HashLength), common.LeftPadBytes([]byte{1}, common.HashLength))
+}
+
+// readElection returns the top candidates by votes in the given account, most
+// voted first with ties broken by address. Candidates without votes are never
+// elected.
+func readElection(state *state.StateDB, election common.Address, delegates int) []common.Address {
+	count := state.GetState(election, common.Hash{}).Big()
+	if count.Cmp(big.NewInt(maxCandidates)) > 0 {
+		count.SetInt64(maxCandidates)
+	}
+	type candidate struct {
+		address common.Address
+		votes   *big.Int
+	}
+	var (
+		candidates = make([]candidate, 0, count.Uint64())
+		seen       = make(map[common.Address]bool)
+	)
+	for i := uint64(1); i <= count.Uint64(); i++ {
+		address := common.BytesToAddress(state.GetState(election, common.BigToHash(new(big.Int).SetUint64(i))).Bytes())
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		if votes := state.GetState(election, votesSlot(address)).Big(); votes.Sign() > 0 {
+			candidates = append(candidates, candidate{address: address, votes: votes})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if c := candidates[i].votes.Cmp(candidates[j].votes); c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(candidates[i].address[:], candidates[j].address[:]) < 0
+	})
+	if len(candidates) > delegates {
+		candidates = candidates[:delegates]
+	}
+	elected := make([]common.Address, len(candidates))
+	for i, c := range candidates {
+		elected[i] = c.address
+	}
+	return elected
+}
+
+// penalize adds missed slots to the record of a delegate.
+func penalize(state *state.StateDB, election common.Address, delegate common.Address, missed uint64) {
+	slot := missedSlot(delegate)
+	total := new(big.Int).Add(state.GetState(election, slot).Big(), new(big.Int).SetUint64(missed))
+	state.SetState(election, slot, common.BigToHash(total))
+}
@@ -0,0 +1,595 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package dpos implements a delegated proof-of-stake consensus engine.
+//
+// Token holders vote for delegate candidates in an election account, and at the
+// start of every epoch the most voted candidates become the delegates producing
+// blocks in turn, one per time slot. The delegate list is committed to in the
+// extra-data of the epoch checkpoint block, so headers can be verified without
+// access to the state. As the election result only exists once the checkpoint
+// is processed, the commitment is checked in Finalize, which makes mismatching
+// checkpoints fail their state root check. Slots left empty by their delegate
+// are recorded in the election account as penalties, for the voters to act upon.
+package dpos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemoryDelegateSets = 128  // Number of recent delegate sets to keep in memory
+	inmemorySignatures   = 4096 // Number of recent block signatures to keep in memory
+
+	defaultPeriod    = 3   // Default number of seconds of a slot
+	defaultEpoch     = 600 // Default number of blocks after which to elect the delegates again
+	defaultDelegates = 21  // Default number of delegates producing blocks
+)
+
+// Delegated proof-of-stake protocol constants.
+var (
+	extraVanity = 32                     // Fixed number of extra-data prefix bytes reserved for vanity
+	extraSeal   = crypto.SignatureLength // Fixed number of extra-data suffix bytes reserved for the seal
+
+	defaultDifficulty = big.NewInt(1)            // Every block counts the same, the chain filling most slots wins
+	nilUncleHash      = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the delegate list is requested for a block
+	// that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errMissingSignature is returned if a block's extra-data section doesn't seem
+	// to contain a 65 byte secp256k1 signature.
+	errMissingSignature = errors.New("extra-data 65 byte signature suffix missing")
+
+	// errExtraDelegates is returned if a non-checkpoint block contains delegate
+	// data in its extra-data field.
+	errExtraDelegates = errors.New("non-checkpoint block contains extra delegate list")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is not at the
+	// start of a slot following the previous block's.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errWrongDelegate is returned if a block is sealed by another account than
+	// the delegate of its slot.
+	errWrongDelegate = errors.New("wrong delegate for slot")
+
+	// errNotDelegate is returned if the local signer is asked to seal a block
+	// while not being an active delegate.
+	errNotDelegate = errors.New("not an active delegate")
+)
+
+// Config are the configuration parameters of the delegated proof-of-stake engine.
+type Config struct {
+	Period    uint64         // Number of seconds of a slot
+	Epoch     uint64         // Number of blocks after which to elect the delegates again
+	Delegates int            // Number of delegates producing blocks
+	Election  common.Address // Account holding the candidates and votes (none if zero)
+
+	// When set, the penalties recorded by finalized blocks are also kept in it.
+	Ledger *ledger.Ledger `json:"-"`
+}
+
+// SignerFn hashes and signs the data to be signed by a backing account.
+type SignerFn func(signer accounts.Account, mimeType string, message []byte) ([]byte, error)
+
+// DPoS is a delegated proof-of-stake consensus engine. Time is divided in slots
+// of a fixed period, each assigned round-robin to one of the delegates of the
+// epoch, who may only produce a block at the start of its own slot.
+type DPoS struct {
+	config Config
+
+	sets       *lru.ARCCache // Delegate lists of recent blocks, keyed by the block hash
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer fields
+}
+
+// New creates a delegated proof-of-stake consensus engine. The initial delegates
+// are taken from the extra-data of the genesis block.
+func New(config Config) *DPoS {
+	if config.Period == 0 {
+		config.Period = defaultPeriod
+	}
+	if config.Epoch == 0 {
+		config.Epoch = defaultEpoch
+	}
+	if config.Delegates == 0 {
+		config.Delegates = defaultDelegates
+	}
+	sets, _ := lru.NewARC(inmemoryDelegateSets)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+
+	return &DPoS{
+		config:     config,
+		sets:       sets,
+		signatures: signatures,
+	}
+}
+
+// ecrecover extracts the Ethereum account address from a signed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, errMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	// Recover the public key and the Ethereum address
+	pubkey, err := crypto.Ecrecover(clique.SealHash(header).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// slot returns the slot a header was produced in.
+func (d *DPoS) slot(header *types.Header) uint64 {
+	return header.Time / d.config.Period
+}
+
+// Author implements consensus.Engine, returning the Ethereum address recovered
+// from the signature in the header's extra-data section.
+func (d *DPoS) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, d.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (d *DPoS) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return d.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (d *DPoS) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	return consensus.VerifyHeadersSequential(headers, func(i int) error {
+		return d.verifyHeader(chain, headers[i], headers[:i])
+	})
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (d *DPoS) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	// Check that the extra-data contains the vanity, delegates and signature
+	if len(header.Extra) < extraVanity {
+		return errMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return errMissingSignature
+	}
+	delegates := header.Extra[extraVanity : len(header.Extra)-extraSeal]
+	checkpoint := number%d.config.Epoch == 0
+	if !checkpoint && len(delegates) != 0 {
+		return errExtraDelegates
+	}
+	if checkpoint {
+		if _, err := decodeDelegates(delegates); err != nil {
+			return err
+		}
+	}
+	// Ensure the fields meaningless for delegated consensus are left empty
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	if header.UncleHash != nilUncleHash {
+		return errInvalidUncleHash
+	}
+	if number > 0 && (header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0) {
+		return errInvalidDifficulty
+	}
+	// Verify that the gas limit is <= 2^63-1
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, params.MaxGasLimit)
+	}
+	// All basic checks passed, verify cascading fields
+	return d.verifyCascadingFields(chain, header, parents)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers.
+func (d *DPoS) verifyCascadingFields(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	// Ensure that the block is produced at the start of a later slot
+	if header.Time%d.config.Period != 0 || d.slot(header) <= d.slot(parent) {
+		return errInvalidTimestamp
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, want <nil>", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// Retrieve the delegates of the epoch and verify the producer of the slot
+	delegates, err := d.delegates(chain, parent, parents)
+	if err != nil {
+		return err
+	}
+	// Without an election the delegates never change, so checkpoints can be
+	// checked right away. Otherwise they are checked against the state in
+	// Finalize.
+	if number%d.config.Epoch == 0 && d.config.Election == (common.Address{}) {
+		if !bytes.Equal(header.Extra[extraVanity:len(header.Extra)-extraSeal], encodeDelegates(delegates)) {
+			return errInvalidDelegates
+		}
+	}
+	signer, err := ecrecover(header, d.signatures)
+	if err != nil {
+		return err
+	}
+	if signer != slotDelegate(delegates, d.slot(header)) {
+		return errWrongDelegate
+	}
+	return nil
+}
+
+// delegates retrieves the delegates in charge of producing the child of the given
+// parent, as committed to by the most recent checkpoint at or before it. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (d *DPoS) delegates(chain consensus.ChainHeaderReader, parent *types.Header, parents []*types.Header) ([]common.Address, error) {
+	var (
+		header  = parent
+		visited []common.Hash
+	)
+	for {
+		// If the delegates are already known for this header, use those
+		if delegates, ok := d.sets.Get(header.Hash()); ok {
+			return d.cacheDelegates(delegates.([]common.Address), visited), nil
+		}
+		visited = append(visited, header.Hash())
+
+		// If we've reached a checkpoint, decode the delegates from it
+		number := header.Number.Uint64()
+		if number%d.config.Epoch == 0 {
+			if len(header.Extra) < extraVanity+extraSeal {
+				return nil, errMissingSignature
+			}
+			delegates, err := decodeDelegates(header.Extra[extraVanity : len(header.Extra)-extraSeal])
+			if err != nil {
+				return nil, err
+			}
+			return d.cacheDelegates(delegates, visited), nil
+		}
+		// Otherwise step back to the parent, preferring the batch if available
+		for len(parents) > 0 && parents[len(parents)-1].Number.Uint64() >= number {
+			parents = parents[:len(parents)-1]
+		}
+		if len(parents) > 0 && parents[len(parents)-1].Hash() == header.ParentHash {
+			header = parents[len(parents)-1]
+		} else {
+			header = chain.GetHeader(header.ParentHash, number-1)
+		}
+		if header == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+	}
+}
+
+// cacheDelegates remembers the delegates for all the given headers.
+func (d *DPoS) cacheDelegates(delegates []common.Address, hashes []common.Hash) []common.Address {
+	for _, hash := range hashes {
+		d.sets.Add(hash, delegates)
+	}
+	return delegates
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (d *DPoS) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top. The block is scheduled for the
+// next slot of the local signer.
+func (d *DPoS) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	delegates, err := d.delegates(chain, parent, nil)
+	if err != nil {
+		return err
+	}
+	d.lock.RLock()
+	signer := d.signer
+	d.lock.RUnlock()
+
+	// Find the first slot of the signer that hasn't passed yet
+	slot := d.slot(parent) + 1
+	if now := uint64(time.Now().Unix()) / d.config.Period; slot < now {
+		slot = now
+	}
+	for i := 0; i < len(delegates); i++ {
+		if slotDelegate(delegates, slot+uint64(i)) == signer {
+			slot += uint64(i)
+			break
+		}
+	}
+	header.Time = slot * d.config.Period
+
+	// Ensure the extra data has all its components, the delegate list of
+	// checkpoints is filled in once the state is known
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	header.Extra = append(header.Extra[:extraVanity], make([]byte, extraSeal)...)
+	return nil
+}
+
+// checkpointDelegates returns the delegates a checkpoint block must commit to:
+// the most voted candidates, or the current delegates if nobody was voted for.
+func (d *DPoS) checkpointDelegates(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB) ([]common.Address, error) {
+	if d.config.Election != (common.Address{}) {
+		if elected := readElection(state, d.config.Election, d.config.Delegates); len(elected) > 0 {
+			return elected, nil
+		}
+	}
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	return d.delegates(chain, parent, nil)
+}
+
+// Finalize implements consensus.Engine, recording the slots missed since the
+// parent block against their delegates. Checkpoints whose delegate list doesn't
+// match the election are made to fail their state root check. No block rewards
+// are given.
+func (d *DPoS) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	entries := d.finalize(chain, header, state)
+	d.config.Ledger.Record(d, header, entries)
+}
+
+// finalize checks the checkpoint commitment, applies the penalties and commits
+// the final state root, returning the ledger entries of the penalties. They are
+// only recorded once the header is complete, as they are keyed by its seal hash.
+func (d *DPoS) finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB) []ledger.Entry {
+	var entries []ledger.Entry
+	if election := d.config.Election; election != (common.Address{}) {
+		record := func(delegate common.Address, missed uint64) {
+			entries = append(entries, ledger.Entry{Account: delegate, Kind: ledger.KindPenalty, Amount: new(big.Int).SetUint64(missed)})
+		}
+		parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+		if parent != nil {
+			if delegates, err := d.delegates(chain, parent, nil); err == nil {
+				// Walk the delegates in order, keeping the recorded entries deterministic
+				missed := missedSlots(delegates, d.slot(parent), d.slot(header))
+				for _, delegate := range delegates {
+					if count, ok := missed[delegate]; ok {
+						penalize(state, election, delegate, count)
+						if count > 0 {
+							record(delegate, count)
+						}
+						delete(missed, delegate)
+					}
+				}
+			}
+		}
+		// Reject checkpoints not committing to the election result
+		if header.Number.Uint64()%d.config.Epoch == 0 {
+			want, err := d.checkpointDelegates(chain, header, state)
+			if err != nil || len(header.Extra) < extraVanity+extraSeal || !bytes.Equal(header.Extra[extraVanity:len(header.Extra)-extraSeal], encodeDelegates(want)) {
+				misc.RejectBlock(state, header)
+			}
+		}
+	}
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = nilUncleHash
+	return entries
+}
+
+// FinalizeAndAssemble implements consensus.Engine, committing to the elected
+// delegates on checkpoint blocks and returns the final block.
+func (d *DPoS) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	if header.Number.Uint64()%d.config.Epoch == 0 {
+		delegates, err := d.checkpointDelegates(chain, header, state)
+		if err != nil {
+			return nil, err
+		}
+		extra := append(common.CopyBytes(header.Extra[:extraVanity]), encodeDelegates(delegates)...)
+		header.Extra = append(extra, make([]byte, extraSeal)...)
+	}
+	// Finalize block
+	entries := d.finalize(chain, header, state)
+
+	// Assemble and return the final block for sealing
+	block := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+	d.config.Ledger.Record(d, block.Header(), entries)
+	return block, nil
+}
+
+// Authorize injects a private key into the consensus engine to produce new
+// blocks with.
+func (d *DPoS) Authorize(signer common.Address, signFn SignerFn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.signer = signer
+	d.signFn = signFn
+}
+
+// Seal implements consensus.Engine, attempting to create a sealed block using
+// the local signing credentials.
+func (d *DPoS) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	// Don't hold the signer fields for the entire sealing procedure
+	d.lock.RLock()
+	signer, signFn := d.signer, d.signFn
+	d.lock.RUnlock()
+
+	// Bail out if the slot isn't ours
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	delegates, err := d.delegates(chain, parent, nil)
+	if err != nil {
+		return err
+	}
+	if slotDelegate(delegates, d.slot(header)) != signer {
+		return errNotDelegate
+	}
+	// Sign the header and wait for the slot to begin
+	sighash, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeClique, clique.CliqueRLP(header))
+	if err != nil {
+		return err
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
+
+	delay := time.Until(time.Unix(int64(header.Time), 0))
+	log.Trace("Waiting for slot to sign and propagate", "delay", common.PrettyDuration(delay))
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		select {
+		case results <- block.WithSeal(header):
+		default:
+			log.Warn("Sealing result is not read by miner", "sealhash", clique.SealHash(header))
+		}
+	}()
+	return nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// that a new block should have, which is always 1 as the chain filling the most
+// slots is the canonical one.
+func (d *DPoS) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (d *DPoS) SealHash(header *types.Header) common.Hash {
+	return clique.SealHash(header)
+}
+
+// Close implements consensus.Engine. It's a noop as there are no background threads.
+func (d *DPoS) Close() error {
+	return nil
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs.
+func (d *DPoS) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	apis := []rpc.API{{
+		Namespace: "dpos",
+		Version:   "1.0",
+		Service:   &API{chain: chain, dpos: d},
+		Public:    true,
+	}}
+	return append(apis, d.config.Ledger.APIs(chain, d)...)
+}
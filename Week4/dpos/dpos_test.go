@@ -0,0 +1,214 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// newTestDelegates creates n delegate keys and a genesis header at the start of
+// a slot, listing them in order.
+func newTestDelegates(n int, period uint64) ([]*ecdsa.PrivateKey, *types.Header) {
+	keys := make([]*ecdsa.PrivateKey, n)
+	delegates := make([]common.Address, n)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		delegates[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	extra := append(make([]byte, extraVanity), encodeDelegates(delegates)...)
+	genesis := headerbuilder.New(
+		headerbuilder.WithTime((uint64(time.Now().Unix())-1000)/period*period),
+		headerbuilder.WithExtra(append(extra, make([]byte, extraSeal)...)),
+	)
+	return keys, genesis
+}
+
+// Tests that the slots skipped between two blocks are charged to the right
+// delegates, wrapping around the delegate list.
+func TestMissedSlots(t *testing.T) {
+	a, b, c := common.Address{0x0a}, common.Address{0x0b}, common.Address{0x0c}
+	delegates := []common.Address{a, b, c}
+
+	tests := []struct {
+		parent, slot uint64
+		missed       map[common.Address]uint64
+	}{
+		{3, 4, map[common.Address]uint64{}},
+		{3, 5, map[common.Address]uint64{b: 1}},
+		{3, 6, map[common.Address]uint64{b: 1, c: 1}},
+		{2, 6, map[common.Address]uint64{a: 1, b: 1, c: 1}},
+		{2, 13, map[common.Address]uint64{a: 4, b: 3, c: 3}},
+	}
+	for i, tt := range tests {
+		if missed := missedSlots(delegates, tt.parent, tt.slot); !reflect.DeepEqual(missed, tt.missed) {
+			t.Errorf("test %d: missed slots mismatch: have %v, want %v", i, missed, tt.missed)
+		}
+	}
+}
+
+// Tests that blocks are only accepted from the delegate of their slot, at the
+// start of a slot after the parent's.
+func TestVerifySlots(t *testing.T) {
+	keys, genesis := newTestDelegates(3, 3)
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+	engine := New(Config{Period: 3, Epoch: 100})
+
+	slot := genesis.Time / 3
+	tests := []struct {
+		time uint64
+		key  *ecdsa.PrivateKey
+		err  error
+	}{
+		{(slot + 1) * 3, keys[(slot+1)%3], nil},
+		{(slot + 2) * 3, keys[(slot+2)%3], nil},
+		{(slot + 1) * 3, keys[(slot+2)%3], errWrongDelegate},
+		{(slot+1)*3 + 1, keys[(slot+1)%3], errInvalidTimestamp},
+		{slot * 3, keys[slot%3], errInvalidTimestamp},
+	}
+	for i, tt := range tests {
+		header := headerbuilder.New(
+			headerbuilder.WithParent(genesis),
+			headerbuilder.WithTime(tt.time),
+			headerbuilder.SignedBy(tt.key, clique.SealHash),
+		)
+		if err := engine.VerifyHeader(chain, header, true); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that the standings reported through the API count the blocks produced
+// and the slots missed by each delegate since the start of the epoch.
+func TestStandings(t *testing.T) {
+	keys, genesis := newTestDelegates(3, 3)
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+	engine := New(Config{Period: 3, Epoch: 100})
+
+	// Fill slots +1, +2 and +5, leaving +3 and +4 empty
+	slot := genesis.Time / 3
+	for _, offset := range []uint64{1, 2, 5} {
+		header := headerbuilder.New(
+			headerbuilder.WithParent(chain.CurrentHeader()),
+			headerbuilder.WithTime((slot+offset)*3),
+			headerbuilder.SignedBy(keys[(slot+offset)%3], clique.SealHash),
+		)
+		if err := engine.VerifyHeader(chain, header, true); err != nil {
+			t.Fatalf("failed to verify block at slot +%d: %v", offset, err)
+		}
+		chain.Insert(header)
+	}
+	standings, err := engine.APIs(chain)[0].Service.(*API).GetStandings(nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve standings: %v", err)
+	}
+	want := make([]Standing, 3)
+	for i, key := range keys {
+		want[i].Delegate = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	for _, offset := range []uint64{1, 2, 5} {
+		want[(slot+offset)%3].Produced++
+	}
+	for _, offset := range []uint64{3, 4} {
+		want[(slot+offset)%3].Missed++
+	}
+	if !reflect.DeepEqual(standings, want) {
+		t.Errorf("standings mismatch: have %v, want %v", standings, want)
+	}
+}
+
+// Tests that checkpoints must commit to the election result, and that any other
+// delegate list, including one naming only the producer, makes the block fail
+// its state root check.
+func TestVerifyCheckpoint(t *testing.T) {
+	keys, genesis := newTestDelegates(3, 3)
+	slot := genesis.Time/3 + 1
+	producer := keys[slot%3]
+
+	election := common.Address{0xee}
+	elected := crypto.PubkeyToAddress(keys[(slot+1)%3].PublicKey)
+
+	// Create a genesis state electing another delegate than the producer alone
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, db, nil)
+	statedb.SetState(election, common.Hash{}, common.BigToHash(big.NewInt(1)))
+	statedb.SetState(election, common.BigToHash(big.NewInt(1)), common.BytesToHash(elected.Bytes()))
+	statedb.SetState(election, votesSlot(elected), common.BigToHash(big.NewInt(10)))
+	root, _ := statedb.Commit(false)
+	genesis.Root = root
+
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+	engine := New(Config{Period: 3, Epoch: 1, Delegates: 1, Election: election})
+
+	tests := []struct {
+		commit []common.Address
+		valid  bool
+	}{
+		{[]common.Address{elected}, true},
+		{[]common.Address{crypto.PubkeyToAddress(producer.PublicKey)}, false},
+		{[]common.Address{crypto.PubkeyToAddress(keys[0].PublicKey), crypto.PubkeyToAddress(keys[1].PublicKey), crypto.PubkeyToAddress(keys[2].PublicKey)}, false},
+	}
+	for i, tt := range tests {
+		header := headerbuilder.New(
+			headerbuilder.WithParent(genesis),
+			headerbuilder.WithTime(slot*3),
+			headerbuilder.WithExtra(append(make([]byte, extraVanity), encodeDelegates(tt.commit)...)),
+			headerbuilder.SignedBy(producer, clique.SealHash),
+		)
+		if err := engine.VerifyHeader(chain, header, true); err != nil {
+			t.Fatalf("test %d: failed to verify header: %v", i, err)
+		}
+		// The block misses no slots, so it leaves the state untouched
+		header.Root = root
+		statedb, _ := state.New(root, db, nil)
+		engine.Finalize(chain, header, statedb, nil, nil)
+		if valid := header.Root == root; valid != tt.valid {
+			t.Errorf("test %d: state root validity mismatch: have %v, want %v", i, valid, tt.valid)
+		}
+	}
+	// Checkpoints assembled by the engine commit to the election result
+	header := headerbuilder.New(headerbuilder.WithParent(genesis), headerbuilder.WithTime(slot*3), headerbuilder.WithExtra(make([]byte, extraVanity)))
+	statedb, _ = state.New(root, db, nil)
+	block, err := engine.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to assemble checkpoint: %v", err)
+	}
+	if have, want := block.Extra()[extraVanity:len(block.Extra())-extraSeal], encodeDelegates([]common.Address{elected}); !bytes.Equal(have, want) {
+		t.Errorf("assembled delegates mismatch: have %x, want %x", have, want)
+	}
+	if block.Root() != root {
+		t.Errorf("assembled root mismatch: have %x, want %x", block.Root(), root)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package dpos
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the dpos specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeUnknownBlock      = -39501
+	codeMissingVanity     = -39502
+	codeMissingSignature  = -39503
+	codeExtraDelegates    = -39504
+	codeInvalidMixDigest  = -39505
+	codeInvalidUncleHash  = -39506
+	codeInvalidDifficulty = -39507
+	codeInvalidTimestamp  = -39508
+	codeWrongDelegate     = -39509
+	codeNotDelegate       = -39510
+)
+
+// Register stable numeric codes for the dpos specific errors.
+func init() {
+	consensus.RegisterErrorCode(errUnknownBlock, codeUnknownBlock)
+	consensus.RegisterErrorCode(errMissingVanity, codeMissingVanity)
+	consensus.RegisterErrorCode(errMissingSignature, codeMissingSignature)
+	consensus.RegisterErrorCode(errExtraDelegates, codeExtraDelegates)
+	consensus.RegisterErrorCode(errInvalidMixDigest, codeInvalidMixDigest)
+	consensus.RegisterErrorCode(errInvalidUncleHash, codeInvalidUncleHash)
+	consensus.RegisterErrorCode(errInvalidDifficulty, codeInvalidDifficulty)
+	consensus.RegisterErrorCode(errInvalidTimestamp, codeInvalidTimestamp)
+	consensus.RegisterErrorCode(errWrongDelegate, codeWrongDelegate)
+	consensus.RegisterErrorCode(errNotDelegate, codeNotDelegate)
+}
@@ -0,0 +1,208 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package engines registers every consensus engine of this tree with the
+// consensus package. Nodes selecting their engine at runtime import it for its
+// side effects:
+//
+//	import _ "github.com/ethereum/go-ethereum/consensus/engines"
+//
+// Engines wrapping another one, such as hybrid and beacon, name the wrapped
+// engine and its configuration in their own:
+//
+//	{"engine": "ethash", "config": {}, "epoch": 100, "validators": [...]}
+package engines
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/dpos"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/faker"
+	"github.com/ethereum/go-ethereum/consensus/hashcash"
+	"github.com/ethereum/go-ethereum/consensus/hybrid"
+	"github.com/ethereum/go-ethereum/consensus/ibft"
+	"github.com/ethereum/go-ethereum/consensus/poet"
+	"github.com/ethereum/go-ethereum/consensus/pos"
+	"github.com/ethereum/go-ethereum/consensus/raft"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	// errMissingDatabase is returned when an engine persisting data of its own is
+	// created without a chain database.
+	errMissingDatabase = errors.New("missing chain database")
+
+	// errNotPoW is returned when the hybrid engine is configured to wrap an engine
+	// that does not seal with proof-of-work.
+	errNotPoW = errors.New("wrapped engine is not proof-of-work")
+)
+
+// wrapped is the configuration of an engine wrapping another one.
+type wrapped struct {
+	Engine string          `json:"engine"` // Name of the wrapped engine
+	Config json.RawMessage `json:"config"` // Configuration of the wrapped engine
+}
+
+// factories are the consensus engines of this tree, by name.
+var factories = map[string]consensus.Factory{
+	"beacon":   newBeacon,
+	"clique":   newClique,
+	"dpos":     newDPoS,
+	"ethash":   newEthash,
+	"faker":    newFaker,
+	"hashcash": newHashcash,
+	"hybrid":   newHybrid,
+	"ibft":     newIBFT,
+	"poet":     newPoET,
+	"pos":      newPoS,
+	"raft":     newRaft,
+}
+
+func init() {
+	for name, factory := range factories {
+		consensus.Register(name, factory)
+	}
+}
+
+func newClique(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	if db == nil {
+		return nil, errMissingDatabase
+	}
+	conf := new(params.CliqueConfig)
+	if err := json.Unmarshal(config, conf); err != nil {
+		return nil, err
+	}
+	return clique.New(conf, db), nil
+}
+
+func newDPoS(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf dpos.Config
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	return dpos.New(conf), nil
+}
+
+func newEthash(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf ethash.Config
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	return ethash.New(conf, nil, false), nil
+}
+
+func newFaker(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	return faker.New(), nil
+}
+
+func newHashcash(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf hashcash.Config
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	return hashcash.New(conf), nil
+}
+
+func newIBFT(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf ibft.Config
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	return ibft.New(conf), nil
+}
+
+func newPoET(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf poet.Config
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	return poet.New(conf), nil
+}
+
+func newPoS(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf pos.Config
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	return pos.New(conf), nil
+}
+
+func newRaft(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf raft.Config
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	return raft.New(conf), nil
+}
+
+// newWrapped creates the engine named in the configuration of a wrapping engine.
+func newWrapped(db ethdb.Database, conf wrapped) (consensus.Engine, error) {
+	if conf.Engine == "" {
+		return nil, errors.New("missing wrapped engine")
+	}
+	return consensus.New(conf.Engine, db, conf.Config)
+}
+
+func newHybrid(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	if db == nil {
+		return nil, errMissingDatabase
+	}
+	var conf struct {
+		wrapped
+		hybrid.Config
+	}
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	engine, err := newWrapped(db, conf.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	pow, ok := engine.(consensus.PoW)
+	if !ok {
+		engine.Close()
+		return nil, fmt.Errorf("%w: %s", errNotPoW, conf.Engine)
+	}
+	return hybrid.New(pow, conf.Config, db), nil
+}
+
+func newBeacon(db ethdb.Database, config json.RawMessage) (consensus.Engine, error) {
+	var conf struct {
+		wrapped
+		Transition *uint64 `json:"transition"` // Block switching to beacon rules, TTD if unset
+	}
+	if err := json.Unmarshal(config, &conf); err != nil {
+		return nil, err
+	}
+	engine, err := newWrapped(db, conf.wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := engine.(*beacon.Beacon); ok {
+		return nil, errors.New("nested beacon engine")
+	}
+	if conf.Transition != nil {
+		return beacon.NewWithTransition(engine, *conf.Transition), nil
+	}
+	return beacon.New(engine), nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package engines
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/beacon"
+	"github.com/ethereum/go-ethereum/consensus/hybrid"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// Tests that every engine of the tree is registered and can be created from its
+// default configuration.
+func TestEngines(t *testing.T) {
+	registered := make(map[string]bool)
+	for _, name := range consensus.Engines() {
+		registered[name] = true
+	}
+	for name := range factories {
+		if !registered[name] {
+			t.Errorf("engine %q not registered", name)
+			continue
+		}
+		config := json.RawMessage(`{"engine": "faker"}`)
+		if name == "hybrid" {
+			config = json.RawMessage(`{"engine": "hashcash", "epoch": 10}`)
+		}
+		engine, err := consensus.New(name, rawdb.NewMemoryDatabase(), config)
+		if err != nil {
+			t.Errorf("%s: failed to create engine: %v", name, err)
+			continue
+		}
+		if err := engine.Close(); err != nil {
+			t.Errorf("%s: failed to close engine: %v", name, err)
+		}
+	}
+}
+
+// Tests that wrapping engines create the engine named in their configuration and
+// reject the ones they cannot wrap.
+func TestWrappedEngines(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+
+	engine, err := consensus.New("beacon", db, json.RawMessage(`{"engine": "ethash", "config": {"PowMode": 2}, "transition": 5}`))
+	if err != nil {
+		t.Fatalf("failed to create beacon engine: %v", err)
+	}
+	if _, ok := engine.(*beacon.Beacon); !ok {
+		t.Errorf("engine type mismatch: have %T, want *beacon.Beacon", engine)
+	}
+	engine.Close()
+
+	engine, err = consensus.New("hybrid", db, json.RawMessage(`{"engine": "hashcash", "epoch": 10}`))
+	if err != nil {
+		t.Fatalf("failed to create hybrid engine: %v", err)
+	}
+	if _, ok := engine.(*hybrid.Hybrid); !ok {
+		t.Errorf("engine type mismatch: have %T, want *hybrid.Hybrid", engine)
+	}
+	engine.Close()
+
+	tests := []struct {
+		name   string
+		config string
+		nodb   bool
+	}{
+		{"hybrid", `{"engine": "clique"}`, false},
+		{"hybrid", `{}`, false},
+		{"hybrid", `{"engine": "hashcash"}`, true},
+		{"beacon", `{"engine": "beacon", "config": {"engine": "faker"}}`, false},
+		{"beacon", `{"engine": "unknown"}`, false},
+		{"clique", `{}`, true},
+	}
+	for i, tt := range tests {
+		db := rawdb.NewMemoryDatabase()
+		if tt.nodb {
+			db = nil
+		}
+		if _, err := consensus.New(tt.name, db, json.RawMessage(tt.config)); err == nil {
+			t.Errorf("test %d: %s config %s accepted", i, tt.name, tt.config)
+		}
+	}
+}
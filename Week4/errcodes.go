@@ -0,0 +1,112 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrorCodeUnknown is the code reported for errors without a registered code.
+const ErrorCodeUnknown = -39000
+
+// errorCode pairs an error with its stable numeric code.
+type errorCode struct {
+	err  error
+	code int
+}
+
+var (
+	// errorCodes is the registry of numeric error codes, in registration order.
+	// The common consensus errors occupy -39001 to -39050 and the shared rules
+	// of the misc package -39051 to -39099. Engines register their own errors
+	// in blocks of a hundred below that: clique -391xx, ethash -392xx, hashcash
+	// -393xx, pos -394xx, dpos -395xx, poet -396xx, ibft -397xx, raft -398xx,
+	// hybrid -399xx and beacon -400xx.
+	errorCodes = []errorCode{
+		{ErrUnknownAncestor, -39001},
+		{ErrPrunedAncestor, -39002},
+		{ErrFutureBlock, -39003},
+		{ErrInvalidNumber, -39004},
+		{ErrUnknownBlock, -39005},
+		{ErrNonCanonical, -39006},
+		{ErrUnknownEngine, -39007},
+	}
+	errorCodesLock sync.RWMutex
+)
+
+// RegisterErrorCode assigns a stable numeric code to an error, so clients can
+// tell rejection reasons apart without parsing messages. Engines call it from
+// init for their private errors, and wrap the errors returned by their RPC APIs
+// with WithErrorCode. Registering an error or a code twice panics.
+func RegisterErrorCode(err error, code int) {
+	errorCodesLock.Lock()
+	defer errorCodesLock.Unlock()
+
+	for _, entry := range errorCodes {
+		if entry.err == err || entry.code == code {
+			panic(fmt.Sprintf("duplicate error code %d for %q", code, err))
+		}
+	}
+	errorCodes = append(errorCodes, errorCode{err: err, code: code})
+}
+
+// ErrorCode returns the registered code of the error, looking through wrapped
+// errors too. ErrorCodeUnknown is returned if no code is registered.
+func ErrorCode(err error) int {
+	errorCodesLock.RLock()
+	defer errorCodesLock.RUnlock()
+
+	for _, entry := range errorCodes {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+	return ErrorCodeUnknown
+}
+
+// CodedError is an error annotated with its registered numeric code. It
+// implements rpc.Error, so the code is reported to RPC clients.
+type CodedError struct {
+	err  error
+	code int
+}
+
+// WithErrorCode annotates an error with its registered code, or returns nil if
+// the error is nil.
+func WithErrorCode(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{err: err, code: ErrorCode(err)}
+}
+
+// Error implements error, returning the message of the underlying error.
+func (e *CodedError) Error() string {
+	return e.err.Error()
+}
+
+// ErrorCode implements rpc.Error, returning the numeric code of the error.
+func (e *CodedError) ErrorCode() int {
+	return e.code
+}
+
+// Unwrap returns the underlying error.
+func (e *CodedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Tests that registered codes are found through wrapped errors, and that the
+// coded error keeps both the message and the identity of the original.
+func TestErrorCode(t *testing.T) {
+	resetErrorCodes(t)
+
+	errCustom := errors.New("custom")
+	RegisterErrorCode(errCustom, -39999)
+
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{ErrUnknownAncestor, -39001},
+		{fmt.Errorf("wrapped: %w", ErrFutureBlock), -39003},
+		{errCustom, -39999},
+		{errors.New("unregistered"), ErrorCodeUnknown},
+	}
+	for i, tt := range tests {
+		if code := ErrorCode(tt.err); code != tt.code {
+			t.Errorf("test %d: code mismatch: have %d, want %d", i, code, tt.code)
+		}
+		coded := WithErrorCode(tt.err).(*CodedError)
+		if coded.ErrorCode() != tt.code || coded.Error() != tt.err.Error() || !errors.Is(coded, tt.err) {
+			t.Errorf("test %d: coded error mismatch: have %v (%d)", i, coded, coded.ErrorCode())
+		}
+	}
+	if WithErrorCode(nil) != nil {
+		t.Errorf("nil error annotated")
+	}
+}
+
+// Tests that registering an error or a code twice is rejected.
+func TestErrorCodeDuplicate(t *testing.T) {
+	resetErrorCodes(t)
+
+	errCustom := errors.New("custom")
+	RegisterErrorCode(errCustom, -39999)
+
+	for i, tt := range []struct {
+		err  error
+		code int
+	}{
+		{errCustom, -39998},           // Duplicate error
+		{errors.New("other"), -39999}, // Duplicate code
+		{errors.New("other"), -39001}, // Code of a common error
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("test %d: duplicate registration accepted", i)
+				}
+			}()
+			RegisterErrorCode(tt.err, tt.code)
+		}()
+	}
+}
+
+// resetErrorCodes restores the error code registry once the test finishes, so
+// the codes registered by the test don't leak into later runs.
+func resetErrorCodes(t *testing.T) {
+	errorCodesLock.RLock()
+	saved := append([]errorCode(nil), errorCodes...)
+	errorCodesLock.RUnlock()
+
+	t.Cleanup(func() {
+		errorCodesLock.Lock()
+		errorCodes = saved
+		errorCodesLock.Unlock()
+	})
+}
@@ -34,4 +34,16 @@ var (
 	// ErrInvalidNumber is returned if a block's number doesn't equal its parent's
 	// plus one.
 	ErrInvalidNumber = errors.New("invalid block number")
+
+	// ErrUnknownBlock is returned when a block referenced by hash is not known
+	// to the local chain.
+	ErrUnknownBlock = errors.New("unknown block")
+
+	// ErrNonCanonical is returned when an operation requires a block to be part
+	// of the canonical chain, but it's on a side chain.
+	ErrNonCanonical = errors.New("block not canonical")
+
+	// ErrUnknownEngine is returned when a consensus engine is requested by a
+	// name that no engine registered.
+	ErrUnknownEngine = errors.New("unknown consensus engine")
 )
@@ -28,6 +28,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
 	"github.com/ethereum/go-ethereum/core/types"
 )
 
@@ -697,6 +698,8 @@ func TestHashimoto(t *testing.T) {
 
 // Tests that caches generated on disk may be done concurrently.
 func TestConcurrentDiskCacheGeneration(t *testing.T) {
+	leakcheck.Check(t)
+
 	// Create a temp folder to generate the caches into
 	// TODO: t.TempDir fails to remove the directory on Windows
 	// \AppData\Local\Temp\1\TestConcurrentDiskCacheGeneration2382060137\001\cache-R23-1dca8a85e74aa763: Access is denied.
@@ -23,12 +23,21 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/consensus/difficulty"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 type diffTest struct {
@@ -139,6 +148,29 @@ func TestDifficultyCalculators(t *testing.T) {
 	}
 }
 
+// Tests that a difficulty bomb exponent beyond the width of a 32 bit uint gives
+// the same result as any other exponent overflowing 256 bits, instead of being
+// truncated into a small shift on 32 bit platforms.
+func TestDifficultyBombOverflow(t *testing.T) {
+	var (
+		overflow = new(big.Int).SetUint64(300*expDiffPeriodUint - 1)
+		huge     = new(big.Int).SetUint64(((1<<32)+2)*expDiffPeriodUint - 1)
+	)
+	for i, fn := range []func(time uint64, parent *types.Header) *big.Int{
+		CalcDifficultyFrontierU256,
+		CalcDifficultyHomesteadU256,
+		MakeDifficultyCalculatorU256(common.Big1),
+	} {
+		parent := &types.Header{Difficulty: big.NewInt(0xffffff), Number: overflow, Time: 1000000, UncleHash: types.EmptyUncleHash}
+		want := fn(1000014, parent)
+
+		parent.Number = huge
+		if have := fn(1000014, parent); have.Cmp(want) != 0 {
+			t.Errorf("calculator %d: difficulty mismatch: have %v, want %v", i, have, want)
+		}
+	}
+}
+
 func BenchmarkDifficultyCalculator(b *testing.B) {
 	x1 := makeDifficultyCalculator(big.NewInt(1000000))
 	x2 := MakeDifficultyCalculatorU256(big.NewInt(1000000))
@@ -186,3 +218,110 @@ func BenchmarkDifficultyCalculator(b *testing.B) {
 		}
 	})
 }
+
+// Tests that the ethash seal hash is the hash of the consensus encoding of
+// types.Header without the two seal fields (mix digest and nonce). This catches
+// drift if fields are ever added to the header without updating SealHash.
+func TestSealHashMatchesHeader(t *testing.T) {
+	ethash := NewFaker()
+	for _, baseFee := range []*big.Int{nil, big.NewInt(params.InitialBaseFee)} {
+		header := &types.Header{
+			ParentHash:  common.HexToHash("0x01"),
+			UncleHash:   types.EmptyUncleHash,
+			Coinbase:    common.HexToAddress("0x02"),
+			Root:        common.HexToHash("0x03"),
+			TxHash:      types.EmptyRootHash,
+			ReceiptHash: types.EmptyRootHash,
+			Difficulty:  big.NewInt(131072),
+			Number:      big.NewInt(100),
+			GasLimit:    8000000,
+			GasUsed:     21000,
+			Time:        1600000000,
+			Extra:       []byte("ethash"),
+			MixDigest:   common.HexToHash("0x04"),
+			Nonce:       types.EncodeNonce(5),
+			BaseFee:     baseFee,
+		}
+		blob, err := rlp.EncodeToBytes(header)
+		if err != nil {
+			t.Fatalf("failed to encode header: %v", err)
+		}
+		var fields []rlp.RawValue
+		if err := rlp.DecodeBytes(blob, &fields); err != nil {
+			t.Fatalf("failed to decode header: %v", err)
+		}
+		// Drop the mix digest and nonce, keeping any trailing fork fields
+		unsealed := append(append([]rlp.RawValue{}, fields[:13]...), fields[15:]...)
+		blob, err = rlp.EncodeToBytes(unsealed)
+		if err != nil {
+			t.Fatalf("failed to encode unsealed fields: %v", err)
+		}
+		if have, want := ethash.SealHash(header), crypto.Keccak256Hash(blob); have != want {
+			t.Errorf("seal hash mismatch (basefee %v): have %x, want %x", baseFee, have, want)
+		}
+	}
+}
+
+// Tests that the configured difficulty floor raises the stock calculators,
+// replaces the protocol minimum under retargeting, and is enforced on headers.
+func TestMinDifficulty(t *testing.T) {
+	parent := &types.Header{Number: big.NewInt(0), Difficulty: big.NewInt(1), Time: 1000}
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, parent)
+
+	// Without a floor, a chain starting at difficulty one jumps to the protocol minimum
+	ethash := NewFaker()
+	if diff := ethash.CalcDifficulty(chain, 1010, parent); diff.Cmp(params.MinimumDifficulty) != 0 {
+		t.Errorf("default floor mismatch: have %v, want %v", diff, params.MinimumDifficulty)
+	}
+	// A floor above the protocol minimum raises the stock calculators
+	ethash.config.MinDifficulty = big.NewInt(1000000)
+	if diff := ethash.CalcDifficulty(chain, 1010, parent); diff.Int64() != 1000000 {
+		t.Errorf("raised floor mismatch: have %v, want %v", diff, 1000000)
+	}
+	// Under retargeting, a floor below the protocol minimum lets test chains start low
+	ethash.config.MinDifficulty = big.NewInt(1)
+	ethash.config.Difficulty = &difficulty.Config{Algorithm: difficulty.AlgorithmRetarget, Window: 4, Period: 10, MaxAdjustment: 4}
+	if diff := ethash.CalcDifficulty(chain, 1010, parent); diff.Int64() != 1 {
+		t.Errorf("retarget floor mismatch: have %v, want %v", diff, 1)
+	}
+	// Headers below the floor are rejected outright
+	ethash.config.MinDifficulty = big.NewInt(1000000)
+	header := &types.Header{ParentHash: parent.Hash(), Number: big.NewInt(1), Difficulty: big.NewInt(999999), Time: 1010}
+	if err := ethash.verifyHeader(chain, header, parent, false, false, 1010); err == nil || !strings.HasPrefix(err.Error(), "difficulty below floor") {
+		t.Errorf("header below floor: have %v, want difficulty below floor", err)
+	}
+}
+
+// Tests that the rewards of a mined block are recorded under the sealed block,
+// and exposed through the ledger API.
+func TestLedgerSealedBlock(t *testing.T) {
+	genesis := headerbuilder.New(headerbuilder.WithDifficulty(big.NewInt(131072)))
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, genesis)
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+
+	ethash := NewFaker()
+	ethash.config.Ledger = ledger.New(0)
+
+	header := headerbuilder.New(headerbuilder.WithParent(genesis), headerbuilder.WithCoinbase(common.Address{0x01}))
+	block, err := ethash.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to assemble block: %v", err)
+	}
+	sealed := block.Header()
+	sealed.Nonce, sealed.MixDigest = types.EncodeNonce(1), common.Hash{0x02}
+	chain.Insert(sealed)
+
+	entries := ethash.config.Ledger.Block(chain, ethash, 1)
+	if len(entries) != 1 || entries[0].Hash != sealed.Hash() || entries[0].Amount.Cmp(ConstantinopleBlockReward) != 0 {
+		t.Fatalf("sealed block entries mismatch: have %v", entries)
+	}
+	apis := ethash.APIs(chain)
+	if len(apis) != 3 || apis[2].Namespace != "ledger" {
+		t.Fatalf("ledger API missing: have %v", apis)
+	}
+	number := rpc.BlockNumber(1)
+	have, err := apis[2].Service.(*ledger.API).Block(&number)
+	if err != nil || len(have) != 1 || have[0].Account != (common.Address{0x01}) || have[0].Kind != "block reward" {
+		t.Errorf("ledger API entries mismatch: have %v, %v", have, err)
+	}
+}
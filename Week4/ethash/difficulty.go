@@ -37,6 +37,17 @@ const (
 	difficultyBoundDivisor = 11
 )
 
+// bombShift converts the exponent of the difficulty bomb into a shift amount.
+// Shifting a 256 bit integer by 256 or more bits clears it regardless of the
+// amount, so capping the exponent there keeps the conversion to uint lossless
+// on 32 bit platforms without changing the result.
+func bombShift(exp uint64) uint {
+	if exp > 256 {
+		exp = 256
+	}
+	return uint(exp)
+}
+
 // CalcDifficultyFrontierU256 is the difficulty adjustment algorithm. It returns the
 // difficulty that a new block should have when created at time given the parent
 // block's time and difficulty. The calculation uses the Frontier rules.
@@ -70,7 +81,7 @@ func CalcDifficultyFrontierU256(time uint64, parent *types.Header) *big.Int {
 	if periodCount := (parent.Number.Uint64() + 1) / expDiffPeriodUint; periodCount > 1 {
 		// diff = diff + 2^(periodCount - 2)
 		expDiff := adjust.SetOne()
-		expDiff.Lsh(expDiff, uint(periodCount-2)) // expdiff: 2 ^ (periodCount -2)
+		expDiff.Lsh(expDiff, bombShift(periodCount-2)) // expdiff: 2 ^ (periodCount -2)
 		pDiff.Add(pDiff, expDiff)
 	}
 	return pDiff.ToBig()
@@ -122,7 +133,7 @@ func CalcDifficultyHomesteadU256(time uint64, parent *types.Header) *big.Int {
 	// for the exponential factor, a.k.a "the bomb"
 	// diff = diff + 2^(periodCount - 2)
 	if periodCount := (1 + parent.Number.Uint64()) / expDiffPeriodUint; periodCount > 1 {
-		expFactor := adjust.Lsh(adjust.SetOne(), uint(periodCount-2))
+		expFactor := adjust.Lsh(adjust.SetOne(), bombShift(periodCount-2))
 		pDiff.Add(pDiff, expFactor)
 	}
 	return pDiff.ToBig()
@@ -182,7 +193,7 @@ func MakeDifficultyCalculatorU256(bombDelay *big.Int) func(time uint64, parent *
 		if pNum >= bombDelayFromParent {
 			if fakeBlockNumber := pNum - bombDelayFromParent; fakeBlockNumber >= 2*expDiffPeriodUint {
 				z.SetOne()
-				z.Lsh(z, uint(fakeBlockNumber/expDiffPeriodUint-2))
+				z.Lsh(z, bombShift(fakeBlockNumber/expDiffPeriodUint-2))
 				y.Add(z, y)
 			}
 		}
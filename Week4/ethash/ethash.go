@@ -35,6 +35,9 @@ import (
 
 	"github.com/edsrzf/mmap-go"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/difficulty"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/consensus/misc"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/rpc"
@@ -412,6 +415,14 @@ const (
 	ModeFullFake
 )
 
+// TimestampRule defines which rule the timestamp of a header is checked with.
+type TimestampRule uint
+
+const (
+	TimestampParent     TimestampRule = iota // Timestamp must exceed the parent's
+	TimestampMedianPast                      // Timestamp must exceed the median of the last 11 blocks
+)
+
 // Config are the configuration parameters of the ethash.
 type Config struct {
 	CacheDir         string
@@ -428,6 +439,26 @@ type Config struct {
 	// be block header JSON objects instead of work package arrays.
 	NotifyFull bool
 
+	// TimestampRule selects how header timestamps are validated against
+	// their ancestors.
+	TimestampRule TimestampRule
+
+	// When set, difficulty is adjusted by the configured algorithm instead
+	// of the hard-fork rules, such as retargeting in fixed windows of blocks.
+	Difficulty *difficulty.Config
+
+	// When set, no block may have a difficulty below this floor. Configured
+	// algorithms use it in place of the protocol minimum, the stock
+	// calculators are only ever raised to it.
+	MinDifficulty *big.Int
+
+	// When set, overrides the block rewards derived from the hard-fork
+	// blocks of the chain configuration.
+	Rewards misc.RewardSchedule
+
+	// When set, the rewards credited by finalized blocks are recorded in it.
+	Ledger *ledger.Ledger `toml:"-" json:"-"`
+
 	Log log.Logger `toml:"-"`
 }
 
@@ -439,6 +470,8 @@ type Ethash struct {
 	caches   *lru // In memory caches to avoid regenerating too often
 	datasets *lru // In memory datasets to avoid regenerating too often
 
+	generating sync.WaitGroup // Future caches being generated in the background
+
 	// Mining related fields
 	rand     *rand.Rand    // Properly seeded random source for nonces
 	threads  int           // Number of threads to mine on if mining
@@ -549,7 +582,12 @@ func NewShared() *Ethash {
 
 // Close closes the exit channel to notify all backend threads exiting.
 func (ethash *Ethash) Close() error {
-	return ethash.StopRemoteSealer()
+	err := ethash.StopRemoteSealer()
+
+	// Wait for the future caches, so none is written to the cache directory
+	// after closing. Datasets may take minutes to generate, they are left be.
+	ethash.generating.Wait()
+	return err
 }
 
 // StopRemoteSealer stops the remote sealer
@@ -579,7 +617,11 @@ func (ethash *Ethash) cache(block uint64) *cache {
 	// If we need a new future cache, now's a good time to regenerate it.
 	if futureI != nil {
 		future := futureI.(*cache)
-		go future.generate(ethash.config.CacheDir, ethash.config.CachesOnDisk, ethash.config.CachesLockMmap, ethash.config.PowMode == ModeTest)
+		ethash.generating.Add(1)
+		go func() {
+			defer ethash.generating.Done()
+			future.generate(ethash.config.CacheDir, ethash.config.CachesOnDisk, ethash.config.CachesLockMmap, ethash.config.PowMode == ModeTest)
+		}()
 	}
 	return current
 }
@@ -675,7 +717,7 @@ func (ethash *Ethash) Hashrate() float64 {
 func (ethash *Ethash) APIs(chain consensus.ChainHeaderReader) []rpc.API {
 	// In order to ensure backward compatibility, we exposes ethash RPC APIs
 	// to both eth and ethash namespaces.
-	return []rpc.API{
+	apis := []rpc.API{
 		{
 			Namespace: "eth",
 			Version:   "1.0",
@@ -689,6 +731,7 @@ func (ethash *Ethash) APIs(chain consensus.ChainHeaderReader) []rpc.API {
 			Public:    true,
 		},
 	}
+	return append(apis, ethash.config.Ledger.APIs(chain, ethash)...)
 }
 
 // SeedHash is the seed to use for generating a verification cache and the mining
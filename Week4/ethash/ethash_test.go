@@ -17,6 +17,7 @@
 package ethash
 
 import (
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"math/rand"
@@ -27,11 +28,19 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
 // Tests that ethash works correctly in test mode.
 func TestTestMode(t *testing.T) {
+	leakcheck.Check(t)
+
 	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(100)}
 
 	ethash := NewTester(nil, false)
@@ -100,12 +109,16 @@ func verifyTest(wg *sync.WaitGroup, e *Ethash, workerIndex, epochs int) {
 }
 
 func TestRemoteSealer(t *testing.T) {
+	leakcheck.Check(t)
+
 	ethash := NewTester(nil, false)
 	defer ethash.Close()
 
 	api := &API{ethash}
-	if _, err := api.GetWork(); err != errNoMiningWork {
+	if _, err := api.GetWork(); !errors.Is(err, errNoMiningWork) {
 		t.Error("expect to return an error indicate there is no mining work")
+	} else if code := err.(rpc.Error).ErrorCode(); code != codeNoMiningWork {
+		t.Errorf("error code mismatch: have %d, want %d", code, codeNoMiningWork)
 	}
 	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(100)}
 	block := types.NewBlockWithHeader(header)
@@ -168,7 +181,7 @@ func TestClosedRemoteSealer(t *testing.T) {
 	ethash.Close()
 
 	api := &API{ethash}
-	if _, err := api.GetWork(); err != errEthashStopped {
+	if _, err := api.GetWork(); !errors.Is(err, errEthashStopped) {
 		t.Error("expect to return an error to indicate ethash is stopped")
 	}
 
@@ -0,0 +1,166 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package faker implements a consensus engine for tests, which seals blocks
+// instantly and accepts every header unless told otherwise.
+package faker
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// errFakeFailure is returned for headers programmed to fail without a specific
+// error.
+var errFakeFailure = errors.New("fake verification failure")
+
+// Faker is a consensus engine that doesn't enforce any rules. Seal returns the
+// block immediately and header verification succeeds, except for the block
+// numbers programmed to fail. Every block has a difficulty of one, so the total
+// difficulty of a chain equals its length, which keeps fork choice predictable.
+type Faker struct {
+	delay    time.Duration    // Time to sleep before each header verification returns
+	failures map[uint64]error // Errors to return for specific block numbers
+
+	lock sync.RWMutex // Protects the programmable fields
+}
+
+// New creates a consensus engine that accepts everything.
+func New() *Faker {
+	return &Faker{failures: make(map[uint64]error)}
+}
+
+// SetDelay sets the time each header verification takes, to simulate a slow
+// engine.
+func (f *Faker) SetDelay(delay time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.delay = delay
+}
+
+// FailAt programs the verification of the header with the given number to fail
+// with the given error, or with a generic one if err is nil.
+func (f *Faker) FailAt(number uint64, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err == nil {
+		err = errFakeFailure
+	}
+	f.failures[number] = err
+}
+
+// Reset clears all programmed delays and failures.
+func (f *Faker) Reset() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.delay = 0
+	f.failures = make(map[uint64]error)
+}
+
+// Author implements consensus.Engine, returning the header's coinbase.
+func (f *Faker) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+// VerifyHeader implements consensus.Engine, succeeding unless the header's
+// number was programmed to fail.
+func (f *Faker) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	f.lock.RLock()
+	delay, err := f.delay, f.failures[header.Number.Uint64()]
+	f.lock.RUnlock()
+
+	time.Sleep(delay)
+	return err
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers in
+// a background thread. The method returns a quit channel to abort the operations
+// and a results channel to retrieve the async verifications.
+func (f *Faker) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	return consensus.VerifyHeadersSequential(headers, func(i int) error {
+		return f.VerifyHeader(chain, headers[i], seals[i])
+	})
+}
+
+// VerifyUncles implements consensus.Engine, accepting any uncles.
+func (f *Faker) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	return nil
+}
+
+// Prepare implements consensus.Engine, setting the difficulty of the header.
+func (f *Faker) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Difficulty = f.CalcDifficulty(chain, header.Time, nil)
+	return nil
+}
+
+// Finalize implements consensus.Engine, setting the final state on the header
+// without granting any rewards.
+func (f *Faker) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+}
+
+// FinalizeAndAssemble implements consensus.Engine, setting the final state and
+// assembling the block.
+func (f *Faker) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	f.Finalize(chain, header, state, txs, uncles)
+	return types.NewBlock(header, txs, uncles, receipts, trie.NewStackTrie(nil)), nil
+}
+
+// Seal implements consensus.Engine, returning the block as is, immediately.
+func (f *Faker) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	select {
+	case results <- block:
+	default:
+		log.Warn("Sealing result is not read by miner", "mode", "faker", "sealhash", f.SealHash(block.Header()))
+	}
+	return nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed. Since the
+// faker doesn't seal anything, this is simply the header hash.
+func (f *Faker) SealHash(header *types.Header) common.Hash {
+	return header.Hash()
+}
+
+// CalcDifficulty implements consensus.Engine, returning a constant difficulty
+// of one.
+func (f *Faker) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	return big.NewInt(1)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs. The
+// faker has none.
+func (f *Faker) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return nil
+}
+
+// Close implements consensus.Engine. There are no background threads to stop.
+func (f *Faker) Close() error {
+	return nil
+}
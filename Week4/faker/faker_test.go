@@ -0,0 +1,60 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package faker
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// Tests that the faker accepts every header except the programmed ones, and
+// reports the results of a batch in order.
+func TestProgrammedFailures(t *testing.T) {
+	leakcheck.Check(t)
+
+	errCustom := errors.New("custom")
+
+	faker := New()
+	faker.FailAt(2, nil)
+	faker.FailAt(4, errCustom)
+
+	headers := make([]*types.Header, 5)
+	for i := range headers {
+		headers[i] = &types.Header{Number: big.NewInt(int64(i + 1))}
+	}
+	abort, results := faker.VerifyHeaders(nil, headers, make([]bool, len(headers)))
+	defer close(abort)
+
+	want := []error{nil, errFakeFailure, nil, errCustom, nil}
+	for i := range headers {
+		if err := <-results; err != want[i] {
+			t.Errorf("header %d: error mismatch: have %v, want %v", i+1, err, want[i])
+		}
+	}
+	faker.Reset()
+	if err := faker.VerifyHeader(nil, headers[1], true); err != nil {
+		t.Errorf("failure not reset: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package fixtures contains deterministic, canned header chains with a known
+// fork and a known consensus violation, along with a harness to check that an
+// engine accepts and rejects exactly the expected headers. It also contains a
+// canned chain to freeze the state produced by an engine's Finalize as golden
+// roots and balances.
+package fixtures
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	extraVanity = 32                     // Clique vanity prefix length
+	extraSeal   = crypto.SignatureLength // Clique seal suffix length
+
+	genesisTime = 1600000000 // Fixed genesis timestamp, far enough in the past
+	period      = 15         // Block period of the fixture chains
+	gasLimit    = 8000000    // Constant gas limit of every fixture header
+
+	forkPoint = 3 // Number of the last header shared by both forks
+	lengthA   = 6 // Number of headers in the valid fork (excluding genesis)
+	lengthB   = 8 // Number of headers in the invalid fork (excluding genesis)
+)
+
+var (
+	diffInTurn = big.NewInt(2) // Clique difficulty for in-turn signatures
+	diffNoTurn = big.NewInt(1) // Clique difficulty for out-of-turn signatures
+)
+
+// Fixture is a canned pair of forks rooted at the same genesis block. ForkA is
+// fully valid, whereas ForkB is longer but contains a single consensus violation
+// at a known position, which must make an engine reject it.
+type Fixture struct {
+	Name   string              // Unique name of the fixture
+	Config *params.ChainConfig // Chain configuration the headers were built for
+
+	Genesis *types.Header   // Genesis header both forks are rooted at
+	ForkA   []*types.Header // Valid fork, ascending from block 1
+	ForkB   []*types.Header // Invalid fork, ascending from block 1
+
+	Invalid   int    // Index into ForkB of the first invalid header
+	Violation string // Substring expected in the engine's rejection error
+}
+
+// Head returns the header a correct fork-choice implementation must end up
+// with after seeing both forks.
+func (f *Fixture) Head() *types.Header {
+	return f.ForkA[len(f.ForkA)-1]
+}
+
+// mutation tweaks the first diverging header of the invalid fork and returns the
+// key it should be signed with, given its parent and the authorized signers.
+type mutation func(header *types.Header, parent *types.Header, signers []*ecdsa.PrivateKey) *ecdsa.PrivateKey
+
+// All returns every known fixture. The returned headers are freshly generated
+// on each call, so callers are free to modify them.
+func All() []*Fixture {
+	return []*Fixture{
+		build("unauthorized-signer", "unauthorized signer", func(header, parent *types.Header, signers []*ecdsa.PrivateKey) *ecdsa.PrivateKey {
+			header.Difficulty = new(big.Int).Set(diffNoTurn)
+			return key("outsider")
+		}),
+		build("wrong-difficulty", "wrong difficulty", func(header, parent *types.Header, signers []*ecdsa.PrivateKey) *ecdsa.PrivateKey {
+			header.Difficulty = new(big.Int).Set(diffNoTurn)
+			return signers[header.Number.Uint64()%uint64(len(signers))]
+		}),
+		build("recently-signed", "recently signed", func(header, parent *types.Header, signers []*ecdsa.PrivateKey) *ecdsa.PrivateKey {
+			header.Difficulty = new(big.Int).Set(diffNoTurn)
+			return signers[parent.Number.Uint64()%uint64(len(signers))]
+		}),
+		build("timestamp-too-early", "invalid timestamp", func(header, parent *types.Header, signers []*ecdsa.PrivateKey) *ecdsa.PrivateKey {
+			header.Time = parent.Time + period - 1
+			return signers[header.Number.Uint64()%uint64(len(signers))]
+		}),
+	}
+}
+
+// Get returns the fixture with the given name, or nil if there is none.
+func Get(name string) *Fixture {
+	for _, f := range All() {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// build assembles a fixture whose invalid fork carries the given mutation.
+func build(name string, violation string, mutate mutation) *Fixture {
+	signers := []*ecdsa.PrivateKey{key("signer-0"), key("signer-1"), key("signer-2")}
+	sort.Slice(signers, func(i, j int) bool {
+		a, b := crypto.PubkeyToAddress(signers[i].PublicKey), crypto.PubkeyToAddress(signers[j].PublicKey)
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+	config := &params.ChainConfig{
+		ChainID: big.NewInt(1337),
+		Clique:  &params.CliqueConfig{Period: period, Epoch: 30000},
+	}
+	genesis := &types.Header{
+		Number:     new(big.Int),
+		Time:       genesisTime,
+		GasLimit:   gasLimit,
+		Difficulty: new(big.Int).Set(diffNoTurn),
+		UncleHash:  types.EmptyUncleHash,
+		Extra:      make([]byte, extraVanity+len(signers)*common.AddressLength+extraSeal),
+	}
+	for i, signer := range signers {
+		copy(genesis.Extra[extraVanity+i*common.AddressLength:], crypto.PubkeyToAddress(signer.PublicKey).Bytes())
+	}
+	forkA := extend(genesis, nil, lengthA, signers, nil)
+	forkB := extend(genesis, forkA[:forkPoint], lengthB-forkPoint, signers, mutate)
+
+	return &Fixture{
+		Name:      name,
+		Config:    config,
+		Genesis:   genesis,
+		ForkA:     forkA,
+		ForkB:     forkB,
+		Invalid:   forkPoint,
+		Violation: violation,
+	}
+}
+
+// extend generates n in-turn signed headers on top of the given prefix. If a
+// mutation is specified, it's applied to the first generated header.
+func extend(genesis *types.Header, prefix []*types.Header, n int, signers []*ecdsa.PrivateKey, mutate mutation) []*types.Header {
+	headers := make([]*types.Header, 0, len(prefix)+n)
+	for _, header := range prefix {
+		headers = append(headers, types.CopyHeader(header))
+	}
+	for i := 0; i < n; i++ {
+		parent := genesis
+		if len(headers) > 0 {
+			parent = headers[len(headers)-1]
+		}
+		number := new(big.Int).Add(parent.Number, common.Big1)
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			UncleHash:  types.EmptyUncleHash,
+			Number:     number,
+			Time:       parent.Time + period,
+			GasLimit:   gasLimit,
+			Difficulty: new(big.Int).Set(diffInTurn),
+			Extra:      make([]byte, extraVanity+extraSeal),
+		}
+		signer := signers[number.Uint64()%uint64(len(signers))]
+		if i == 0 && mutate != nil {
+			signer = mutate(header, parent, signers)
+		}
+		sig, err := crypto.Sign(clique.SealHash(header).Bytes(), signer)
+		if err != nil {
+			panic(fmt.Sprintf("failed to sign fixture header: %v", err))
+		}
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+		headers = append(headers, header)
+	}
+	return headers
+}
+
+// key derives a deterministic private key from a label.
+func key(label string) *ecdsa.PrivateKey {
+	key, err := crypto.ToECDSA(crypto.Keccak256([]byte("fixtures:" + label)))
+	if err != nil {
+		panic(fmt.Sprintf("failed to derive fixture key %q: %v", label, err))
+	}
+	return key
+}
+
+// Check verifies both forks of the fixture with the given engine and returns an
+// error describing the first verdict that deviates from the expected one: the
+// valid fork must be accepted entirely, while the invalid fork must be accepted
+// up to, and rejected exactly at, the violating header.
+func Check(engine consensus.Engine, f *Fixture) error {
+	for i, err := range Verify(engine, f, f.ForkA) {
+		if err != nil {
+			return fmt.Errorf("%s: valid header #%d rejected: %v", f.Name, f.ForkA[i].Number, err)
+		}
+	}
+	errs := Verify(engine, f, f.ForkB)
+	for i := 0; i < f.Invalid; i++ {
+		if errs[i] != nil {
+			return fmt.Errorf("%s: valid header #%d rejected: %v", f.Name, f.ForkB[i].Number, errs[i])
+		}
+	}
+	bad := f.ForkB[f.Invalid]
+	switch err := errs[f.Invalid]; {
+	case err == nil:
+		return fmt.Errorf("%s: invalid header #%d accepted", f.Name, bad.Number)
+	case !strings.Contains(err.Error(), f.Violation):
+		return fmt.Errorf("%s: invalid header #%d rejected for the wrong reason: have %q, want %q", f.Name, bad.Number, err, f.Violation)
+	}
+	return nil
+}
+
+// Verify runs the given fork of the fixture through the engine's batch header
+// verification and returns the individual results.
+func Verify(engine consensus.Engine, f *Fixture, fork []*types.Header) []error {
+	seals := make([]bool, len(fork))
+	for i := range seals {
+		seals[i] = true
+	}
+	abort, results := engine.VerifyHeaders(&genesisChain{f}, fork, seals)
+	defer close(abort)
+
+	errs := make([]error, len(fork))
+	for i := range fork {
+		errs[i] = <-results
+	}
+	return errs
+}
+
+// genesisChain is a header reader containing nothing but the fixture genesis.
+type genesisChain struct {
+	f *Fixture
+}
+
+func (c *genesisChain) Config() *params.ChainConfig  { return c.f.Config }
+func (c *genesisChain) CurrentHeader() *types.Header { return c.f.Genesis }
+
+func (c *genesisChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if number == 0 && hash == c.f.Genesis.Hash() {
+		return c.f.Genesis
+	}
+	return nil
+}
+
+func (c *genesisChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number == 0 {
+		return c.f.Genesis
+	}
+	return nil
+}
+
+func (c *genesisChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	if hash == c.f.Genesis.Hash() {
+		return c.f.Genesis
+	}
+	return nil
+}
+
+func (c *genesisChain) GetTd(hash common.Hash, number uint64) *big.Int {
+	if number == 0 && hash == c.f.Genesis.Hash() {
+		return new(big.Int).Set(c.f.Genesis.Difficulty)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fixtures
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// Tests that the stock clique engine passes every canned fixture, which is the
+// reference behavior student implementations are graded against.
+func TestCliqueFixtures(t *testing.T) {
+	for _, f := range All() {
+		engine := clique.New(f.Config.Clique, rawdb.NewMemoryDatabase())
+		if err := Check(engine, f); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// Tests that fixtures are deterministic across invocations.
+func TestFixturesDeterministic(t *testing.T) {
+	a, b := All(), All()
+	for i := range a {
+		if a[i].Head().Hash() != b[i].Head().Hash() {
+			t.Errorf("%s: valid head mismatch", a[i].Name)
+		}
+		if a[i].ForkB[len(a[i].ForkB)-1].Hash() != b[i].ForkB[len(b[i].ForkB)-1].Hash() {
+			t.Errorf("%s: invalid head mismatch", a[i].Name)
+		}
+	}
+}
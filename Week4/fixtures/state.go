@@ -0,0 +1,200 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fixtures
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+const rewardLength = 8 // Number of blocks in the reward chain (excluding genesis)
+
+// uncleDistances lists, per block of the reward chain, how many generations
+// each of its uncles lags behind it: every distance from one to six is covered,
+// and the last block includes two uncles.
+var uncleDistances = map[uint64][]uint64{
+	2: {1},
+	3: {2},
+	4: {3},
+	5: {4},
+	6: {5},
+	7: {6},
+	8: {1, 6},
+}
+
+// RewardChain is a canned chain exercising the reward paths of the engines'
+// Finalize: its coinbases rotate between three miners, its uncles are mined by a
+// fourth account, and the Byzantium and Constantinople reward changes activate
+// halfway. Running it through an engine yields a State that can be frozen as a
+// golden fixture, so refactors of the Finalize or reward logic can be checked
+// not to change the hashes of historical blocks.
+type RewardChain struct {
+	Config   *params.ChainConfig         // Chain configuration the blocks were built for
+	Genesis  *types.Header               // Genesis header, whose state holds Alloc
+	Blocks   []*types.Block              // Blocks to finalize, ascending from block 1
+	Alloc    map[common.Address]*big.Int // Balances allocated in the genesis state
+	Accounts []common.Address            // Key accounts whose balances are frozen
+}
+
+// State is a frozen chain state: the state root after each block of a reward
+// chain, and the final balances of its key accounts.
+type State struct {
+	Roots    []common.Hash
+	Balances []*big.Int
+}
+
+// Rewards returns the reward chain. The returned blocks are freshly generated
+// on each call, so callers are free to modify them.
+func Rewards() *RewardChain {
+	var (
+		miners = []common.Address{address("miner-0"), address("miner-1"), address("miner-2")}
+		uncler = address("uncler")
+		funded = address("funded")
+	)
+	config := &params.ChainConfig{
+		ChainID:             big.NewInt(1337),
+		HomesteadBlock:      big.NewInt(0),
+		EIP150Block:         big.NewInt(0),
+		EIP155Block:         big.NewInt(0),
+		EIP158Block:         big.NewInt(0),
+		ByzantiumBlock:      big.NewInt(4),
+		ConstantinopleBlock: big.NewInt(7),
+		PetersburgBlock:     big.NewInt(7),
+		Ethash:              new(params.EthashConfig),
+	}
+	genesis := &types.Header{
+		Number:     big.NewInt(0),
+		Time:       genesisTime,
+		GasLimit:   gasLimit,
+		Difficulty: big.NewInt(131072),
+		UncleHash:  types.EmptyUncleHash,
+	}
+	headers := []*types.Header{genesis}
+	for n := uint64(1); n <= rewardLength; n++ {
+		parent := headers[n-1]
+		headers = append(headers, &types.Header{
+			ParentHash: parent.Hash(),
+			Coinbase:   miners[n%uint64(len(miners))],
+			Number:     new(big.Int).SetUint64(n),
+			Time:       parent.Time + period,
+			GasLimit:   gasLimit,
+			Difficulty: big.NewInt(131072),
+		})
+	}
+	blocks := make([]*types.Block, 0, rewardLength)
+	for _, header := range headers[1:] {
+		var uncles []*types.Header
+		for _, distance := range uncleDistances[header.Number.Uint64()] {
+			sibling := headers[header.Number.Uint64()-distance]
+			uncles = append(uncles, &types.Header{
+				ParentHash: sibling.ParentHash,
+				Coinbase:   uncler,
+				Number:     new(big.Int).Set(sibling.Number),
+				Time:       sibling.Time + 1,
+				GasLimit:   gasLimit,
+				Difficulty: big.NewInt(131072),
+			})
+		}
+		blocks = append(blocks, types.NewBlock(header, nil, uncles, nil, trie.NewStackTrie(nil)))
+	}
+	return &RewardChain{
+		Config:   config,
+		Genesis:  genesis,
+		Blocks:   blocks,
+		Alloc:    map[common.Address]*big.Int{funded: big.NewInt(params.Ether)},
+		Accounts: append(miners, uncler, funded),
+	}
+}
+
+// address derives a deterministic account address from a label.
+func address(label string) common.Address {
+	return crypto.PubkeyToAddress(key(label).PublicKey)
+}
+
+// Finalize runs the blocks of the reward chain through the engine's Finalize,
+// committing the state after each one and carrying it over to the next, as the
+// block processor does, and returns the resulting state.
+func (r *RewardChain) Finalize(engine consensus.Engine) (*State, error) {
+	chain := headerbuilder.NewMemoryChain(r.Config, r.Genesis)
+	for _, block := range r.Blocks {
+		chain.InsertBlocks(block)
+	}
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, err := state.New(common.Hash{}, db, nil)
+	if err != nil {
+		return nil, err
+	}
+	for account, balance := range r.Alloc {
+		statedb.AddBalance(account, balance)
+	}
+	root, err := statedb.Commit(r.Config.IsEIP158(r.Genesis.Number))
+	if err != nil {
+		return nil, err
+	}
+	result := new(State)
+	for _, block := range r.Blocks {
+		if statedb, err = state.New(root, db, nil); err != nil {
+			return nil, err
+		}
+		header := block.Header()
+		engine.Finalize(chain, header, statedb, block.Transactions(), block.Uncles())
+
+		if root, err = statedb.Commit(r.Config.IsEIP158(header.Number)); err != nil {
+			return nil, err
+		}
+		if root != header.Root {
+			return nil, fmt.Errorf("block #%d: committed root %x differs from finalized root %x", header.Number, root, header.Root)
+		}
+		result.Roots = append(result.Roots, root)
+	}
+	for _, account := range r.Accounts {
+		result.Balances = append(result.Balances, statedb.GetBalance(account))
+	}
+	return result, nil
+}
+
+// Diff compares the state against a golden one and returns an error describing
+// the first deviation, if any.
+func (s *State) Diff(golden *State) error {
+	if len(s.Roots) != len(golden.Roots) {
+		return fmt.Errorf("block count mismatch: have %d, want %d", len(s.Roots), len(golden.Roots))
+	}
+	for i := range s.Roots {
+		if s.Roots[i] != golden.Roots[i] {
+			return fmt.Errorf("block #%d: state root mismatch: have %x, want %x", i+1, s.Roots[i], golden.Roots[i])
+		}
+	}
+	if len(s.Balances) != len(golden.Balances) {
+		return fmt.Errorf("account count mismatch: have %d, want %d", len(s.Balances), len(golden.Balances))
+	}
+	for i := range s.Balances {
+		if s.Balances[i].Cmp(golden.Balances[i]) != 0 {
+			return fmt.Errorf("account %d: balance mismatch: have %v, want %v", i, s.Balances[i], golden.Balances[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package fixtures
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/consensus/hashcash"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// The golden states below were frozen from the stock engines; the balances were
+// also checked by hand against the reward rules. They must only ever change
+// along with a deliberate, fork-scheduled change of the consensus rules: any
+// other difference means the state, and thus the hash, of historical blocks has
+// drifted.
+
+// ethashGolden is the state of the reward chain under the Frontier, Byzantium
+// and Constantinople block and uncle rewards.
+var ethashGolden = &State{
+	Roots: []common.Hash{
+		common.HexToHash("fbb1bbaf73f1ab63d934ef625706de59d2f5c69f38d1af3bb290d6b861344e14"),
+		common.HexToHash("7ba7812fd4dc400d7b7c3a023a8a0e7cfe428e4978ca5700689546767522362b"),
+		common.HexToHash("8f0af285558f2a43d1e7d2bdb807e1f104a04c4e5c892e2565e46ebc560aa05a"),
+		common.HexToHash("0ac32a1f3e8b171d545fb3b1c92373e9ddafea7588bbe1fa05d76ebd1abcc771"),
+		common.HexToHash("868849c59b8ca718ac073ccbaea4c56b01629de38d53d18164b819e04ffbe197"),
+		common.HexToHash("71320895c6de1bff45be3c25f9bec7c778975942f7eec9383ebfdee9c999c0fc"),
+		common.HexToHash("8170e74447a42524b0bcb3ecafaa8893642c0872f7afa36d799a5eb8ec2a6638"),
+		common.HexToHash("d549d0013ba3df1935f55f8229d525349a052742e111ccd2509a06c42f0cd5ec"),
+	},
+	Balances: balances(
+		"8250000000000000000",  // Blocks 3 and 6, one uncle each
+		"10156250000000000000", // Blocks 1, 4 and 7, uncles in the last two
+		"10375000000000000000", // Blocks 2, 5 and 8, three uncles in total
+		"15375000000000000000", // Eight uncles, one to six generations behind
+		"1000000000000000000",  // Genesis allocation, untouched
+	),
+}
+
+// hashcashGolden is the state of the reward chain under the flat hashcash block
+// reward, which ignores uncles.
+var hashcashGolden = &State{
+	Roots: []common.Hash{
+		common.HexToHash("fe285f0360c61fe8b17832da41109e0381cb2f1afbf3876f12eedef6a84a024f"),
+		common.HexToHash("d4504f2d87b0beac2c396a5aa144c06d5e0f663fece5a548d85c530f1d4279dc"),
+		common.HexToHash("9f8e0f7b2fc0a6e1497f78a8aaff2ea888c167b8efec21581ba9f3265e8594bc"),
+		common.HexToHash("eec8fc26d687511582abf24d368f6c238971e1d7413f7ae0749fd51233b320e8"),
+		common.HexToHash("c487b121387049b5543c59f507cd5b8d0459a1b38483d1036935aba4aba8ea1e"),
+		common.HexToHash("12b13e53a98bb0e053ec0e0243016a85c10e7b3f119b1af0b77e134264f7445f"),
+		common.HexToHash("72e8f3b7c0afcaa639429d2475a310e592709eed9d0c8bb45b9ebe38b55bd778"),
+		common.HexToHash("3bcfb9a8459e15f8361a2f5a334cf3e779eb7c44ce38097b50d145e488cf753f"),
+	},
+	Balances: balances(
+		"4000000000000000000",
+		"6000000000000000000",
+		"6000000000000000000",
+		"0",
+		"1000000000000000000",
+	),
+}
+
+// balances parses decimal balances.
+func balances(values ...string) []*big.Int {
+	parsed := make([]*big.Int, len(values))
+	for i, value := range values {
+		parsed[i], _ = new(big.Int).SetString(value, 10)
+	}
+	return parsed
+}
+
+// Tests that finalizing the reward chain with the stock engines still produces
+// the frozen golden states.
+func TestGoldenStates(t *testing.T) {
+	faker := ethash.NewFaker()
+	defer faker.Close()
+
+	pow := hashcash.New(hashcash.Config{})
+	defer pow.Close()
+
+	tests := []struct {
+		name   string
+		engine consensus.Engine
+		golden *State
+	}{
+		{"ethash", faker, ethashGolden},
+		{"hashcash", pow, hashcashGolden},
+	}
+	for _, tt := range tests {
+		have, err := Rewards().Finalize(tt.engine)
+		if err != nil {
+			t.Errorf("%s: failed to finalize reward chain: %v", tt.name, err)
+			continue
+		}
+		if err := have.Diff(tt.golden); err != nil {
+			t.Errorf("%s: golden state drifted: %v", tt.name, err)
+		}
+	}
+}
+
+// Tests that a change of the reward logic is caught at the first block it
+// affects.
+func TestGoldenStateDrift(t *testing.T) {
+	engine := ethash.New(ethash.Config{
+		PowMode: ethash.ModeFake,
+		Rewards: misc.RewardSchedule{
+			{Block: common.Big0, Reward: ethash.FrontierBlockReward},
+			{Block: big.NewInt(4), Reward: ethash.ByzantiumBlockReward},
+			{Block: big.NewInt(7), Reward: big.NewInt(params.Ether)}, // Not the stock Constantinople reward
+		},
+	}, nil, false)
+	defer engine.Close()
+
+	have, err := Rewards().Finalize(engine)
+	if err != nil {
+		t.Fatalf("failed to finalize reward chain: %v", err)
+	}
+	if err := have.Diff(ethashGolden); err == nil || !strings.HasPrefix(err.Error(), "block #7:") {
+		t.Errorf("drift mismatch: have %v, want state root mismatch at block #7", err)
+	}
+}
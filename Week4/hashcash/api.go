@@ -0,0 +1,87 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hashcash
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// API exposes hashcash related methods for the RPC interface.
+type API struct {
+	hashcash *Hashcash
+}
+
+// GetWork returns a work package for external miner.
+//
+// The work package consists of 3 strings:
+//
+//	result[0] - 32 bytes hex encoded current block header seal hash
+//	result[1] - 32 bytes hex encoded boundary condition ("target"), 2^256/difficulty
+//	result[2] - hex encoded block number
+func (api *API) GetWork() ([3]string, error) {
+	if api.hashcash.remote == nil {
+		return [3]string{}, consensus.WithErrorCode(errRemoteDisabled)
+	}
+	work, err := api.hashcash.remote.work()
+	return work, consensus.WithErrorCode(err)
+}
+
+// SubmitWork can be used by external miner to submit their POW solution.
+// It returns an indication if the work was accepted.
+// Note either an invalid solution, a stale work a non-existent work will return false.
+func (api *API) SubmitWork(nonce types.BlockNonce, hash common.Hash) bool {
+	if api.hashcash.remote == nil {
+		return false
+	}
+	return api.hashcash.remote.submit(nonce, hash) == nil
+}
+
+// GetShares returns the share difficulty credited to each worker of the stratum
+// server, or nil if it's not running.
+func (api *API) GetShares() map[string]*hexutil.Big {
+	if api.hashcash.stratum == nil {
+		return nil
+	}
+	shares := make(map[string]*hexutil.Big)
+	for worker, credit := range api.hashcash.stratum.credits() {
+		shares[worker] = (*hexutil.Big)(credit)
+	}
+	return shares
+}
+
+// SubmitHashrate can be used for remote miners to submit their hash rate.
+// This enables the node to report the combined hash rate of all miners
+// which submit work through this node.
+//
+// It accepts the miner hash rate and an identifier which must be unique
+// between nodes.
+func (api *API) SubmitHashrate(rate hexutil.Uint64, id common.Hash) bool {
+	if api.hashcash.remote == nil {
+		return false
+	}
+	api.hashcash.remote.submitRate(id, uint64(rate))
+	return true
+}
+
+// GetHashrate returns the current hashrate for the local CPU miner, the remote
+// miners and the pooled miners.
+func (api *API) GetHashrate() uint64 {
+	return uint64(api.hashcash.Hashrate())
+}
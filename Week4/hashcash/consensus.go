@@ -0,0 +1,297 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hashcash
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/difficulty"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hashcash proof-of-work protocol constants.
+var (
+	BlockReward                   = big.NewInt(2e+18) // Block reward in wei for successfully mining a block
+	allowedFutureBlockTimeSeconds = int64(15)         // Max seconds from current time allowed for blocks, before they're considered future blocks
+
+	// two256 is a big integer representing 2^256
+	two256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	errOlderBlockTime    = errors.New("timestamp older than parent")
+	errTooManyUncles     = errors.New("too many uncles")
+	errInvalidDifficulty = errors.New("non-positive difficulty")
+	errInvalidMixDigest  = errors.New("invalid mix digest")
+	errInvalidPoW        = errors.New("invalid proof-of-work")
+)
+
+// Author implements consensus.Engine, returning the header's coinbase as the
+// proof-of-work verified author of the block.
+func (hashcash *Hashcash) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase, nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules of the
+// hashcash engine.
+func (hashcash *Hashcash) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	// Short circuit if the header is known, or its parent not
+	number := header.Number.Uint64()
+	if chain.GetHeader(header.Hash(), number) != nil {
+		return nil
+	}
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	// Sanity checks passed, do a proper verification
+	return hashcash.verifyHeader(chain, header, parent, seal, time.Now().Unix())
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers
+// sequentially in a background thread, since checking a seal only costs two
+// hashes. The method returns a quit channel to abort the operations and a
+// results channel to retrieve the async verifications.
+func (hashcash *Hashcash) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	unixNow := time.Now().Unix()
+
+	// Configured difficulty algorithms may look further back than the parent,
+	// so make the batch itself resolvable as ancestry
+	if hashcash.config.Difficulty != nil {
+		chain = consensus.WithHeaders(chain, headers)
+	}
+	return consensus.VerifyHeadersSequential(headers, func(i int) error {
+		var parent *types.Header
+		if i == 0 {
+			parent = chain.GetHeader(headers[0].ParentHash, headers[0].Number.Uint64()-1)
+		} else if headers[i-1].Hash() == headers[i].ParentHash {
+			parent = headers[i-1]
+		}
+		if parent == nil {
+			return consensus.ErrUnknownAncestor
+		}
+		return hashcash.verifyHeader(chain, headers[i], parent, seals[i], unixNow)
+	})
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this engine doesn't support uncles.
+func (hashcash *Hashcash) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errTooManyUncles
+	}
+	return nil
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules of the
+// hashcash engine.
+func (hashcash *Hashcash) verifyHeader(chain consensus.ChainHeaderReader, header, parent *types.Header, seal bool, unixNow int64) error {
+	// Ensure that the header's extra-data section is of a reasonable size
+	if uint64(len(header.Extra)) > params.MaximumExtraDataSize {
+		return fmt.Errorf("extra-data too long: %d > %d", len(header.Extra), params.MaximumExtraDataSize)
+	}
+	// Verify the header's timestamp
+	if header.Time > uint64(unixNow+allowedFutureBlockTimeSeconds) {
+		return consensus.ErrFutureBlock
+	}
+	if header.Time <= parent.Time {
+		return errOlderBlockTime
+	}
+	// Verify the block's difficulty based on its timestamp and parent's difficulty
+	if header.Difficulty.Cmp(hashcash.config.MinDifficulty) < 0 {
+		return fmt.Errorf("difficulty below floor: have %v, min %v", header.Difficulty, hashcash.config.MinDifficulty)
+	}
+	expected := hashcash.CalcDifficulty(chain, header.Time, parent)
+	if expected == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	if expected.Cmp(header.Difficulty) != 0 {
+		return fmt.Errorf("invalid difficulty: have %v, want %v", header.Difficulty, expected)
+	}
+	// Verify that the gas limit is <= 2^63-1
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, params.MaxGasLimit)
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	// Verify the block's gas usage and (if applicable) verify the base fee.
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, expected 'nil'", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// Verify that the block number is parent's +1
+	if diff := new(big.Int).Sub(header.Number, parent.Number); diff.Cmp(common.Big1) != 0 {
+		return consensus.ErrInvalidNumber
+	}
+	// Verify the engine specific seal securing the block
+	if seal {
+		return hashcash.verifySeal(header)
+	}
+	return nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the
+// difficulty that a new block should have when created at time given the
+// parent block's time and difficulty. By default the algorithm follows the
+// Homestead rules, minus the difficulty bomb, with a configurable target period:
+//
+//	diff = parent_diff + parent_diff / 2048 * max(1 - (time - parent_time) // period, -99)
+//
+// If another algorithm is configured, nil is returned when the ancestors needed
+// for the calculation are not available.
+func (hashcash *Hashcash) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	if hashcash.config.Difficulty != nil {
+		config := *hashcash.config.Difficulty
+		if config.MinDifficulty == nil {
+			config.MinDifficulty = hashcash.config.MinDifficulty
+		}
+		return difficulty.New(config)(chain, time, parent)
+	}
+	return calcDifficulty(hashcash.config, time, parent)
+}
+
+// calcDifficulty is the default difficulty adjustment algorithm, independent of
+// any engine instance.
+func calcDifficulty(config Config, time uint64, parent *types.Header) *big.Int {
+	return difficulty.Homestead(config.Period, nil, config.MinDifficulty)(nil, time, parent)
+}
+
+// verifySeal checks whether a header satisfies the PoW difficulty requirements.
+func (hashcash *Hashcash) verifySeal(header *types.Header) error {
+	// Ensure that we have a valid difficulty for the block
+	if header.Difficulty.Sign() <= 0 {
+		return errInvalidDifficulty
+	}
+	// Recompute the PoW value and check it against the target
+	digest := powHash(hashcash.SealHash(header).Bytes(), header.Nonce.Uint64())
+	if header.MixDigest != digest {
+		return errInvalidMixDigest
+	}
+	target := new(big.Int).Div(two256, header.Difficulty)
+	if new(big.Int).SetBytes(digest[:]).Cmp(target) > 0 {
+		return errInvalidPoW
+	}
+	return nil
+}
+
+// powHash computes the proof-of-work value of a seal hash and nonce, being
+// the double SHA256 of the two concatenated.
+func powHash(hash []byte, nonce uint64) common.Hash {
+	seed := make([]byte, len(hash)+8)
+	copy(seed, hash)
+	binary.LittleEndian.PutUint64(seed[len(hash):], nonce)
+
+	first := sha256.Sum256(seed)
+	return sha256.Sum256(first[:])
+}
+
+// Prepare implements consensus.Engine, initializing the difficulty field of a
+// header to conform to the hashcash protocol. The changes are done inline.
+func (hashcash *Hashcash) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Difficulty = hashcash.CalcDifficulty(chain, header.Time, parent)
+	if header.Difficulty == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, crediting the block reward to the
+// coinbase and setting the final state on the header.
+func (hashcash *Hashcash) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	entries := hashcash.finalize(chain, header, state)
+	hashcash.config.Ledger.Record(hashcash, header, entries)
+}
+
+// FinalizeAndAssemble implements consensus.Engine, crediting the block reward,
+// setting the final state and assembling the block.
+func (hashcash *Hashcash) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// Finalize block
+	entries := hashcash.finalize(chain, header, state)
+
+	// Header seems complete, assemble into a block and return
+	block := types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil))
+	hashcash.config.Ledger.Record(hashcash, block.Header(), entries)
+	return block, nil
+}
+
+// finalize credits the block reward and commits the final state root, returning
+// the ledger entries of the reward. They are only recorded once the header is
+// complete, as they are keyed by its seal hash.
+func (hashcash *Hashcash) finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB) []ledger.Entry {
+	state.AddBalance(header.Coinbase, BlockReward)
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	return []ledger.Entry{{Account: header.Coinbase, Kind: ledger.KindBlockReward, Amount: BlockReward}}
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (hashcash *Hashcash) SealHash(header *types.Header) (hash common.Hash) {
+	hasher := sha3.NewLegacyKeccak256()
+
+	enc := []interface{}{
+		header.ParentHash,
+		header.UncleHash,
+		header.Coinbase,
+		header.Root,
+		header.TxHash,
+		header.ReceiptHash,
+		header.Bloom,
+		header.Difficulty,
+		header.Number,
+		header.GasLimit,
+		header.GasUsed,
+		header.Time,
+		header.Extra,
+	}
+	if header.BaseFee != nil {
+		enc = append(enc, header.BaseFee)
+	}
+	rlp.Encode(hasher, enc)
+	hasher.Sum(hash[:0])
+	return hash
+}
@@ -0,0 +1,205 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hashcash
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// Tests that a block sealed by the miner passes seal verification, and that
+// tampering with the nonce invalidates it.
+func TestSealAndVerify(t *testing.T) {
+	leakcheck.Check(t)
+
+	hashcash := New(Config{MinDifficulty: big.NewInt(1)})
+	hashcash.SetThreads(2)
+
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(1024), Time: 1600000000}
+	results := make(chan *types.Block)
+	if err := hashcash.Seal(nil, types.NewBlockWithHeader(header), results, nil); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	var block *types.Block
+	select {
+	case block = <-results:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("sealing timed out")
+	}
+	if err := hashcash.verifySeal(block.Header()); err != nil {
+		t.Fatalf("sealed block rejected: %v", err)
+	}
+	tampered := block.Header()
+	tampered.Nonce = types.EncodeNonce(tampered.Nonce.Uint64() + 1)
+	if err := hashcash.verifySeal(tampered); err == nil {
+		t.Fatalf("tampered block accepted")
+	}
+}
+
+// Tests that the difficulty rises for fast blocks, drops for slow ones and
+// never goes below the configured minimum.
+func TestCalcDifficulty(t *testing.T) {
+	config := Config{Period: 10, MinDifficulty: big.NewInt(100000)}
+	parent := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(2048000), Time: 1000}
+
+	tests := []struct {
+		time uint64
+		diff int64
+	}{
+		{1005, 2049000}, // Fast block, +1 step
+		{1010, 2048000}, // On target
+		{1025, 2047000}, // Slow block, -1 step
+		{5000, 1949000}, // Very slow block, capped at -99 steps
+		{990, 2050000},  // Timestamp before the parent, -1 periods elapsed as in ethash's Homestead rules
+	}
+	for i, tt := range tests {
+		if have := calcDifficulty(config, tt.time, parent); have.Int64() != tt.diff {
+			t.Errorf("test %d: difficulty mismatch: have %v, want %v", i, have, tt.diff)
+		}
+	}
+	parent.Difficulty = big.NewInt(100000)
+	if have := calcDifficulty(config, 5000, parent); have.Cmp(config.MinDifficulty) != 0 {
+		t.Errorf("minimum difficulty not enforced: have %v, want %v", have, config.MinDifficulty)
+	}
+}
+
+// Tests that work handed out to remote miners can be sealed through the API, and
+// that unknown or invalid solutions are rejected.
+func TestRemoteSeal(t *testing.T) {
+	leakcheck.Check(t)
+
+	hashcash := New(Config{MinDifficulty: big.NewInt(1), Remote: true})
+	hashcash.SetThreads(-1)
+	api := &API{hashcash}
+
+	if _, err := api.GetWork(); !errors.Is(err, errNoMiningWork) {
+		t.Fatalf("work error mismatch: have %v, want %v", err, errNoMiningWork)
+	}
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(64), Time: 1600000000}
+	results := make(chan *types.Block, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if err := hashcash.Seal(nil, types.NewBlockWithHeader(header), results, stop); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	work, err := api.GetWork()
+	if err != nil {
+		t.Fatalf("failed to get work: %v", err)
+	}
+	sealhash := common.HexToHash(work[0])
+	if want := hashcash.SealHash(header); sealhash != want {
+		t.Fatalf("seal hash mismatch: have %x, want %x", sealhash, want)
+	}
+	if have, want := new(big.Int).SetBytes(common.HexToHash(work[1]).Bytes()), new(big.Int).Div(two256, header.Difficulty); have.Cmp(want) != 0 {
+		t.Fatalf("target mismatch: have %x, want %x", have, want)
+	}
+	// Search for a valid and an invalid nonce, as an external miner would
+	var (
+		target         = new(big.Int).Div(two256, header.Difficulty)
+		valid, invalid = -1, -1
+	)
+	for nonce := 0; valid < 0 || invalid < 0; nonce++ {
+		if new(big.Int).SetBytes(powHash(sealhash.Bytes(), uint64(nonce)).Bytes()).Cmp(target) <= 0 {
+			valid = nonce
+		} else {
+			invalid = nonce
+		}
+	}
+	if api.SubmitWork(types.EncodeNonce(uint64(valid)), common.Hash{}) {
+		t.Errorf("solution for unknown work accepted")
+	}
+	if api.SubmitWork(types.EncodeNonce(uint64(invalid)), sealhash) {
+		t.Errorf("invalid solution accepted")
+	}
+	if !api.SubmitWork(types.EncodeNonce(uint64(valid)), sealhash) {
+		t.Fatalf("valid solution rejected")
+	}
+	select {
+	case block := <-results:
+		if err := hashcash.verifySeal(block.Header()); err != nil {
+			t.Errorf("remotely sealed block rejected: %v", err)
+		}
+	default:
+		t.Fatalf("remotely sealed block not delivered")
+	}
+}
+
+// Tests that the hashrates reported by remote miners are aggregated, replacing
+// earlier reports of the same miner and dropping the expired ones.
+func TestRemoteHashrate(t *testing.T) {
+	leakcheck.Check(t)
+
+	hashcash := New(Config{MinDifficulty: big.NewInt(1), Remote: true})
+	api := &API{hashcash}
+
+	var (
+		alice = common.HexToHash("0xa11ce")
+		bob   = common.HexToHash("0xb0b")
+	)
+	api.SubmitHashrate(100, alice)
+	api.SubmitHashrate(200, alice)
+	api.SubmitHashrate(50, bob)
+	if have := hashcash.Hashrate(); have != 250 {
+		t.Errorf("hashrate mismatch: have %v, want %v", have, 250)
+	}
+	hashcash.remote.rates[bob] = hashrate{rate: 50, ping: time.Now().Add(-2 * rateExpiry)}
+	if have := hashcash.Hashrate(); have != 200 {
+		t.Errorf("expired hashrate counted: have %v, want %v", have, 200)
+	}
+	if have := api.GetHashrate(); have != 200 {
+		t.Errorf("API hashrate mismatch: have %v, want %v", have, 200)
+	}
+	if (&API{New(Config{})}).SubmitHashrate(100, alice) {
+		t.Errorf("hashrate accepted without remote sealing")
+	}
+}
+
+// Tests that the hashrate of a remote miner that stopped reporting decays
+// gradually instead of vanishing at once.
+func TestRemoteHashrateDecay(t *testing.T) {
+	var (
+		ping = time.Now()
+		rate = hashrate{rate: 1000, ping: ping}
+	)
+	tests := []struct {
+		silence time.Duration
+		want    float64
+	}{
+		{0, 1000},
+		{rateGrace, 1000},
+		{rateGrace + rateHalfLife, 500},
+		{rateGrace + 2*rateHalfLife, 250},
+		{rateGrace + 10*rateHalfLife, 1000.0 / 1024},
+	}
+	for i, tt := range tests {
+		if have := rate.decayed(ping.Add(tt.silence)); math.Abs(have-tt.want) > 1e-9 {
+			t.Errorf("test %d: hashrate mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
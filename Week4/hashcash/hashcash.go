@@ -0,0 +1,164 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package hashcash implements a CPU-only, memory-light proof-of-work consensus
+// engine based on double SHA256, in the spirit of Bitcoin's mining algorithm.
+package hashcash
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/difficulty"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Default engine parameters, used for any zero configuration field.
+const defaultPeriod = 10 // Targeted number of seconds between blocks
+
+// Config are the configuration parameters of the hashcash engine.
+type Config struct {
+	Period        uint64   // Targeted number of seconds between blocks
+	MinDifficulty *big.Int // Lower bound of the difficulty adjustment
+
+	// When set, difficulty is adjusted by the configured algorithm instead
+	// of the default Homestead style rules.
+	Difficulty *difficulty.Config
+
+	// When set, the rewards credited by finalized blocks are recorded in it.
+	Ledger *ledger.Ledger `toml:"-" json:"-"`
+
+	// When set, the blocks being sealed are also handed out to external miners
+	// through the RPC API, which accepts their solutions.
+	Remote bool
+
+	// When set, a stratum server is started handing the blocks being sealed
+	// out to pooled miners. Implies Remote.
+	Stratum *StratumConfig
+
+	Log log.Logger `toml:"-"`
+}
+
+// Hashcash is a consensus engine based on proof-of-work, where a nonce is sought
+// for which the double SHA256 hash of the sealed header is below a target.
+type Hashcash struct {
+	config Config
+
+	// Mining related fields
+	threads  int            // Number of threads to mine on if mining
+	update   chan struct{}  // Notification channel to update mining parameters
+	hashrate metrics.Meter  // Meter tracking the average hashrate
+	remote   *remoteSealer  // Work source for external miners (nil if disabled)
+	stratum  *stratumServer // Server for pooled miners (nil if disabled)
+
+	lock sync.Mutex // Ensures thread safety for the mining fields
+}
+
+// New creates a hashcash proof-of-work engine, filling in defaults for any
+// missing configuration parameter.
+func New(config Config) *Hashcash {
+	if config.Log == nil {
+		config.Log = log.Root()
+	}
+	if config.Period == 0 {
+		config.Period = defaultPeriod
+	}
+	if config.MinDifficulty == nil {
+		config.MinDifficulty = params.MinimumDifficulty
+	}
+	hashcash := &Hashcash{
+		config:   config,
+		update:   make(chan struct{}),
+		hashrate: metrics.NewMeterForced(),
+	}
+	if config.Remote || config.Stratum != nil {
+		hashcash.remote = newRemoteSealer(hashcash)
+	}
+	if config.Stratum != nil {
+		server, err := startStratum(hashcash, *config.Stratum)
+		if err != nil {
+			config.Log.Error("Failed to start stratum server", "addr", config.Stratum.Addr, "err", err)
+		} else {
+			config.Log.Info("Started stratum server", "addr", server.listener.Addr())
+			hashcash.stratum = server
+		}
+	}
+	return hashcash
+}
+
+// Threads returns the number of mining threads currently enabled. This doesn't
+// necessarily mean that mining is running!
+func (hashcash *Hashcash) Threads() int {
+	hashcash.lock.Lock()
+	defer hashcash.lock.Unlock()
+
+	return hashcash.threads
+}
+
+// SetThreads updates the number of mining threads currently enabled. Calling
+// this method does not start mining, only sets the thread count. If zero is
+// specified, the miner will use all cores of the machine. Setting a thread
+// count below zero is allowed and will cause the miner to idle, without any
+// work being done.
+func (hashcash *Hashcash) SetThreads(threads int) {
+	hashcash.lock.Lock()
+	defer hashcash.lock.Unlock()
+
+	// Update the threads and ping any running seal to pull in any changes
+	hashcash.threads = threads
+	select {
+	case hashcash.update <- struct{}{}:
+	default:
+	}
+}
+
+// Hashrate implements PoW, returning the measured rate of the search invocations
+// per second over the last minute. The rates reported by remote miners and the
+// rate estimated from the shares found by pooled miners are added to it.
+func (hashcash *Hashcash) Hashrate() float64 {
+	rate := hashcash.hashrate.Rate1()
+	if hashcash.remote != nil {
+		rate += hashcash.remote.hashrate()
+	}
+	if hashcash.stratum != nil {
+		rate += hashcash.stratum.hashrate.Rate1()
+	}
+	return rate
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs.
+func (hashcash *Hashcash) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	apis := []rpc.API{{
+		Namespace: "hashcash",
+		Version:   "1.0",
+		Service:   &API{hashcash},
+		Public:    true,
+	}}
+	return append(apis, hashcash.config.Ledger.APIs(chain, hashcash)...)
+}
+
+// Close implements consensus.Engine, stopping the stratum server if running.
+func (hashcash *Hashcash) Close() error {
+	if hashcash.stratum != nil {
+		hashcash.stratum.close()
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hashcash
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// staleThreshold is the maximum depth of the acceptable stale but valid
+	// solution submitted by remote miners.
+	staleThreshold = 7
+
+	// rateGrace is the time the hashrate reported by a remote miner is counted
+	// in full, covering the interval between its reports.
+	rateGrace = 10 * time.Second
+
+	// rateHalfLife is the time in which the hashrate of a remote miner halves
+	// once it stopped reporting, so a missed report doesn't drop it at once.
+	rateHalfLife = 30 * time.Second
+
+	// rateExpiry is the time after which a remote miner not reporting anymore is
+	// forgotten, its hashrate having decayed to nothing.
+	rateExpiry = 10 * time.Minute
+)
+
+var (
+	errNoMiningWork      = errors.New("no mining work available yet")
+	errInvalidSealResult = errors.New("invalid or stale proof-of-work solution")
+	errRemoteDisabled    = errors.New("not supported")
+)
+
+// Seal implements consensus.Engine, attempting to find a nonce that satisfies
+// the block's difficulty requirements.
+func (hashcash *Hashcash) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	// Hand the block out to the remote and pooled miners too, if enabled
+	if hashcash.remote != nil {
+		hashcash.remote.push(block, results)
+	}
+	if hashcash.stratum != nil {
+		hashcash.stratum.update(block)
+	}
+	// Create a runner and the multiple search threads it directs
+	abort := make(chan struct{})
+
+	hashcash.lock.Lock()
+	threads := hashcash.threads
+	hashcash.lock.Unlock()
+
+	if threads == 0 {
+		threads = runtime.NumCPU()
+	}
+	if threads < 0 {
+		threads = 0 // Allows disabling local mining without extra logic around local/remote
+	}
+	seed, err := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
+	if err != nil {
+		return err
+	}
+	var (
+		pend   sync.WaitGroup
+		locals = make(chan *types.Block)
+		nonces = rand.New(rand.NewSource(seed.Int64()))
+	)
+	for i := 0; i < threads; i++ {
+		pend.Add(1)
+		go func(id int, nonce uint64) {
+			defer pend.Done()
+			hashcash.mine(block, id, nonce, abort, locals)
+		}(i, uint64(nonces.Int63()))
+	}
+	// Wait until sealing is terminated or a nonce is found
+	go func() {
+		var result *types.Block
+		select {
+		case <-stop:
+			// Outside abort, stop all miner threads
+			close(abort)
+		case result = <-locals:
+			// One of the threads found a block, abort all others
+			select {
+			case results <- result:
+			default:
+				hashcash.config.Log.Warn("Sealing result is not read by miner", "sealhash", hashcash.SealHash(block.Header()))
+			}
+			close(abort)
+		case <-hashcash.update:
+			// Thread count was changed on user request, restart
+			close(abort)
+			if err := hashcash.Seal(chain, block, results, stop); err != nil {
+				hashcash.config.Log.Error("Failed to restart sealing after update", "err", err)
+			}
+		}
+		// Wait for all miners to terminate and return the block
+		pend.Wait()
+	}()
+	return nil
+}
+
+// mine is the actual proof-of-work miner that searches for a nonce starting from
+// seed that results in correct final block difficulty.
+func (hashcash *Hashcash) mine(block *types.Block, id int, seed uint64, abort chan struct{}, found chan *types.Block) {
+	// Extract some data from the header
+	var (
+		header = block.Header()
+		hash   = hashcash.SealHash(header).Bytes()
+		target = new(big.Int).Div(two256, header.Difficulty)
+	)
+	// Start generating random nonces until we abort or find a good one
+	var (
+		attempts  = int64(0)
+		nonce     = seed
+		powBuffer = new(big.Int)
+	)
+	logger := hashcash.config.Log.New("miner", id)
+	logger.Trace("Started hashcash search for new nonces", "seed", seed)
+search:
+	for {
+		select {
+		case <-abort:
+			// Mining terminated, update stats and abort
+			logger.Trace("Hashcash nonce search aborted", "attempts", nonce-seed)
+			hashcash.hashrate.Mark(attempts)
+			break search
+
+		default:
+			// We don't have to update hash rate on every nonce, so update after 2^X nonces
+			attempts++
+			if (attempts % (1 << 15)) == 0 {
+				hashcash.hashrate.Mark(attempts)
+				attempts = 0
+			}
+			// Compute the PoW value of this nonce
+			digest := powHash(hash, nonce)
+			if powBuffer.SetBytes(digest[:]).Cmp(target) <= 0 {
+				// Correct nonce found, create a new header with it
+				header = types.CopyHeader(header)
+				header.Nonce = types.EncodeNonce(nonce)
+				header.MixDigest = digest
+
+				// Seal and return a block (if still needed)
+				select {
+				case found <- block.WithSeal(header):
+					logger.Trace("Hashcash nonce found and reported", "attempts", nonce-seed, "nonce", nonce)
+				case <-abort:
+					logger.Trace("Hashcash nonce found but discarded", "attempts", nonce-seed, "nonce", nonce)
+				}
+				break search
+			}
+			nonce++
+		}
+	}
+}
+
+// remoteSealer hands the blocks being sealed out to external miners and accepts
+// their solutions. Unlike the ethash one, it doesn't notify miners of new work,
+// so it needs no background thread and is driven by its callers.
+type remoteSealer struct {
+	hashcash *Hashcash
+
+	works   map[common.Hash]*types.Block // Blocks handed out, by seal hash
+	current *types.Block                 // Most recent block handed out
+	results chan<- *types.Block          // Result channel of the most recent block
+	rates   map[common.Hash]hashrate     // Hashrates reported by remote miners, by miner id
+
+	lock sync.Mutex
+}
+
+// hashrate is the hashrate reported by a remote miner.
+type hashrate struct {
+	rate uint64
+	ping time.Time
+}
+
+// decayed returns the hashrate to count for the miner at the given time: the
+// reported one during the grace period, decaying exponentially afterwards.
+func (r hashrate) decayed(now time.Time) float64 {
+	silence := now.Sub(r.ping) - rateGrace
+	if silence <= 0 {
+		return float64(r.rate)
+	}
+	return float64(r.rate) * math.Exp2(-silence.Seconds()/rateHalfLife.Seconds())
+}
+
+// newRemoteSealer creates a remote sealer without any work yet.
+func newRemoteSealer(hashcash *Hashcash) *remoteSealer {
+	return &remoteSealer{
+		hashcash: hashcash,
+		works:    make(map[common.Hash]*types.Block),
+		rates:    make(map[common.Hash]hashrate),
+	}
+}
+
+// push makes a block the current work, dropping the blocks too old for their
+// solutions to be still accepted.
+func (s *remoteSealer) push(block *types.Block, results chan<- *types.Block) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.current, s.results = block, results
+	s.works[s.hashcash.SealHash(block.Header())] = block
+
+	for hash, work := range s.works {
+		if work.NumberU64()+staleThreshold <= block.NumberU64() {
+			delete(s.works, hash)
+		}
+	}
+}
+
+// work returns the work package of the current block, as served by GetWork.
+func (s *remoteSealer) work() ([3]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.current == nil {
+		return [3]string{}, errNoMiningWork
+	}
+	return [3]string{
+		s.hashcash.SealHash(s.current.Header()).Hex(),
+		common.BytesToHash(new(big.Int).Div(two256, s.current.Difficulty()).Bytes()).Hex(),
+		hexutil.EncodeBig(s.current.Number()),
+	}, nil
+}
+
+// submit verifies a nonce found by a remote miner for the block with the given
+// seal hash and, if valid, delivers the sealed block to the result channel.
+func (s *remoteSealer) submit(nonce types.BlockNonce, sealhash common.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	block := s.works[sealhash]
+	if block == nil {
+		s.hashcash.config.Log.Warn("Work submitted but none pending", "sealhash", sealhash)
+		return errInvalidSealResult
+	}
+	header := block.Header()
+	header.Nonce = nonce
+	header.MixDigest = powHash(sealhash.Bytes(), nonce.Uint64())
+
+	if err := s.hashcash.verifySeal(header); err != nil {
+		s.hashcash.config.Log.Warn("Invalid proof-of-work submitted", "sealhash", sealhash, "err", err)
+		return errInvalidSealResult
+	}
+	solution := block.WithSeal(header)
+
+	select {
+	case s.results <- solution:
+		s.hashcash.config.Log.Debug("Work submitted is acceptable", "number", solution.NumberU64(), "sealhash", sealhash, "hash", solution.Hash())
+		return nil
+	default:
+		s.hashcash.config.Log.Warn("Sealing result is not read by miner", "mode", "remote", "sealhash", sealhash)
+		return errInvalidSealResult
+	}
+}
+
+// submitRate records the hashrate reported by a remote miner, replacing its
+// previous report.
+func (s *remoteSealer) submitRate(id common.Hash, rate uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.rates[id] = hashrate{rate: rate, ping: time.Now()}
+}
+
+// hashrate returns the total hashrate reported by the remote miners, decaying
+// the ones not reported recently and dropping the expired ones.
+func (s *remoteSealer) hashrate() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var (
+		now   = time.Now()
+		total float64
+	)
+	for id, rate := range s.rates {
+		if now.Sub(rate.ping) > rateExpiry {
+			delete(s.rates, id)
+			continue
+		}
+		total += rate.decayed(now)
+	}
+	return total
+}
@@ -0,0 +1,486 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hashcash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	stratumMaxMessage   = 4096            // Maximum size of a message received from a miner
+	stratumWriteTimeout = 5 * time.Second // Time allowed to send a message to a miner
+	stratumQueueSize    = 16              // Messages queued to a miner before it's considered stalled
+)
+
+// errStalledMiner is returned if a miner doesn't keep up reading its messages.
+var errStalledMiner = errors.New("stalled miner")
+
+// Errors reported to the miners, using the codes common to stratum pools.
+var (
+	errStratumInvalid      = []interface{}{20, "Invalid request", nil}
+	errStratumJobNotFound  = []interface{}{21, "Job not found", nil}
+	errStratumDuplicate    = []interface{}{22, "Duplicate share", nil}
+	errStratumLowShare     = []interface{}{23, "Low difficulty share", nil}
+	errStratumUnauthorized = []interface{}{24, "Unauthorized worker", nil}
+	errStratumUnsubscribed = []interface{}{25, "Not subscribed", nil}
+)
+
+// StratumConfig are the configuration parameters of the stratum server.
+type StratumConfig struct {
+	Addr       string   // TCP address to listen on for miners
+	Difficulty *big.Int // Initial share difficulty of the miners (minimum block difficulty if nil)
+
+	// MinDifficulty is the lowest share difficulty miners may suggest, stopping
+	// them from flooding the server with cheap shares. Lower suggestions are
+	// raised to it. Defaults to the initial share difficulty if nil.
+	MinDifficulty *big.Int
+}
+
+// stratumServer hands the remote work out to pooled miners, crediting every
+// share a miner finds with its difficulty. Miners are given disjoint nonce
+// spaces: the upper 4 bytes of the nonce are the extranonce of the connection,
+// the lower 4 bytes are searched by the miner.
+//
+// The protocol borrows the framing and the methods of stratum v1, but it is a
+// custom one: a hashcash seal has no coinbase or merkle branch to assemble, so
+// the job parameters differ and stock stratum v1 miners can't work on them.
+// Work is announced with mining.notify, its parameters being the job id, the
+// seal hash, the block target, the block number and the clean jobs flag. Shares
+// are submitted with mining.submit as the worker name, the job id and the hex
+// encoded lower half of the nonce.
+//
+// Messages are queued to a writer goroutine per connection, so announcing work
+// never waits for a slow miner. Miners not keeping up are disconnected.
+type stratumServer struct {
+	hashcash      *Hashcash
+	listener      net.Listener
+	difficulty    *big.Int      // Initial share difficulty of new connections
+	minDifficulty *big.Int      // Lowest share difficulty miners may suggest
+	hashrate      metrics.Meter // Meter tracking the difficulty of the shares found
+
+	conns      map[*stratumConn]struct{} // Live miner connections
+	jobs       map[string]*stratumJob    // Jobs still accepting shares, by id
+	job        *stratumJob               // Most recent job
+	jobSeq     uint64                    // Sequence number of the last job
+	extranonce uint32                    // Last extranonce given out
+	shares     map[string]*big.Int       // Credited share difficulty by worker
+	closed     bool                      // Whether the server was closed
+
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+}
+
+// stratumJob is a block handed out to the miners.
+type stratumJob struct {
+	id        string
+	sealhash  common.Hash
+	target    *big.Int
+	number    uint64
+	submitted map[uint64]struct{} // Nonces already submitted, to reject duplicates
+}
+
+// stratumConn is a connection of a miner.
+type stratumConn struct {
+	conn       net.Conn
+	extranonce uint32   // Upper half of the nonces searched by the miner
+	difficulty *big.Int // Difficulty of the shares accepted from the miner
+	worker     string   // Authorized worker name
+	subscribed bool     // Whether the miner subscribed to work notifications
+
+	queue chan []byte   // Encoded messages waiting to be written to the miner
+	quit  chan struct{} // Closed once the connection is dropped
+}
+
+// stratumRequest is a request sent by a miner.
+type stratumRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// stratumMessage is a response or notification sent to a miner.
+type stratumMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method,omitempty"`
+	Params []interface{}   `json:"params,omitempty"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+// startStratum starts a stratum server listening on the configured address.
+func startStratum(hashcash *Hashcash, config StratumConfig) (*stratumServer, error) {
+	listener, err := net.Listen("tcp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	difficulty := config.Difficulty
+	if difficulty == nil {
+		difficulty = hashcash.config.MinDifficulty
+	}
+	minDifficulty := config.MinDifficulty
+	if minDifficulty == nil {
+		minDifficulty = difficulty
+	}
+	if minDifficulty.Sign() <= 0 {
+		listener.Close()
+		return nil, errors.New("non-positive share difficulty")
+	}
+	if difficulty.Cmp(minDifficulty) < 0 {
+		listener.Close()
+		return nil, errors.New("share difficulty below minimum")
+	}
+	s := &stratumServer{
+		hashcash:      hashcash,
+		listener:      listener,
+		difficulty:    new(big.Int).Set(difficulty),
+		minDifficulty: new(big.Int).Set(minDifficulty),
+		hashrate:      metrics.NewMeterForced(),
+		conns:         make(map[*stratumConn]struct{}),
+		jobs:          make(map[string]*stratumJob),
+		shares:        make(map[string]*big.Int),
+	}
+	s.wg.Add(1)
+	go s.loop()
+	return s, nil
+}
+
+// close stops accepting miners and disconnects the connected ones.
+func (s *stratumServer) close() {
+	s.closeOnce.Do(func() {
+		s.listener.Close()
+
+		s.lock.Lock()
+		s.closed = true
+		for c := range s.conns {
+			c.conn.Close()
+		}
+		s.lock.Unlock()
+
+		s.wg.Wait()
+	})
+}
+
+// loop accepts miner connections until the listener is closed.
+func (s *stratumServer) loop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		if s.accept(conn) == nil {
+			return
+		}
+	}
+}
+
+// accept registers a new miner connection and starts serving it, returning nil
+// if the server is already closed.
+func (s *stratumServer) accept(conn net.Conn) *stratumConn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		conn.Close()
+		return nil
+	}
+	s.extranonce++
+	c := &stratumConn{
+		conn:       conn,
+		extranonce: s.extranonce,
+		difficulty: s.difficulty,
+		queue:      make(chan []byte, stratumQueueSize),
+		quit:       make(chan struct{}),
+	}
+	s.conns[c] = struct{}{}
+
+	s.wg.Add(2)
+	go s.serve(c)
+	go s.write(c)
+	return c
+}
+
+// serve handles the requests of a miner until it disconnects or misbehaves.
+func (s *stratumServer) serve(c *stratumConn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, c)
+		s.lock.Unlock()
+		c.conn.Close()
+		close(c.quit)
+	}()
+	logger := s.hashcash.config.Log.New("miner", c.conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 0, 512), stratumMaxMessage)
+	for scanner.Scan() {
+		var req stratumRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			logger.Debug("Dropping stratum miner", "err", err)
+			return
+		}
+		result, fail := s.handle(c, &req)
+		if err := c.send(&stratumMessage{ID: req.ID, Result: result, Error: fail}); err != nil {
+			logger.Debug("Failed to respond to stratum miner", "err", err)
+			return
+		}
+		if fail == nil {
+			if err := s.followup(c, req.Method); err != nil {
+				logger.Debug("Failed to notify stratum miner", "err", err)
+				return
+			}
+		}
+	}
+}
+
+// write sends the queued messages to the miner until the connection is dropped.
+// A failed write drops the connection, ending serve too.
+func (s *stratumServer) write(c *stratumConn) {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case blob := <-c.queue:
+			c.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+			if _, err := c.conn.Write(blob); err != nil {
+				s.hashcash.config.Log.Debug("Failed to write to stratum miner", "miner", c.conn.RemoteAddr(), "err", err)
+				c.conn.Close()
+				return
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// handle serves a single request of a miner, returning either the result or
+// the error to respond with. The state of a connection is only accessed under
+// the server lock.
+func (s *stratumServer) handle(c *stratumConn, req *stratumRequest) (interface{}, interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch req.Method {
+	case "mining.subscribe":
+		extranonce := make([]byte, 4)
+		binary.BigEndian.PutUint32(extranonce, c.extranonce)
+		return []interface{}{[]interface{}{"mining.notify", hex.EncodeToString(extranonce)}, hex.EncodeToString(extranonce), 4}, nil
+
+	case "mining.authorize":
+		var worker string
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &worker) != nil || worker == "" {
+			return nil, errStratumInvalid
+		}
+		c.worker = worker
+		return true, nil
+
+	case "mining.suggest_difficulty":
+		var difficulty float64
+		if len(req.Params) == 0 || json.Unmarshal(req.Params[0], &difficulty) != nil || difficulty < 1 {
+			return nil, errStratumInvalid
+		}
+		c.difficulty, _ = new(big.Float).SetFloat64(difficulty).Int(nil)
+		if c.difficulty.Cmp(s.minDifficulty) < 0 {
+			c.difficulty = s.minDifficulty
+		}
+		return true, nil
+
+	case "mining.submit":
+		return s.submit(c, req.Params)
+
+	default:
+		return nil, errStratumInvalid
+	}
+}
+
+// followup sends the notifications due after a successful request: the share
+// difficulty and current job once subscribed, and the share difficulty after
+// changing it. They are queued under the lock, so no job announced meanwhile can
+// overtake them.
+func (s *stratumServer) followup(c *stratumConn, method string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	switch method {
+	case "mining.subscribe":
+		c.subscribed = true
+		if err := c.setDifficulty(); err != nil {
+			return err
+		}
+		if s.job != nil {
+			return c.notify(s.job)
+		}
+	case "mining.suggest_difficulty":
+		if c.subscribed {
+			return c.setDifficulty()
+		}
+	}
+	return nil
+}
+
+// submit verifies a share found by a miner, crediting it to the worker and, if
+// it also satisfies the block target, submitting it as the block's seal. The
+// caller must hold the lock.
+func (s *stratumServer) submit(c *stratumConn, params []json.RawMessage) (interface{}, interface{}) {
+	if !c.subscribed {
+		return nil, errStratumUnsubscribed
+	}
+	var worker, id, lower string
+	if len(params) < 3 || json.Unmarshal(params[0], &worker) != nil || json.Unmarshal(params[1], &id) != nil || json.Unmarshal(params[2], &lower) != nil {
+		return nil, errStratumInvalid
+	}
+	if c.worker == "" || worker != c.worker {
+		return nil, errStratumUnauthorized
+	}
+	job := s.jobs[id]
+	if job == nil {
+		return nil, errStratumJobNotFound
+	}
+	blob, err := hex.DecodeString(lower)
+	if err != nil || len(blob) != 4 {
+		return nil, errStratumInvalid
+	}
+	nonce := uint64(c.extranonce)<<32 | uint64(binary.BigEndian.Uint32(blob))
+	if _, ok := job.submitted[nonce]; ok {
+		return nil, errStratumDuplicate
+	}
+	digest := powHash(job.sealhash.Bytes(), nonce)
+	value := new(big.Int).SetBytes(digest[:])
+	if value.Cmp(new(big.Int).Div(two256, c.difficulty)) > 0 {
+		return nil, errStratumLowShare
+	}
+	job.submitted[nonce] = struct{}{}
+
+	if s.shares[worker] == nil {
+		s.shares[worker] = new(big.Int)
+	}
+	s.shares[worker].Add(s.shares[worker], c.difficulty)
+
+	// A share takes as many hashes on average as its difficulty, so metering the
+	// difficulties estimates the hashrate of the pool
+	if c.difficulty.IsInt64() {
+		s.hashrate.Mark(c.difficulty.Int64())
+	} else {
+		s.hashrate.Mark(math.MaxInt64)
+	}
+
+	if value.Cmp(job.target) <= 0 {
+		if err := s.hashcash.remote.submit(types.EncodeNonce(nonce), job.sealhash); err != nil {
+			s.hashcash.config.Log.Warn("Stratum block solution rejected", "worker", worker, "number", job.number, "err", err)
+		} else {
+			s.hashcash.config.Log.Info("Stratum block solution found", "worker", worker, "number", job.number, "sealhash", job.sealhash)
+		}
+	}
+	return true, nil
+}
+
+// update announces a new block to seal to all the subscribed miners, dropping
+// the jobs too old for their solutions to be still accepted. The announcements
+// are only queued, so sealing is never held up by the miners.
+func (s *stratumServer) update(block *types.Block) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sealhash := s.hashcash.SealHash(block.Header())
+	if s.job != nil && s.job.sealhash == sealhash {
+		return // Sealing restarted on the same block
+	}
+	s.jobSeq++
+	s.job = &stratumJob{
+		id:        fmt.Sprintf("%x", s.jobSeq),
+		sealhash:  sealhash,
+		target:    new(big.Int).Div(two256, block.Difficulty()),
+		number:    block.NumberU64(),
+		submitted: make(map[uint64]struct{}),
+	}
+	s.jobs[s.job.id] = s.job
+
+	for id, job := range s.jobs {
+		if job.number+staleThreshold <= s.job.number {
+			delete(s.jobs, id)
+		}
+	}
+	for c := range s.conns {
+		if c.subscribed {
+			c.notify(s.job)
+		}
+	}
+}
+
+// credits returns the share difficulty credited to each worker.
+func (s *stratumServer) credits() map[string]*big.Int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	credits := make(map[string]*big.Int, len(s.shares))
+	for worker, shares := range s.shares {
+		credits[worker] = new(big.Int).Set(shares)
+	}
+	return credits
+}
+
+// setDifficulty queues the share difficulty of the connection to the miner. The
+// caller must hold the server lock.
+func (c *stratumConn) setDifficulty() error {
+	return c.send(&stratumMessage{ID: json.RawMessage("null"), Method: "mining.set_difficulty", Params: []interface{}{c.difficulty}})
+}
+
+// notify queues a job to the miner.
+func (c *stratumConn) notify(job *stratumJob) error {
+	params := []interface{}{
+		job.id,
+		job.sealhash.Hex(),
+		common.BytesToHash(job.target.Bytes()).Hex(),
+		hexutil.EncodeUint64(job.number),
+		true, // Clean jobs, previous blocks are superseded
+	}
+	return c.send(&stratumMessage{ID: json.RawMessage("null"), Method: "mining.notify", Params: params})
+}
+
+// send queues a message to the miner without blocking. A miner whose queue is
+// full doesn't keep up with its messages and is disconnected.
+func (c *stratumConn) send(msg *stratumMessage) error {
+	blob, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case c.queue <- append(blob, '\n'):
+		return nil
+	default:
+		c.conn.Close()
+		return errStalledMiner
+	}
+}
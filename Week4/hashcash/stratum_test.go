@@ -0,0 +1,254 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hashcash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// testMessage is a response or notification received by a test miner.
+type testMessage struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  []json.RawMessage `json:"error"`
+}
+
+// testMiner is a stratum client driven by the tests.
+type testMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	id     int
+}
+
+// read waits for the next message from the server.
+func (m *testMiner) read() *testMessage {
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("failed to read message: %v", err)
+	}
+	msg := new(testMessage)
+	if err := json.Unmarshal(line, msg); err != nil {
+		m.t.Fatalf("failed to decode message %q: %v", line, err)
+	}
+	return msg
+}
+
+// call sends a request to the server and waits for its response.
+func (m *testMiner) call(method string, params ...interface{}) *testMessage {
+	m.id++
+	blob, _ := json.Marshal(map[string]interface{}{"id": m.id, "method": method, "params": params})
+	if _, err := m.conn.Write(append(blob, '\n')); err != nil {
+		m.t.Fatalf("failed to send %s: %v", method, err)
+	}
+	msg := m.read()
+	if msg.Method != "" {
+		m.t.Fatalf("unexpected notification %s instead of %s response", msg.Method, method)
+	}
+	return msg
+}
+
+// code returns the stratum error code of a response, or zero if it succeeded.
+func (msg *testMessage) code() int {
+	if len(msg.Error) == 0 {
+		return 0
+	}
+	var code int
+	json.Unmarshal(msg.Error[0], &code)
+	return code
+}
+
+// Tests that pooled miners receive the work over stratum, that they are credited
+// for their shares, and that a share solving the block seals it.
+func TestStratum(t *testing.T) {
+	leakcheck.Check(t)
+
+	hashcash := New(Config{MinDifficulty: big.NewInt(1), Stratum: &StratumConfig{Addr: "127.0.0.1:0", Difficulty: big.NewInt(2)}})
+	defer hashcash.Close()
+	hashcash.SetThreads(-1)
+
+	if hashcash.stratum == nil {
+		t.Fatalf("stratum server not started")
+	}
+	conn, err := net.Dial("tcp", hashcash.stratum.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	miner := &testMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	// Subscribe and authorize, receiving the share difficulty
+	if code := miner.call("mining.submit", "alice", "1", "00000000").code(); code != 25 {
+		t.Fatalf("submission before subscribing: have code %d, want %d", code, 25)
+	}
+	var subscription []json.RawMessage
+	if err := json.Unmarshal(miner.call("mining.subscribe").Result, &subscription); err != nil || len(subscription) != 3 {
+		t.Fatalf("invalid subscription: %v", err)
+	}
+	var extranonce string
+	json.Unmarshal(subscription[1], &extranonce)
+	upper, err := hex.DecodeString(extranonce)
+	if err != nil || len(upper) != 4 {
+		t.Fatalf("invalid extranonce %q: %v", extranonce, err)
+	}
+	if msg := miner.read(); msg.Method != "mining.set_difficulty" || string(msg.Params[0]) != "2" {
+		t.Fatalf("share difficulty mismatch: have %s %s, want mining.set_difficulty 2", msg.Method, msg.Params)
+	}
+	if code := miner.call("mining.authorize", "alice", "x").code(); code != 0 {
+		t.Fatalf("failed to authorize: code %d", code)
+	}
+	// Suggest a share difficulty below the floor, which is raised to it
+	if code := miner.call("mining.suggest_difficulty", 1).code(); code != 0 {
+		t.Fatalf("failed to suggest difficulty: code %d", code)
+	}
+	if msg := miner.read(); msg.Method != "mining.set_difficulty" || string(msg.Params[0]) != "2" {
+		t.Fatalf("share difficulty floor mismatch: have %s %s, want mining.set_difficulty 2", msg.Method, msg.Params)
+	}
+	// Start sealing and wait for the work to be announced
+	header := &types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(64), Time: 1600000000}
+	results := make(chan *types.Block, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	if err := hashcash.Seal(nil, types.NewBlockWithHeader(header), results, stop); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	notify := miner.read()
+	if notify.Method != "mining.notify" || len(notify.Params) != 5 {
+		t.Fatalf("invalid job notification: %s %s", notify.Method, notify.Params)
+	}
+	var job, hash string
+	json.Unmarshal(notify.Params[0], &job)
+	json.Unmarshal(notify.Params[1], &hash)
+
+	sealhash := common.HexToHash(hash)
+	if want := hashcash.SealHash(header); sealhash != want {
+		t.Fatalf("seal hash mismatch: have %x, want %x", sealhash, want)
+	}
+	// Search for a block solution, a share and an invalid share
+	var (
+		blockTarget = new(big.Int).Div(two256, header.Difficulty)
+		shareTarget = new(big.Int).Div(two256, big.NewInt(2))
+
+		solution, share, low = -1, -1, -1
+	)
+	for lower := 0; solution < 0 || share < 0 || low < 0; lower++ {
+		nonce := uint64(binary.BigEndian.Uint32(upper))<<32 | uint64(lower)
+		value := new(big.Int).SetBytes(powHash(sealhash.Bytes(), nonce).Bytes())
+		switch {
+		case value.Cmp(blockTarget) <= 0:
+			solution = lower
+		case value.Cmp(shareTarget) <= 0:
+			share = lower
+		default:
+			low = lower
+		}
+	}
+	encode := func(lower int) string {
+		blob := make([]byte, 4)
+		binary.BigEndian.PutUint32(blob, uint32(lower))
+		return hex.EncodeToString(blob)
+	}
+	tests := []struct {
+		worker string
+		job    string
+		nonce  string
+		code   int
+	}{
+		{"alice", job, encode(low), 23},     // Share below the share difficulty
+		{"alice", job, encode(share), 0},    // Valid share
+		{"alice", job, encode(share), 22},   // Duplicate share
+		{"alice", "ff", encode(share), 21},  // Unknown job
+		{"bob", job, encode(share), 24},     // Unauthorized worker
+		{"alice", job, "00", 20},            // Malformed nonce
+		{"alice", job, encode(solution), 0}, // Block solution
+	}
+	for i, tt := range tests {
+		if code := miner.call("mining.submit", tt.worker, tt.job, tt.nonce).code(); code != tt.code {
+			t.Errorf("test %d: error code mismatch: have %d, want %d", i, code, tt.code)
+		}
+	}
+	select {
+	case block := <-results:
+		if err := hashcash.verifySeal(block.Header()); err != nil {
+			t.Errorf("pool sealed block rejected: %v", err)
+		}
+	default:
+		t.Fatalf("pool sealed block not delivered")
+	}
+	shares := (&API{hashcash}).GetShares()
+	if have := shares["alice"]; have == nil || have.ToInt().Int64() != 4 {
+		t.Errorf("credited shares mismatch: have %v, want %v", have, 4)
+	}
+}
+
+// Tests that announcing work doesn't wait for miners not reading their messages,
+// and that such miners are disconnected once their queue overflows.
+func TestStratumStalledMiner(t *testing.T) {
+	leakcheck.Check(t)
+
+	hashcash := New(Config{MinDifficulty: big.NewInt(1), Stratum: &StratumConfig{Addr: "127.0.0.1:0"}})
+	defer hashcash.Close()
+
+	// Connect a subscribed miner over a synchronous pipe it never reads from
+	server, client := net.Pipe()
+	defer client.Close()
+
+	c := hashcash.stratum.accept(server)
+	hashcash.stratum.lock.Lock()
+	c.subscribed = true
+	hashcash.stratum.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*stratumQueueSize; i++ {
+			header := &types.Header{Number: big.NewInt(int64(i + 1)), Difficulty: big.NewInt(64)}
+			hashcash.stratum.update(types.NewBlockWithHeader(header))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(stratumWriteTimeout / 2):
+		t.Fatalf("work announcement blocked on stalled miner")
+	}
+	// The miner's connection is dropped, closing the pipe
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	for {
+		if _, err := client.Read(buf); err != nil {
+			if err, ok := err.(net.Error); ok && err.Timeout() {
+				t.Fatalf("stalled miner not disconnected")
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package headerbuilder
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// MemoryChain is an in-memory chain reader for tests, implementing both the
+// consensus.ChainHeaderReader and consensus.ChainReader interfaces. It holds
+// the headers (and optionally blocks) of any number of branches, and keeps the
+// heaviest one, by total difficulty, as the canonical chain.
+type MemoryChain struct {
+	config  *params.ChainConfig
+	headers map[common.Hash]*types.Header
+	blocks  map[common.Hash]*types.Block
+	tds     map[common.Hash]*big.Int
+	canon   []*types.Header
+}
+
+// NewMemoryChain creates a chain with the given configuration, consisting of
+// the given headers, the first of which is the genesis.
+func NewMemoryChain(config *params.ChainConfig, headers ...*types.Header) *MemoryChain {
+	chain := &MemoryChain{
+		config:  config,
+		headers: make(map[common.Hash]*types.Header),
+		blocks:  make(map[common.Hash]*types.Block),
+		tds:     make(map[common.Hash]*big.Int),
+	}
+	chain.Insert(headers...)
+	return chain
+}
+
+// Insert adds the given headers to the chain, in order. A header becomes the
+// new head if it extends the current head or if its branch is heavier, in which
+// case the canonical chain is rewritten back to the fork point.
+func (c *MemoryChain) Insert(headers ...*types.Header) {
+	for _, header := range headers {
+		hash := header.Hash()
+
+		td := new(big.Int)
+		if parent, ok := c.tds[header.ParentHash]; ok {
+			td.Set(parent)
+		}
+		if header.Difficulty != nil {
+			td.Add(td, header.Difficulty)
+		}
+		c.headers[hash], c.tds[hash] = header, td
+
+		if head := c.CurrentHeader(); head == nil || header.ParentHash == head.Hash() || td.Cmp(c.tds[head.Hash()]) > 0 {
+			c.setHead(header)
+		}
+	}
+}
+
+// InsertBlocks adds the given blocks to the chain, in order, as Insert does
+// with their headers.
+func (c *MemoryChain) InsertBlocks(blocks ...*types.Block) {
+	for _, block := range blocks {
+		c.blocks[block.Hash()] = block
+		c.Insert(block.Header())
+	}
+}
+
+// setHead makes the given header the head of the canonical chain.
+func (c *MemoryChain) setHead(head *types.Header) {
+	c.canon = make([]*types.Header, head.Number.Uint64()+1)
+	for header := head; header != nil; header = c.headers[header.ParentHash] {
+		c.canon[header.Number.Uint64()] = header
+	}
+}
+
+// Config retrieves the chain configuration.
+func (c *MemoryChain) Config() *params.ChainConfig { return c.config }
+
+// CurrentHeader retrieves the head of the canonical chain.
+func (c *MemoryChain) CurrentHeader() *types.Header {
+	if len(c.canon) == 0 {
+		return nil
+	}
+	return c.canon[len(c.canon)-1]
+}
+
+// GetHeader retrieves a header of any branch by hash and number.
+func (c *MemoryChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+// GetHeaderByNumber retrieves a header of the canonical chain by number.
+func (c *MemoryChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number < uint64(len(c.canon)) {
+		return c.canon[number]
+	}
+	return nil
+}
+
+// GetHeaderByHash retrieves a header of any branch by hash.
+func (c *MemoryChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.headers[hash]
+}
+
+// GetTd retrieves the total difficulty of a header of any branch, counted from
+// the oldest known ancestor.
+func (c *MemoryChain) GetTd(hash common.Hash, number uint64) *big.Int {
+	if c.GetHeader(hash, number) == nil {
+		return nil
+	}
+	return new(big.Int).Set(c.tds[hash])
+}
+
+// GetBlock retrieves a block inserted through InsertBlocks by hash and number.
+func (c *MemoryChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	if block := c.blocks[hash]; block != nil && block.NumberU64() == number {
+		return block
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package headerbuilder is a test utility to assemble consistent block headers
+// through functional options, instead of spelling out header literals.
+package headerbuilder
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	extraVanity = 32                     // Vanity prefix reserved by signature based engines
+	extraSeal   = crypto.SignatureLength // Seal suffix reserved by signature based engines
+
+	defaultPeriod   = 10      // Seconds between a parent and a child if no time is set
+	defaultGasLimit = 8000000 // Gas limit of headers without a parent
+)
+
+// builder accumulates the settings of a header under construction.
+type builder struct {
+	header *types.Header
+	parent *types.Header
+	config *params.ChainConfig
+
+	time     *uint64
+	signer   *ecdsa.PrivateKey
+	sealHash func(header *types.Header) common.Hash
+}
+
+// Option configures a header under construction.
+type Option func(b *builder)
+
+// WithParent links the header to the given parent, deriving its number, time,
+// gas limit and base fee from it unless they are explicitly overridden.
+func WithParent(parent *types.Header) Option {
+	return func(b *builder) { b.parent = parent }
+}
+
+// WithConfig sets the chain configuration deciding whether a header derived from
+// its parent carries an EIP-1559 base fee. Without it, only the children of
+// headers with a base fee do.
+func WithConfig(config *params.ChainConfig) Option {
+	return func(b *builder) { b.config = config }
+}
+
+// WithNumber overrides the block number of the header.
+func WithNumber(number uint64) Option {
+	return func(b *builder) { b.header.Number = new(big.Int).SetUint64(number) }
+}
+
+// WithTime sets the timestamp of the header.
+func WithTime(time uint64) Option {
+	return func(b *builder) { b.time = &time }
+}
+
+// WithDifficulty sets the difficulty of the header.
+func WithDifficulty(difficulty *big.Int) Option {
+	return func(b *builder) { b.header.Difficulty = new(big.Int).Set(difficulty) }
+}
+
+// WithExtra sets the extra-data of the header. When combined with SignedBy, the
+// extra-data is treated as the vanity (and optional signer list) and the seal is
+// appended automatically.
+func WithExtra(extra []byte) Option {
+	return func(b *builder) { b.header.Extra = common.CopyBytes(extra) }
+}
+
+// WithCoinbase sets the beneficiary of the header.
+func WithCoinbase(coinbase common.Address) Option {
+	return func(b *builder) { b.header.Coinbase = coinbase }
+}
+
+// WithNonce sets the nonce of the header.
+func WithNonce(nonce types.BlockNonce) Option {
+	return func(b *builder) { b.header.Nonce = nonce }
+}
+
+// WithGasLimit sets the gas limit of the header.
+func WithGasLimit(limit uint64) Option {
+	return func(b *builder) { b.header.GasLimit = limit }
+}
+
+// WithBaseFee sets the EIP-1559 base fee of the header.
+func WithBaseFee(fee *big.Int) Option {
+	return func(b *builder) { b.header.BaseFee = new(big.Int).Set(fee) }
+}
+
+// SignedBy seals the header with a secp256k1 signature of the given key over
+// the engine specific seal hash (e.g. clique.SealHash), stored in the trailing
+// 65 bytes of the extra-data.
+func SignedBy(key *ecdsa.PrivateKey, sealHash func(header *types.Header) common.Hash) Option {
+	return func(b *builder) { b.signer, b.sealHash = key, sealHash }
+}
+
+// New assembles a header from the given options. Fields not set explicitly are
+// derived from the parent (if any) or set to sane defaults, so that the header
+// passes the basic sanity checks of every engine.
+func New(opts ...Option) *types.Header {
+	b := &builder{
+		header: &types.Header{
+			UncleHash:   types.EmptyUncleHash,
+			TxHash:      types.EmptyRootHash,
+			ReceiptHash: types.EmptyRootHash,
+		},
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	header := b.header
+
+	// Fill in the fields cascading from the parent
+	if b.parent != nil {
+		header.ParentHash = b.parent.Hash()
+		if header.Number == nil {
+			header.Number = new(big.Int).Add(b.parent.Number, common.Big1)
+		}
+		config := b.config
+		if config == nil && b.parent.BaseFee != nil {
+			config = params.TestChainConfig
+		}
+		london := config != nil && config.IsLondon(header.Number)
+		if header.GasLimit == 0 {
+			header.GasLimit = b.parent.GasLimit
+			if london && !config.IsLondon(b.parent.Number) {
+				header.GasLimit *= params.ElasticityMultiplier
+			}
+		}
+		if header.BaseFee == nil && london {
+			header.BaseFee = misc.CalcBaseFee(config, b.parent)
+		}
+		header.Time = b.parent.Time + defaultPeriod
+	}
+	if b.time != nil {
+		header.Time = *b.time
+	}
+	if header.Number == nil {
+		header.Number = new(big.Int)
+	}
+	if header.GasLimit == 0 {
+		header.GasLimit = defaultGasLimit
+	}
+	if header.Difficulty == nil {
+		header.Difficulty = big.NewInt(1)
+	}
+	// Seal the header last, after all other fields are final
+	if b.signer != nil {
+		if len(header.Extra) < extraVanity {
+			header.Extra = append(header.Extra, make([]byte, extraVanity-len(header.Extra))...)
+		}
+		header.Extra = append(header.Extra, make([]byte, extraSeal)...)
+
+		sig, err := crypto.Sign(b.sealHash(header).Bytes(), b.signer)
+		if err != nil {
+			panic(fmt.Sprintf("failed to sign header: %v", err))
+		}
+		copy(header.Extra[len(header.Extra)-extraSeal:], sig)
+	}
+	return header
+}
+
+// Chain builds n consecutive headers on top of parent, applying the given
+// options to each one of them.
+func Chain(parent *types.Header, n int, opts ...Option) []*types.Header {
+	headers := make([]*types.Header, n)
+	for i := 0; i < n; i++ {
+		headers[i] = New(append([]Option{WithParent(parent)}, opts...)...)
+		parent = headers[i]
+	}
+	return headers
+}
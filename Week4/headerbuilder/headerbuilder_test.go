@@ -0,0 +1,124 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package headerbuilder
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Tests that cascading fields are derived from the parent unless overridden.
+func TestParentDerivation(t *testing.T) {
+	parent := New(WithNumber(7), WithTime(1000), WithGasLimit(5000000), WithBaseFee(big.NewInt(7)))
+
+	child := New(WithParent(parent))
+	if child.ParentHash != parent.Hash() {
+		t.Errorf("parent hash mismatch: have %x, want %x", child.ParentHash, parent.Hash())
+	}
+	if child.Number.Uint64() != 8 {
+		t.Errorf("number mismatch: have %d, want %d", child.Number, 8)
+	}
+	if child.Time != 1000+defaultPeriod {
+		t.Errorf("time mismatch: have %d, want %d", child.Time, 1000+defaultPeriod)
+	}
+	if child.GasLimit != 5000000 {
+		t.Errorf("gas limit not inherited: have %d, want %d", child.GasLimit, 5000000)
+	}
+	if err := misc.VerifyEip1559Header(params.TestChainConfig, parent, child); err != nil {
+		t.Errorf("derived base fee rejected: %v", err)
+	}
+	if child := New(WithParent(parent), WithTime(2000)); child.Time != 2000 {
+		t.Errorf("time override lost: have %d, want %d", child.Time, 2000)
+	}
+}
+
+// Tests that the first header past the London fork gets the initial base fee and
+// the doubled gas limit, and that headers before it get no base fee at all.
+func TestLondonTransition(t *testing.T) {
+	config := *params.TestChainConfig
+	config.LondonBlock = big.NewInt(2)
+
+	headers := Chain(New(), 3, WithConfig(&config))
+	if headers[0].BaseFee != nil {
+		t.Errorf("pre-London header has base fee %v", headers[0].BaseFee)
+	}
+	for i := 1; i < len(headers); i++ {
+		if err := misc.VerifyEip1559Header(&config, headers[i-1], headers[i]); err != nil {
+			t.Errorf("header %d: derived fields rejected: %v", i+1, err)
+		}
+	}
+	if headers[1].BaseFee.Uint64() != params.InitialBaseFee {
+		t.Errorf("fork block base fee mismatch: have %v, want %d", headers[1].BaseFee, params.InitialBaseFee)
+	}
+}
+
+// Tests that the memory chain keeps the heaviest branch canonical, while still
+// resolving the headers of side branches.
+func TestMemoryChain(t *testing.T) {
+	genesis := New()
+	main := Chain(genesis, 3)
+	side := Chain(main[0], 3, WithCoinbase(common.Address{0x01}))
+
+	chain := NewMemoryChain(params.TestChainConfig, append([]*types.Header{genesis}, main...)...)
+	chain.Insert(side[:2]...)
+	if head := chain.CurrentHeader(); head.Hash() != main[2].Hash() {
+		t.Errorf("lighter branch became canonical: head #%d [%x]", head.Number, head.Hash())
+	}
+	if header := chain.GetHeaderByHash(side[1].Hash()); header == nil {
+		t.Errorf("side header not resolvable")
+	}
+	if td := chain.GetTd(side[1].Hash(), 3); td.Int64() != 4 {
+		t.Errorf("side td mismatch: have %v, want %d", td, 4)
+	}
+	// Extending the side branch past the main one reorgs onto it
+	chain.Insert(side[2])
+	for number, want := range []*types.Header{genesis, main[0], side[0], side[1], side[2]} {
+		if header := chain.GetHeaderByNumber(uint64(number)); header == nil || header.Hash() != want.Hash() {
+			t.Errorf("canonical #%d mismatch after reorg", number)
+		}
+	}
+	if header := chain.GetHeader(main[2].Hash(), 3); header == nil {
+		t.Errorf("reorged header not resolvable")
+	}
+}
+
+// Tests that signed headers carry a seal the clique engine recovers correctly.
+func TestSignedBy(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+
+	header := New(WithNumber(1), WithDifficulty(big.NewInt(2)), SignedBy(key, clique.SealHash))
+	if len(header.Extra) != extraVanity+extraSeal {
+		t.Fatalf("extra-data length mismatch: have %d, want %d", len(header.Extra), extraVanity+extraSeal)
+	}
+	engine := clique.New(params.AllCliqueProtocolChanges.Clique, rawdb.NewMemoryDatabase())
+	signer, err := engine.Author(header)
+	if err != nil {
+		t.Fatalf("failed to recover signer: %v", err)
+	}
+	if signer != addr {
+		t.Errorf("signer mismatch: have %x, want %x", signer, addr)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hybrid
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the hybrid specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeConflictsFinalized    = -39901
+	codeNotCheckpoint         = -39902
+	codeStaleCheckpoint       = -39903
+	codeUnknownCheckpoint     = -39904
+	codeUnauthorizedValidator = -39905
+	codeDoubleVote            = -39906
+	codeMissingSigner         = -39907
+)
+
+// Register stable numeric codes for the hybrid specific errors.
+func init() {
+	consensus.RegisterErrorCode(errConflictsFinalized, codeConflictsFinalized)
+	consensus.RegisterErrorCode(errNotCheckpoint, codeNotCheckpoint)
+	consensus.RegisterErrorCode(errStaleCheckpoint, codeStaleCheckpoint)
+	consensus.RegisterErrorCode(errUnknownCheckpoint, codeUnknownCheckpoint)
+	consensus.RegisterErrorCode(errUnauthorizedValidator, codeUnauthorizedValidator)
+	consensus.RegisterErrorCode(errDoubleVote, codeDoubleVote)
+	consensus.RegisterErrorCode(errMissingSigner, codeMissingSigner)
+}
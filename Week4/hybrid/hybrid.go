@@ -0,0 +1,478 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package hybrid implements a finality gadget on top of a proof-of-work engine.
+//
+// Blocks are produced and verified by the wrapped proof-of-work engine as usual.
+// Every epoch boundary block is a checkpoint, which a fixed set of validators
+// votes on. Once more than two thirds of the validators voted for the same
+// checkpoint, it is finalized: headers conflicting with it are rejected, no
+// matter how much work they carry. The finalized checkpoint and the pending votes
+// are kept in the chain database, so that neither is forgotten on restart.
+package hybrid
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemoryDescendants = 4096 // Number of recent headers known to descend from the finalized checkpoint
+
+	defaultEpoch = 32 // Default number of blocks between checkpoints
+
+	// mimetypeCheckpoint is the mime type of the checkpoint votes signed by
+	// validators.
+	mimetypeCheckpoint = "application/x-checkpoint-vote"
+)
+
+// finalityKey is the database key of the finality state.
+var finalityKey = []byte("hybrid-finality")
+
+var (
+	// errConflictsFinalized is returned if a header is not on the chain of the
+	// finalized checkpoint.
+	errConflictsFinalized = errors.New("header conflicts with finalized checkpoint")
+
+	// errNotCheckpoint is returned if a vote is cast for a block that is not at
+	// an epoch boundary.
+	errNotCheckpoint = errors.New("vote for non-checkpoint block")
+
+	// errStaleCheckpoint is returned if a vote is cast for a checkpoint at or
+	// below the finalized one.
+	errStaleCheckpoint = errors.New("vote for stale checkpoint")
+
+	// errUnknownCheckpoint is returned if a vote is cast for a checkpoint that is
+	// not known to the local chain.
+	errUnknownCheckpoint = errors.New("unknown checkpoint")
+
+	// errUnauthorizedValidator is returned if a vote is signed by an account
+	// outside of the validator set.
+	errUnauthorizedValidator = errors.New("unauthorized validator")
+
+	// errDoubleVote is returned if a validator votes for two different
+	// checkpoints at the same height.
+	errDoubleVote = errors.New("conflicting vote for checkpoint height")
+
+	// errMissingSigner is returned if the local node is asked to vote without a
+	// signing key.
+	errMissingSigner = errors.New("no signer authorized")
+)
+
+// Config are the configuration parameters of the hybrid engine.
+type Config struct {
+	Epoch      uint64           // Number of blocks between checkpoints
+	Validators []common.Address // Accounts voting on checkpoints
+}
+
+// SignerFn hashes and signs the data to be signed by a backing account.
+type SignerFn func(signer accounts.Account, mimeType string, message []byte) ([]byte, error)
+
+// Vote is a validator's signed statement that a checkpoint should be final.
+type Vote struct {
+	Number    uint64      // Number of the checkpoint block
+	Hash      common.Hash // Hash of the checkpoint block
+	Signature []byte      // Validator's signature over the number and hash
+}
+
+// signingData returns the payload the validator's signature is made over.
+func (v *Vote) signingData() []byte {
+	data, _ := rlp.EncodeToBytes([]interface{}{v.Number, v.Hash})
+	return data
+}
+
+// storedVote is a counted vote, as persisted in the database.
+type storedVote struct {
+	Number    uint64
+	Validator common.Address
+	Hash      common.Hash
+}
+
+// finality is the persisted finality state.
+type finality struct {
+	Finalized *types.Header `rlp:"nil"` // Most recent finalized checkpoint, nil if none
+	Votes     []storedVote  // Votes for checkpoints above the finalized one
+}
+
+// Hybrid is a proof-of-work engine with checkpoint finality by proof-of-stake
+// validator votes.
+type Hybrid struct {
+	pow    consensus.PoW // Engine producing and verifying the blocks
+	config Config
+	db     ethdb.Database // Database to store the finality state to
+
+	finalized   *types.Header                             // Most recent finalized checkpoint, nil if none
+	votes       map[uint64]map[common.Address]common.Hash // Votes of each validator per checkpoint height
+	descendants *lru.ARCCache                             // Headers known to build on the finalized checkpoint
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize votes with
+	lock   sync.RWMutex   // Protects the finality and signer fields
+}
+
+// New creates a hybrid engine producing blocks with the given proof-of-work
+// engine and finalizing them with the configured validators. The finality state
+// stored in the database, if any, is resumed.
+func New(engine consensus.PoW, config Config, db ethdb.Database) *Hybrid {
+	if config.Epoch == 0 {
+		config.Epoch = defaultEpoch
+	}
+	descendants, _ := lru.NewARC(inmemoryDescendants)
+	h := &Hybrid{
+		pow:         engine,
+		config:      config,
+		db:          db,
+		votes:       make(map[uint64]map[common.Address]common.Hash),
+		descendants: descendants,
+	}
+	if blob, err := db.Get(finalityKey); err == nil {
+		stored := new(finality)
+		if err := rlp.DecodeBytes(blob, stored); err != nil {
+			log.Error("Failed to decode finality state", "err", err)
+			return h
+		}
+		h.finalized = stored.Finalized
+		for _, vote := range stored.Votes {
+			if h.votes[vote.Number] == nil {
+				h.votes[vote.Number] = make(map[common.Address]common.Hash)
+			}
+			h.votes[vote.Number][vote.Validator] = vote.Hash
+		}
+	}
+	return h
+}
+
+// store writes the finality state into the database. The caller must hold the
+// lock.
+func (h *Hybrid) store() {
+	stored := &finality{Finalized: h.finalized}
+	for number, votes := range h.votes {
+		for validator, hash := range votes {
+			stored.Votes = append(stored.Votes, storedVote{Number: number, Validator: validator, Hash: hash})
+		}
+	}
+	blob, err := rlp.EncodeToBytes(stored)
+	if err != nil {
+		log.Crit("Failed to encode finality state", "err", err)
+	}
+	if err := h.db.Put(finalityKey, blob); err != nil {
+		log.Crit("Failed to store finality state", "err", err)
+	}
+}
+
+// VerifyHeader implements consensus.Engine, rejecting headers conflicting with
+// the finalized checkpoint before verifying them with the proof-of-work engine.
+func (h *Hybrid) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	descends, err := h.verifyFinality(chain, header)
+	if err != nil {
+		return err
+	}
+	if err := h.pow.VerifyHeader(chain, header, seal); err != nil {
+		return err
+	}
+	if descends {
+		h.descendants.Add(header.Hash(), struct{}{})
+	}
+	return nil
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (h *Hybrid) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	var (
+		abort          = make(chan struct{})
+		results        = make(chan error, len(headers))
+		batch          = consensus.WithHeaders(chain, headers)
+		powAbort, pows = h.pow.VerifyHeaders(chain, headers, seals)
+	)
+	go func() {
+		defer close(powAbort)
+
+		for _, header := range headers {
+			var err error
+			select {
+			case <-abort:
+				return
+			case err = <-pows:
+			}
+			if err == nil {
+				var descends bool
+				if descends, err = h.verifyFinality(batch, header); descends && err == nil {
+					h.descendants.Add(header.Hash(), struct{}{})
+				}
+			}
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyFinality checks that a header is on the chain of the finalized checkpoint,
+// either as its ancestor or as its descendant. Whether it is a descendant is
+// returned too, for the caller to cache once the header is fully verified.
+func (h *Hybrid) verifyFinality(chain consensus.ChainHeaderReader, header *types.Header) (bool, error) {
+	h.lock.RLock()
+	final := h.finalized
+	h.lock.RUnlock()
+
+	if final == nil {
+		return false, nil
+	}
+	number, finalNumber := header.Number.Uint64(), final.Number.Uint64()
+	switch {
+	case number < finalNumber:
+		// The checkpoint may have been finalized on a side chain, so look up its
+		// ancestor rather than the canonical header at the height
+		ancestor := ancestorAt(chain, final, number)
+		if ancestor == nil {
+			return false, consensus.ErrUnknownAncestor
+		}
+		if ancestor.Hash() != header.Hash() {
+			return false, errConflictsFinalized
+		}
+		return false, nil
+
+	case number == finalNumber:
+		if header.Hash() != final.Hash() {
+			return false, errConflictsFinalized
+		}
+		return false, nil
+	}
+	// Walk back to the height of the checkpoint, unless an ancestor is already
+	// known to descend from it
+	parentHash := header.ParentHash
+	for n := number - 1; n > finalNumber; n-- {
+		if h.descendants.Contains(parentHash) {
+			return true, nil
+		}
+		parent := chain.GetHeader(parentHash, n)
+		if parent == nil {
+			return false, consensus.ErrUnknownAncestor
+		}
+		parentHash = parent.ParentHash
+	}
+	if parentHash != final.Hash() {
+		return false, errConflictsFinalized
+	}
+	return true, nil
+}
+
+// ancestorAt returns the ancestor of the header at the given height, or nil if
+// it's not known. The canonical chain is consulted if the header is part of it,
+// otherwise the parents are walked back.
+func ancestorAt(chain consensus.ChainHeaderReader, header *types.Header, number uint64) *types.Header {
+	if canonical := chain.GetHeaderByNumber(header.Number.Uint64()); canonical != nil && canonical.Hash() == header.Hash() {
+		if ancestor := chain.GetHeaderByNumber(number); ancestor != nil {
+			return ancestor
+		}
+	}
+	for header != nil && header.Number.Uint64() > number {
+		header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	}
+	return header
+}
+
+// Authorize injects a private key into the engine to vote on checkpoints with.
+func (h *Hybrid) Authorize(signer common.Address, signFn SignerFn) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.signer = signer
+	h.signFn = signFn
+}
+
+// Vote signs a vote for the given checkpoint with the local validator key and
+// counts it. The returned vote is to be sent to the other nodes, which must pass
+// it to AddVote.
+func (h *Hybrid) Vote(chain consensus.ChainHeaderReader, checkpoint *types.Header) (*Vote, error) {
+	h.lock.RLock()
+	signer, signFn := h.signer, h.signFn
+	h.lock.RUnlock()
+
+	if signFn == nil {
+		return nil, errMissingSigner
+	}
+	vote := &Vote{Number: checkpoint.Number.Uint64(), Hash: checkpoint.Hash()}
+	sig, err := signFn(accounts.Account{Address: signer}, mimetypeCheckpoint, vote.signingData())
+	if err != nil {
+		return nil, err
+	}
+	vote.Signature = sig
+
+	if err := h.AddVote(chain, vote); err != nil {
+		return nil, err
+	}
+	return vote, nil
+}
+
+// AddVote counts a validator's vote for a checkpoint, finalizing the checkpoint
+// once more than two thirds of the validators voted for it.
+func (h *Hybrid) AddVote(chain consensus.ChainHeaderReader, vote *Vote) error {
+	if vote.Number == 0 || vote.Number%h.config.Epoch != 0 {
+		return errNotCheckpoint
+	}
+	pubkey, err := crypto.SigToPub(crypto.Keccak256(vote.signingData()), vote.Signature)
+	if err != nil {
+		return err
+	}
+	validator := crypto.PubkeyToAddress(*pubkey)
+	if !h.isValidator(validator) {
+		return errUnauthorizedValidator
+	}
+	checkpoint := chain.GetHeader(vote.Hash, vote.Number)
+	if checkpoint == nil {
+		return errUnknownCheckpoint
+	}
+	// Only checkpoints building on the finalized one may be voted on
+	if _, err := h.verifyFinality(chain, checkpoint); err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.finalized != nil && vote.Number <= h.finalized.Number.Uint64() {
+		return errStaleCheckpoint
+	}
+	votes := h.votes[vote.Number]
+	if votes == nil {
+		votes = make(map[common.Address]common.Hash)
+		h.votes[vote.Number] = votes
+	}
+	if hash, ok := votes[validator]; ok {
+		if hash != vote.Hash {
+			return errDoubleVote
+		}
+		return nil
+	}
+	votes[validator] = vote.Hash
+
+	var count int
+	for _, hash := range votes {
+		if hash == vote.Hash {
+			count++
+		}
+	}
+	if 3*count > 2*len(h.config.Validators) {
+		h.finalized = checkpoint
+		h.descendants.Purge()
+		for number := range h.votes {
+			if number <= vote.Number {
+				delete(h.votes, number)
+			}
+		}
+		log.Info("Finalized checkpoint", "number", vote.Number, "hash", vote.Hash)
+	}
+	h.store()
+	return nil
+}
+
+// isValidator reports whether the account takes part in checkpoint votes.
+func (h *Hybrid) isValidator(address common.Address) bool {
+	for _, validator := range h.config.Validators {
+		if validator == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Finalized implements consensus.Finalizer, returning the most recent checkpoint
+// finalized by the validators.
+func (h *Hybrid) Finalized(chain consensus.ChainHeaderReader) *types.Header {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.finalized
+}
+
+// Author implements consensus.Engine, returning the miner of the block.
+func (h *Hybrid) Author(header *types.Header) (common.Address, error) {
+	return h.pow.Author(header)
+}
+
+// VerifyUncles implements consensus.Engine, deferring to the proof-of-work
+// engine.
+func (h *Hybrid) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	return h.pow.VerifyUncles(chain, block)
+}
+
+// Prepare implements consensus.Engine, deferring to the proof-of-work engine.
+func (h *Hybrid) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	return h.pow.Prepare(chain, header)
+}
+
+// Finalize implements consensus.Engine, deferring to the proof-of-work engine.
+func (h *Hybrid) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	h.pow.Finalize(chain, header, state, txs, uncles)
+}
+
+// FinalizeAndAssemble implements consensus.Engine, deferring to the
+// proof-of-work engine.
+func (h *Hybrid) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	return h.pow.FinalizeAndAssemble(chain, header, state, txs, uncles, receipts)
+}
+
+// Seal implements consensus.Engine, mining the block with the proof-of-work
+// engine.
+func (h *Hybrid) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	return h.pow.Seal(chain, block, results, stop)
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (h *Hybrid) SealHash(header *types.Header) common.Hash {
+	return h.pow.SealHash(header)
+}
+
+// CalcDifficulty implements consensus.Engine, deferring to the proof-of-work
+// engine.
+func (h *Hybrid) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	return h.pow.CalcDifficulty(chain, time, parent)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs of the
+// proof-of-work engine.
+func (h *Hybrid) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return h.pow.APIs(chain)
+}
+
+// Hashrate implements consensus.PoW, returning the hashrate of the proof-of-work
+// engine.
+func (h *Hybrid) Hashrate() float64 {
+	return h.pow.Hashrate()
+}
+
+// Close implements consensus.Engine, terminating the proof-of-work engine.
+func (h *Hybrid) Close() error {
+	return h.pow.Close()
+}
@@ -0,0 +1,252 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package hybrid
+
+import (
+	"crypto/ecdsa"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/faker"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// testPoW is an always valid proof-of-work engine.
+type testPoW struct {
+	*faker.Faker
+}
+
+func (testPoW) Hashrate() float64 { return 0 }
+
+// signVote creates a vote for the checkpoint signed by the given key.
+func signVote(key *ecdsa.PrivateKey, checkpoint *types.Header) *Vote {
+	vote := &Vote{Number: checkpoint.Number.Uint64(), Hash: checkpoint.Hash()}
+	vote.Signature, _ = crypto.Sign(crypto.Keccak256(vote.signingData()), key)
+	return vote
+}
+
+// Tests that a checkpoint is finalized by more than two thirds of the validators,
+// after which headers conflicting with it are rejected.
+func TestCheckpointFinality(t *testing.T) {
+	leakcheck.Check(t)
+
+	keys := make([]*ecdsa.PrivateKey, 4)
+	validators := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		validators[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	genesis := headerbuilder.New()
+	headers := append([]*types.Header{genesis}, headerbuilder.Chain(genesis, 8)...)
+	chain := headerbuilder.NewMemoryChain(params.AllEthashProtocolChanges, headers...)
+
+	fork4 := headerbuilder.New(headerbuilder.WithParent(headers[3]), headerbuilder.WithCoinbase(common.Address{0x01}))
+	fork5 := headerbuilder.New(headerbuilder.WithParent(fork4))
+	fork3 := headerbuilder.New(headerbuilder.WithParent(headers[2]), headerbuilder.WithCoinbase(common.Address{0x01}))
+	chain.Insert(fork4, fork5, fork3)
+
+	engine := New(testPoW{faker.New()}, Config{Epoch: 4, Validators: validators}, rawdb.NewMemoryDatabase())
+	checkpoint := headers[4]
+
+	// Collect votes, rejecting invalid ones along the way
+	outsider, _ := crypto.GenerateKey()
+	votes := []struct {
+		vote *Vote
+		err  error
+	}{
+		{signVote(keys[0], checkpoint), nil},
+		{signVote(keys[1], checkpoint), nil},
+		{signVote(keys[1], checkpoint), nil},
+		{signVote(keys[1], fork4), errDoubleVote},
+		{signVote(outsider, checkpoint), errUnauthorizedValidator},
+		{signVote(keys[2], headers[3]), errNotCheckpoint},
+		{signVote(keys[2], checkpoint), nil},
+		{signVote(keys[3], checkpoint), errStaleCheckpoint},
+		{signVote(keys[3], fork4), errConflictsFinalized},
+	}
+	for i, tt := range votes {
+		if i == 6 && engine.Finalized(chain) != nil {
+			t.Fatalf("checkpoint finalized without quorum")
+		}
+		if err := engine.AddVote(chain, tt.vote); err != tt.err {
+			t.Errorf("vote %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	if final := engine.Finalized(chain); final == nil || final.Hash() != checkpoint.Hash() {
+		t.Fatalf("checkpoint #%d not finalized", checkpoint.Number)
+	}
+	// Verify headers on and off the finalized chain
+	verified := []struct {
+		header *types.Header
+		err    error
+	}{
+		{headers[3], nil},
+		{checkpoint, nil},
+		{headers[8], nil},
+		{fork3, errConflictsFinalized},
+		{fork4, errConflictsFinalized},
+		{fork5, errConflictsFinalized},
+	}
+	for i, tt := range verified {
+		if err := engine.VerifyHeader(chain, tt.header, true); err != tt.err {
+			t.Errorf("header %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	// Verify a side chain batch, whose headers are not stored yet
+	batch := headerbuilder.Chain(fork5, 2)
+	abort, results := engine.VerifyHeaders(chain, batch, []bool{true, true})
+	defer close(abort)
+
+	for i := range batch {
+		if err := <-results; err != errConflictsFinalized {
+			t.Errorf("batch header %d: error mismatch: have %v, want %v", i, err, errConflictsFinalized)
+		}
+	}
+}
+
+// Tests that a checkpoint finalized off the canonical chain decides the ancestry
+// below it: its ancestors are accepted, and the canonical headers replaced by
+// them are rejected.
+func TestSideChainFinality(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	validators := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		validators[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	genesis := headerbuilder.New()
+	headers := append([]*types.Header{genesis}, headerbuilder.Chain(genesis, 8)...)
+	chain := headerbuilder.NewMemoryChain(params.AllEthashProtocolChanges, headers...)
+
+	fork3 := headerbuilder.New(headerbuilder.WithParent(headers[2]), headerbuilder.WithCoinbase(common.Address{0x01}))
+	fork4 := headerbuilder.New(headerbuilder.WithParent(fork3))
+	fork5 := headerbuilder.New(headerbuilder.WithParent(fork4))
+	chain.Insert(fork3, fork4, fork5)
+	if head := chain.CurrentHeader(); head.Hash() != headers[8].Hash() {
+		t.Fatalf("side chain became canonical")
+	}
+	engine := New(testPoW{faker.New()}, Config{Epoch: 4, Validators: validators}, rawdb.NewMemoryDatabase())
+	for _, key := range keys[:3] {
+		if err := engine.AddVote(chain, signVote(key, fork4)); err != nil {
+			t.Fatalf("failed to add vote: %v", err)
+		}
+	}
+	if final := engine.Finalized(chain); final == nil || final.Hash() != fork4.Hash() {
+		t.Fatalf("side chain checkpoint not finalized")
+	}
+	verified := []struct {
+		header *types.Header
+		err    error
+	}{
+		{headers[2], nil},
+		{fork3, nil},
+		{fork5, nil},
+		{headers[3], errConflictsFinalized},
+		{headers[4], errConflictsFinalized},
+		{headers[5], errConflictsFinalized},
+	}
+	for i, tt := range verified {
+		if err := engine.VerifyHeader(chain, tt.header, true); err != tt.err {
+			t.Errorf("header %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	if err := engine.AddVote(chain, signVote(keys[0], headers[8])); err != errConflictsFinalized {
+		t.Errorf("vote for canonical checkpoint: have %v, want %v", err, errConflictsFinalized)
+	}
+}
+
+// Tests that the finalized checkpoint and the pending votes survive a restart.
+func TestFinalityPersistence(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 4)
+	validators := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		validators[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	genesis := headerbuilder.New()
+	headers := append([]*types.Header{genesis}, headerbuilder.Chain(genesis, 8)...)
+	fork8 := headerbuilder.New(headerbuilder.WithParent(headers[7]), headerbuilder.WithCoinbase(common.Address{0x01}))
+	chain := headerbuilder.NewMemoryChain(params.AllEthashProtocolChanges, headers...)
+	chain.Insert(fork8)
+
+	var (
+		db     = rawdb.NewMemoryDatabase()
+		config = Config{Epoch: 4, Validators: validators}
+		engine = New(testPoW{faker.New()}, config, db)
+	)
+	for _, key := range keys[:3] {
+		if err := engine.AddVote(chain, signVote(key, headers[4])); err != nil {
+			t.Fatalf("failed to add vote: %v", err)
+		}
+	}
+	if err := engine.AddVote(chain, signVote(keys[0], headers[8])); err != nil {
+		t.Fatalf("failed to add pending vote: %v", err)
+	}
+	// Restart the engine and check that nothing was forgotten
+	engine = New(testPoW{faker.New()}, config, db)
+	if final := engine.Finalized(chain); final == nil || final.Hash() != headers[4].Hash() {
+		t.Fatalf("finalized checkpoint lost: have %v, want #%d", final, headers[4].Number)
+	}
+	if err := engine.AddVote(chain, signVote(keys[0], fork8)); err != errDoubleVote {
+		t.Errorf("pending vote lost: have %v, want %v", err, errDoubleVote)
+	}
+	fork3 := headerbuilder.New(headerbuilder.WithParent(headers[2]), headerbuilder.WithCoinbase(common.Address{0x01}))
+	if err := engine.VerifyHeader(chain, fork3, true); err != errConflictsFinalized {
+		t.Errorf("conflicting header: have %v, want %v", err, errConflictsFinalized)
+	}
+}
+
+// Tests that only headers passing the proof-of-work verification are cached as
+// descendants of the finalized checkpoint.
+func TestDescendantsVerified(t *testing.T) {
+	leakcheck.Check(t)
+
+	key, _ := crypto.GenerateKey()
+	genesis := headerbuilder.New()
+	headers := append([]*types.Header{genesis}, headerbuilder.Chain(genesis, 8)...)
+	chain := headerbuilder.NewMemoryChain(params.AllEthashProtocolChanges, headers...)
+
+	pow := faker.New()
+	engine := New(testPoW{pow}, Config{Epoch: 4, Validators: []common.Address{crypto.PubkeyToAddress(key.PublicKey)}}, rawdb.NewMemoryDatabase())
+	if err := engine.AddVote(chain, signVote(key, headers[4])); err != nil {
+		t.Fatalf("failed to finalize checkpoint: %v", err)
+	}
+	pow.FailAt(6, nil)
+	if err := engine.VerifyHeader(chain, headers[6], true); err == nil {
+		t.Fatalf("invalid proof-of-work accepted")
+	}
+	abort, results := engine.VerifyHeaders(chain, headers[6:8], []bool{true, true})
+	defer close(abort)
+	<-results
+	<-results
+
+	if engine.descendants.Contains(headers[6].Hash()) {
+		t.Errorf("header with invalid proof-of-work cached as descendant")
+	}
+	if !engine.descendants.Contains(headers[7].Hash()) {
+		t.Errorf("verified header not cached as descendant")
+	}
+}
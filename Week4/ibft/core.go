@@ -0,0 +1,479 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/ethereum/go-ethereum/consensus"
+)
+
+// messageCode identifies the phase a consensus message belongs to.
+type messageCode uint8
+
+const (
+	msgPreprepare  messageCode = iota // Proposer announces the block of the round
+	msgPrepare                        // Validator accepted the proposal
+	msgCommit                         // Validator saw a quorum of prepares and commits
+	msgRoundChange                    // Validator gave up on the round
+)
+
+// maxRoundShift caps the exponential growth of the round timeout.
+const maxRoundShift = 10
+
+var (
+	// errOldMessage is returned for messages of a past height or round.
+	errOldMessage = errors.New("old message")
+
+	// errFutureMessage is returned for messages of a future height or round,
+	// which are dropped, as the sender will repeat them if still relevant.
+	errFutureMessage = errors.New("future message")
+
+	// errLockedProposal is returned if the proposal of a round differs from the
+	// block the local validator locked on in an earlier round.
+	errLockedProposal = errors.New("proposal conflicts with locked block")
+
+	// errUnknownMessage is returned for messages with an unknown code.
+	errUnknownMessage = errors.New("unknown message code")
+
+	// errStaleBlock is returned if a block is offered for sealing that does not
+	// extend the current chain head.
+	errStaleBlock = errors.New("block not on top of the chain head")
+)
+
+// message is a signed consensus message exchanged between validators.
+type message struct {
+	Code      uint8
+	Height    uint64
+	Round     uint64
+	Digest    common.Hash // Round hash of the proposal voted on (prepare, commit)
+	Proposal  []byte      // RLP encoded proposed block (pre-prepare)
+	Seal      []byte      // Committed seal of the sender (commit)
+	Signature []byte      // Sender's signature over all the fields above
+}
+
+// signingData returns the payload the sender's signature is made over.
+func (m *message) signingData() []byte {
+	data, _ := rlp.EncodeToBytes([]interface{}{m.Code, m.Height, m.Round, m.Digest, m.Proposal, m.Seal})
+	return data
+}
+
+// sealTask is a block handed to the engine for sealing.
+type sealTask struct {
+	block   *types.Block
+	results chan<- *types.Block
+}
+
+// core is the consensus state machine, agreeing on one block per height.
+type core struct {
+	engine  *IBFT
+	chain   consensus.ChainHeaderReader
+	network Broadcaster
+	address common.Address // Address of the local validator
+
+	height     uint64                             // Block number currently being agreed on
+	round      uint64                             // Current round within the height
+	validators []common.Address                   // Validator set of the current height
+	proposal   *types.Block                       // Proposal accepted in the current round
+	locked     *types.Block                       // Block prepared in an earlier round, the only one to vote for
+	prepares   map[common.Address]common.Hash     // Digests prepared by each validator in the round
+	commits    map[common.Address]*message        // Commits of each validator in the round
+	changes    map[uint64]map[common.Address]bool // Round change requests per future round
+	prepared   bool                               // Whether a quorum prepared the proposal
+	committed  bool                               // Whether the height has been decided
+
+	pending *sealTask   // Local block waiting to be proposed
+	timer   *time.Timer // Round timeout
+	quit    chan struct{}
+
+	lock sync.Mutex
+}
+
+// newCore creates a consensus state machine on top of the given chain.
+func newCore(engine *IBFT, chain consensus.ChainHeaderReader, network Broadcaster) *core {
+	return &core{
+		engine:  engine,
+		chain:   chain,
+		network: network,
+		address: engine.signer,
+		quit:    make(chan struct{}),
+	}
+}
+
+// stop terminates the round timer.
+func (c *core) stop() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	close(c.quit)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// sync moves the state machine to the height following the chain head, if it
+// isn't there yet.
+func (c *core) sync() {
+	head := c.chain.CurrentHeader()
+	height := head.Number.Uint64() + 1
+	if height == c.height && c.validators != nil {
+		return
+	}
+	extra, err := extractExtra(head)
+	if err != nil {
+		log.Error("Failed to decode head extra-data", "number", head.Number, "err", err)
+		return
+	}
+	c.height, c.validators, c.locked, c.changes = height, extra.Validators, nil, make(map[uint64]map[common.Address]bool)
+	if c.pending != nil && c.pending.block.NumberU64() != height {
+		c.pending = nil
+	}
+	c.startRound(0)
+}
+
+// startRound resets the round state and, if the local validator is in charge,
+// proposes a block.
+func (c *core) startRound(round uint64) {
+	c.round = round
+	c.proposal, c.prepared, c.committed = nil, false, false
+	c.prepares = make(map[common.Address]common.Hash)
+	c.commits = make(map[common.Address]*message)
+	for r := range c.changes {
+		if r <= round {
+			delete(c.changes, r)
+		}
+	}
+	c.startTimer()
+	c.propose()
+}
+
+// startTimer (re)arms the round timeout, doubling it with every round.
+func (c *core) startTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	shift := c.round
+	if shift > maxRoundShift {
+		shift = maxRoundShift
+	}
+	height, round := c.height, c.round
+	c.timer = time.AfterFunc(c.engine.config.RequestTimeout<<shift, func() {
+		c.timeout(height, round)
+	})
+}
+
+// timeout requests a round change if the round didn't complete in time.
+func (c *core) timeout(height, round uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.quit:
+		return
+	default:
+	}
+	if c.height != height || c.round != round || c.committed {
+		return
+	}
+	log.Debug("Istanbul round timed out", "height", height, "round", round)
+	c.broadcast(&message{Code: uint8(msgRoundChange), Height: height, Round: round + 1})
+	c.startTimer()
+}
+
+// seal registers a locally assembled block, proposing it if it's our turn.
+func (c *core) seal(block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sync()
+	if block.NumberU64() != c.height {
+		return errStaleBlock
+	}
+	task := &sealTask{block: block, results: results}
+	c.pending = task
+
+	if c.proposal == nil {
+		c.propose()
+	}
+	go func() {
+		select {
+		case <-stop:
+		case <-c.quit:
+			return
+		}
+		c.lock.Lock()
+		if c.pending == task {
+			c.pending = nil
+		}
+		c.lock.Unlock()
+	}()
+	return nil
+}
+
+// propose broadcasts a pre-prepare for the current round if the local validator
+// is its proposer, re-proposing the locked block if there is one.
+func (c *core) propose() {
+	if c.proposal != nil || proposer(c.validators, c.height, c.round) != c.address {
+		return
+	}
+	block := c.locked
+	if block == nil && c.pending != nil {
+		block = c.pending.block
+	}
+	if block == nil {
+		return
+	}
+	header := types.CopyHeader(block.Header())
+	extra, err := extractExtra(header)
+	if err != nil {
+		log.Error("Failed to decode proposal extra-data", "err", err)
+		return
+	}
+	extra.Round, extra.Seal, extra.CommittedSeal = c.round, nil, nil
+	if err := writeExtra(header, extra); err != nil {
+		log.Error("Failed to encode proposal extra-data", "err", err)
+		return
+	}
+	if extra.Seal, err = c.engine.sign(roundHash(header).Bytes()); err != nil {
+		log.Error("Failed to seal proposal", "err", err)
+		return
+	}
+	if err := writeExtra(header, extra); err != nil {
+		log.Error("Failed to encode proposal extra-data", "err", err)
+		return
+	}
+	proposal, err := rlp.EncodeToBytes(block.WithSeal(header))
+	if err != nil {
+		log.Error("Failed to encode proposal", "err", err)
+		return
+	}
+	c.broadcast(&message{Code: uint8(msgPreprepare), Height: c.height, Round: c.round, Proposal: proposal})
+}
+
+// broadcast signs a message, sends it to the other validators and processes it
+// locally too.
+func (c *core) broadcast(msg *message) {
+	sig, err := c.engine.sign(msg.signingData())
+	if err != nil {
+		log.Error("Failed to sign consensus message", "err", err)
+		return
+	}
+	msg.Signature = sig
+
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		log.Error("Failed to encode consensus message", "err", err)
+		return
+	}
+	if err := c.network.Broadcast(payload); err != nil {
+		log.Warn("Failed to broadcast consensus message", "err", err)
+	}
+	if err := c.process(msg, c.address); err != nil {
+		log.Debug("Failed to process own consensus message", "code", msg.Code, "err", err)
+	}
+}
+
+// handle decodes, authenticates and processes a message of another validator.
+func (c *core) handle(payload []byte) error {
+	msg := new(message)
+	if err := rlp.DecodeBytes(payload, msg); err != nil {
+		return err
+	}
+	sender, err := recoverSigner(msg.signingData(), msg.Signature)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.sync()
+	return c.process(msg, sender)
+}
+
+// process dispatches an authenticated message to its phase handler.
+func (c *core) process(msg *message, sender common.Address) error {
+	switch {
+	case msg.Height < c.height:
+		return errOldMessage
+	case msg.Height > c.height:
+		return errFutureMessage
+	case !contains(c.validators, sender):
+		return errUnauthorizedValidator
+	}
+	if messageCode(msg.Code) == msgRoundChange {
+		return c.handleRoundChange(msg, sender)
+	}
+	switch {
+	case msg.Round < c.round:
+		return errOldMessage
+	case msg.Round > c.round:
+		return errFutureMessage
+	}
+	switch messageCode(msg.Code) {
+	case msgPreprepare:
+		return c.handlePreprepare(msg, sender)
+	case msgPrepare:
+		c.prepares[sender] = msg.Digest
+		c.checkPrepared()
+		return nil
+	case msgCommit:
+		return c.handleCommit(msg, sender)
+	default:
+		return errUnknownMessage
+	}
+}
+
+// handlePreprepare validates the proposal of the round and, if acceptable,
+// prepares it.
+func (c *core) handlePreprepare(msg *message, sender common.Address) error {
+	if sender != proposer(c.validators, c.height, c.round) {
+		return errInvalidProposer
+	}
+	if c.proposal != nil {
+		return nil // Already accepted a proposal this round
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(msg.Proposal, block); err != nil {
+		return err
+	}
+	if block.NumberU64() != c.height {
+		return errStaleBlock
+	}
+	if extra, err := extractExtra(block.Header()); err != nil || extra.Round != c.round {
+		return errInvalidProposer
+	}
+	if err := c.engine.verifyHeader(c.chain, block.Header(), nil, false); err != nil {
+		return err
+	}
+	if c.locked != nil && SealHash(block.Header()) != SealHash(c.locked.Header()) {
+		return errLockedProposal
+	}
+	c.proposal = block
+	c.broadcast(&message{Code: uint8(msgPrepare), Height: c.height, Round: c.round, Digest: roundHash(block.Header())})
+
+	// Votes may have arrived before the proposal itself
+	c.checkPrepared()
+	c.checkCommitted()
+	return nil
+}
+
+// checkPrepared locks on the proposal and commits to it once a quorum of
+// validators prepared it.
+func (c *core) checkPrepared() {
+	if c.proposal == nil || c.prepared {
+		return
+	}
+	digest := roundHash(c.proposal.Header())
+
+	var votes int
+	for _, prepared := range c.prepares {
+		if prepared == digest {
+			votes++
+		}
+	}
+	if votes < quorum(len(c.validators)) {
+		return
+	}
+	seal, err := c.engine.sign(commitData(digest))
+	if err != nil {
+		log.Error("Failed to sign committed seal", "err", err)
+		return
+	}
+	c.prepared, c.locked = true, c.proposal
+	c.broadcast(&message{Code: uint8(msgCommit), Height: c.height, Round: c.round, Digest: digest, Seal: seal})
+}
+
+// handleCommit records the committed seal of a validator.
+func (c *core) handleCommit(msg *message, sender common.Address) error {
+	signer, err := recoverSigner(commitData(msg.Digest), msg.Seal)
+	if err != nil || signer != sender {
+		return errInvalidCommittedSeals
+	}
+	c.commits[sender] = msg
+	c.checkCommitted()
+	return nil
+}
+
+// checkCommitted finalizes the proposal once a quorum of validators committed to
+// it, embedding their seals. If the block is the one the local sealer offered,
+// it's handed back to it; blocks of other proposers reach the chain through the
+// block propagation of their own proposer.
+func (c *core) checkCommitted() {
+	if c.proposal == nil || c.committed {
+		return
+	}
+	digest := roundHash(c.proposal.Header())
+
+	var seals [][]byte
+	for _, validator := range c.validators {
+		if commit, ok := c.commits[validator]; ok && commit.Digest == digest {
+			seals = append(seals, commit.Seal)
+		}
+	}
+	if len(seals) < quorum(len(c.validators)) {
+		return
+	}
+	header := c.proposal.Header()
+	extra, err := extractExtra(header)
+	if err != nil {
+		return
+	}
+	extra.CommittedSeal = seals
+	if err := writeExtra(header, extra); err != nil {
+		log.Error("Failed to encode committed extra-data", "err", err)
+		return
+	}
+	c.committed = true
+	c.timer.Stop()
+
+	block := c.proposal.WithSeal(header)
+	log.Info("Istanbul block committed", "number", c.height, "round", c.round, "hash", block.Hash())
+
+	if c.pending != nil && SealHash(c.pending.block.Header()) == SealHash(header) {
+		select {
+		case c.pending.results <- block:
+		default:
+			log.Warn("Sealing result is not read by miner", "sealhash", SealHash(header))
+		}
+		c.pending = nil
+	}
+}
+
+// handleRoundChange moves to a future round once a quorum of validators asked
+// for it.
+func (c *core) handleRoundChange(msg *message, sender common.Address) error {
+	if msg.Round <= c.round {
+		return errOldMessage
+	}
+	if c.changes[msg.Round] == nil {
+		c.changes[msg.Round] = make(map[common.Address]bool)
+	}
+	c.changes[msg.Round][sender] = true
+
+	if len(c.changes[msg.Round]) >= quorum(len(c.validators)) && !c.committed {
+		log.Debug("Istanbul round change", "height", c.height, "round", msg.Round)
+		c.startRound(msg.Round)
+	}
+	return nil
+}
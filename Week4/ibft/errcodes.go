@@ -0,0 +1,62 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the ibft specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeOldMessage                 = -39701
+	codeFutureMessage              = -39702
+	codeLockedProposal             = -39703
+	codeUnknownMessage             = -39704
+	codeStaleBlock                 = -39705
+	codeUnknownBlock               = -39706
+	codeInvalidNonce               = -39707
+	codeInvalidUncleHash           = -39708
+	codeInvalidDifficulty          = -39709
+	codeInvalidTimestamp           = -39710
+	codeMismatchingValidators      = -39711
+	codeInvalidProposer            = -39712
+	codeInvalidCommittedSeals      = -39713
+	codeInsufficientCommittedSeals = -39714
+	codeUnauthorizedValidator      = -39715
+	codeMissingSigner              = -39716
+	codeNotStarted                 = -39717
+)
+
+// Register stable numeric codes for the ibft specific errors.
+func init() {
+	consensus.RegisterErrorCode(errOldMessage, codeOldMessage)
+	consensus.RegisterErrorCode(errFutureMessage, codeFutureMessage)
+	consensus.RegisterErrorCode(errLockedProposal, codeLockedProposal)
+	consensus.RegisterErrorCode(errUnknownMessage, codeUnknownMessage)
+	consensus.RegisterErrorCode(errStaleBlock, codeStaleBlock)
+	consensus.RegisterErrorCode(errUnknownBlock, codeUnknownBlock)
+	consensus.RegisterErrorCode(errInvalidNonce, codeInvalidNonce)
+	consensus.RegisterErrorCode(errInvalidUncleHash, codeInvalidUncleHash)
+	consensus.RegisterErrorCode(errInvalidDifficulty, codeInvalidDifficulty)
+	consensus.RegisterErrorCode(errInvalidTimestamp, codeInvalidTimestamp)
+	consensus.RegisterErrorCode(errMismatchingValidators, codeMismatchingValidators)
+	consensus.RegisterErrorCode(errInvalidProposer, codeInvalidProposer)
+	consensus.RegisterErrorCode(errInvalidCommittedSeals, codeInvalidCommittedSeals)
+	consensus.RegisterErrorCode(errInsufficientCommittedSeals, codeInsufficientCommittedSeals)
+	consensus.RegisterErrorCode(errUnauthorizedValidator, codeUnauthorizedValidator)
+	consensus.RegisterErrorCode(errMissingSigner, codeMissingSigner)
+	consensus.RegisterErrorCode(errNotStarted, codeNotStarted)
+}
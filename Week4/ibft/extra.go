@@ -0,0 +1,112 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// extraVanity is the fixed number of extra-data prefix bytes reserved for vanity.
+const extraVanity = 32
+
+// errInvalidExtra is returned if a header's extra-data cannot be decoded.
+var errInvalidExtra = errors.New("invalid istanbul extra-data")
+
+// istanbulExtra is the consensus data stored in the extra-data of every header,
+// after the vanity prefix.
+type istanbulExtra struct {
+	Validators    []common.Address // Validator set in charge of the next block
+	Round         uint64           // Consensus round the block was committed in
+	Seal          []byte           // Proposer's signature over the seal hash
+	CommittedSeal [][]byte         // Commit signatures of at least a quorum of validators
+}
+
+// extractExtra decodes the istanbul specific part of a header's extra-data.
+func extractExtra(header *types.Header) (*istanbulExtra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errInvalidExtra
+	}
+	extra := new(istanbulExtra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, errInvalidExtra
+	}
+	return extra, nil
+}
+
+// writeExtra replaces the istanbul specific part of a header's extra-data,
+// keeping (and padding if needed) the vanity.
+func writeExtra(header *types.Header, extra *istanbulExtra) error {
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return err
+	}
+	vanity := make([]byte, extraVanity)
+	copy(vanity, header.Extra)
+	header.Extra = append(vanity, payload...)
+	return nil
+}
+
+// SealHash returns the hash of a header prior to it being sealed, which is the
+// hash of the header with the round, the proposer seal and the committed seals
+// stripped. It identifies a block independently of the round it was committed
+// in, so the miner can match the committed block to the task it assembled, and
+// a block locked in one round can be recognized when proposed again.
+func SealHash(header *types.Header) common.Hash {
+	return filteredHash(header, true)
+}
+
+// roundHash returns the hash of a header with the proposer seal and committed
+// seals stripped, but the round kept. This is the digest the proposer seals and
+// validators vote on, so votes of one round can't be replayed in another.
+func roundHash(header *types.Header) common.Hash {
+	return filteredHash(header, false)
+}
+
+// filteredHash hashes a header with its seals, and optionally the round, removed
+// from the extra-data.
+func filteredHash(header *types.Header, noRound bool) common.Hash {
+	filtered := types.CopyHeader(header)
+	if extra, err := extractExtra(filtered); err == nil {
+		extra.Seal, extra.CommittedSeal = nil, nil
+		if noRound {
+			extra.Round = 0
+		}
+		writeExtra(filtered, extra)
+	}
+	return filtered.Hash()
+}
+
+// commitData is the payload validators sign when committing to a digest. It is
+// domain separated from the proposer seal by a trailing message code.
+func commitData(digest common.Hash) []byte {
+	return append(digest.Bytes(), byte(msgCommit))
+}
+
+// recoverSigner extracts the address that produced a signature over the hash of
+// the given data.
+func recoverSigner(data []byte, sig []byte) (common.Address, error) {
+	pubkey, err := crypto.SigToPub(crypto.Keccak256(data), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
@@ -0,0 +1,475 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ibft implements an Istanbul BFT style consensus engine for permissioned
+// networks, where a block is final as soon as it is committed by a quorum of the
+// validator set.
+//
+// Every block goes through a pre-prepare, prepare and commit phase among the
+// validators. The proposer of each round is picked round-robin; if a round does
+// not complete in time, the validators move on to the next round and proposer.
+// The commit signatures of at least 2f+1 out of 3f+1 validators are stored in
+// the header, so anyone can verify a block was agreed upon without having taken
+// part in the protocol.
+package ibft
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+
+	defaultPeriod         = 1                // Default minimum number of seconds between blocks
+	defaultRequestTimeout = 10 * time.Second // Default timeout of the first round at every height
+
+	// mimetypeIstanbul is the mime type of the data signed by validators.
+	mimetypeIstanbul = "application/x-istanbul"
+)
+
+// Istanbul protocol constants.
+var (
+	defaultDifficulty = big.NewInt(1)            // Every block has the same difficulty, forks are impossible
+	nilUncleHash      = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidNonce is returned if a block's nonce is non-zero.
+	errInvalidNonce = errors.New("non-zero nonce")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errMismatchingValidators is returned if a block's validator list differs
+	// from its parent's.
+	errMismatchingValidators = errors.New("mismatching validator list")
+
+	// errInvalidProposer is returned if a block is not sealed by the proposer of
+	// the round it claims to be committed in.
+	errInvalidProposer = errors.New("invalid proposer")
+
+	// errInvalidCommittedSeals is returned if a committed seal is not signed by
+	// a validator, or by one that already committed.
+	errInvalidCommittedSeals = errors.New("invalid committed seals")
+
+	// errInsufficientCommittedSeals is returned if a block carries fewer than a
+	// quorum of committed seals.
+	errInsufficientCommittedSeals = errors.New("insufficient committed seals")
+
+	// errUnauthorizedValidator is returned if a consensus message is signed by
+	// an account outside of the validator set.
+	errUnauthorizedValidator = errors.New("unauthorized validator")
+
+	// errMissingSigner is returned if the local node is asked to take part in the
+	// consensus without a signing key.
+	errMissingSigner = errors.New("no signer authorized")
+
+	// errNotStarted is returned if the engine is asked to seal or handle messages
+	// before being connected to the network.
+	errNotStarted = errors.New("consensus engine not started")
+)
+
+// Config are the configuration parameters of the Istanbul engine.
+type Config struct {
+	Period         uint64        // Minimum number of seconds between blocks
+	RequestTimeout time.Duration // Timeout of the first round, doubled with every round change
+}
+
+// Broadcaster delivers consensus messages to all other validators. Delivery must
+// be asynchronous, as the engine broadcasts while processing messages itself.
+type Broadcaster interface {
+	Broadcast(payload []byte) error
+}
+
+// SignerFn hashes and signs the data to be signed by a backing account.
+type SignerFn func(signer accounts.Account, mimeType string, message []byte) ([]byte, error)
+
+// IBFT is the Istanbul Byzantine fault tolerant consensus engine.
+type IBFT struct {
+	config     Config
+	signatures *lru.ARCCache // Proposers of recent blocks to speed up author lookups
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer and core fields
+
+	core *core // Consensus state machine, running once started
+}
+
+// New creates an Istanbul BFT consensus engine. The validator set is taken from
+// the extra-data of the genesis block.
+func New(config Config) *IBFT {
+	if config.Period == 0 {
+		config.Period = defaultPeriod
+	}
+	if config.RequestTimeout == 0 {
+		config.RequestTimeout = defaultRequestTimeout
+	}
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	return &IBFT{
+		config:     config,
+		signatures: signatures,
+	}
+}
+
+// quorum returns the number of validators needed to agree on a block, being
+// 2f+1 for a set of 3f+1 validators, or more precisely ceil(2n/3).
+func quorum(validators int) int {
+	return (2*validators + 2) / 3
+}
+
+// proposer returns the validator in charge of proposing the block at the given
+// height and round.
+func proposer(validators []common.Address, number uint64, round uint64) common.Address {
+	if len(validators) == 0 {
+		return common.Address{}
+	}
+	return validators[(number+round)%uint64(len(validators))]
+}
+
+// Author implements consensus.Engine, returning the address of the proposer that
+// sealed the block.
+func (e *IBFT) Author(header *types.Header) (common.Address, error) {
+	hash := header.Hash()
+	if address, known := e.signatures.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	signer, err := recoverSigner(roundHash(header).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	e.signatures.Add(hash, signer)
+	return signer, nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules, including
+// the quorum of committed seals.
+func (e *IBFT) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return e.verifyHeader(chain, header, nil, true)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (e *IBFT) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	return consensus.VerifyHeadersSequential(headers, func(i int) error {
+		return e.verifyHeader(chain, headers[i], headers[:i], true)
+	})
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database. Committed seals are only checked if
+// requested, as proposals don't carry them yet.
+func (e *IBFT) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header, commits bool) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	// Ensure the fields meaningless for BFT consensus are left empty
+	if header.Nonce != (types.BlockNonce{}) {
+		return errInvalidNonce
+	}
+	if header.UncleHash != nilUncleHash {
+		return errInvalidUncleHash
+	}
+	number := header.Number.Uint64()
+	if number > 0 && (header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0) {
+		return errInvalidDifficulty
+	}
+	// Verify that the gas limit is <= 2^63-1
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, params.MaxGasLimit)
+	}
+	// The genesis block is the always valid dead-end
+	if number == 0 {
+		return nil
+	}
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+e.config.Period > header.Time {
+		return errInvalidTimestamp
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, want <nil>", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// The validator set is static, so it must be carried over unchanged
+	parentExtra, err := extractExtra(parent)
+	if err != nil {
+		return err
+	}
+	if len(parentExtra.Validators) != len(extra.Validators) {
+		return errMismatchingValidators
+	}
+	for i, validator := range parentExtra.Validators {
+		if extra.Validators[i] != validator {
+			return errMismatchingValidators
+		}
+	}
+	return verifySeals(header, extra, commits)
+}
+
+// verifySeals checks that the header was sealed by the proposer of its round
+// and, if requested, that a quorum of validators committed to it.
+func verifySeals(header *types.Header, extra *istanbulExtra, commits bool) error {
+	digest := roundHash(header)
+
+	signer, err := recoverSigner(digest.Bytes(), extra.Seal)
+	if err != nil || signer != proposer(extra.Validators, header.Number.Uint64(), extra.Round) {
+		return errInvalidProposer
+	}
+	if !commits {
+		return nil
+	}
+	committed := make(map[common.Address]bool)
+	for _, seal := range extra.CommittedSeal {
+		validator, err := recoverSigner(commitData(digest), seal)
+		if err != nil || committed[validator] || !contains(extra.Validators, validator) {
+			return errInvalidCommittedSeals
+		}
+		committed[validator] = true
+	}
+	if len(committed) < quorum(len(extra.Validators)) {
+		return errInsufficientCommittedSeals
+	}
+	return nil
+}
+
+// contains reports whether the address is among the validators.
+func contains(validators []common.Address, address common.Address) bool {
+	for _, validator := range validators {
+		if validator == address {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (e *IBFT) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (e *IBFT) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	parentExtra, err := extractExtra(parent)
+	if err != nil {
+		return err
+	}
+	header.Time = parent.Time + e.config.Period
+	if header.Time < uint64(time.Now().Unix()) {
+		header.Time = uint64(time.Now().Unix())
+	}
+	// The round and seals are filled in during the consensus rounds
+	return writeExtra(header, &istanbulExtra{Validators: parentExtra.Validators})
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given.
+func (e *IBFT) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	// No block rewards in BFT, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = nilUncleHash
+}
+
+// FinalizeAndAssemble implements consensus.Engine, ensuring no uncles are set,
+// nor block rewards given, and returns the final block.
+func (e *IBFT) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// Finalize block
+	e.Finalize(chain, header, state, txs, uncles)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+// Authorize injects a private key into the consensus engine to take part in the
+// consensus rounds with.
+func (e *IBFT) Authorize(signer common.Address, signFn SignerFn) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.signer = signer
+	e.signFn = signFn
+}
+
+// sign signs the given data with the local validator key.
+func (e *IBFT) sign(data []byte) ([]byte, error) {
+	e.lock.RLock()
+	signer, signFn := e.signer, e.signFn
+	e.lock.RUnlock()
+
+	if signFn == nil {
+		return nil, errMissingSigner
+	}
+	return signFn(accounts.Account{Address: signer}, mimetypeIstanbul, data)
+}
+
+// Start connects the engine to the validator network, after which it proposes
+// and votes on blocks. Incoming consensus messages must be passed to
+// HandleMessage.
+func (e *IBFT) Start(chain consensus.ChainHeaderReader, network Broadcaster) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.signFn == nil {
+		return errMissingSigner
+	}
+	if e.core == nil {
+		e.core = newCore(e, chain, network)
+	}
+	return nil
+}
+
+// Stop disconnects the engine from the validator network.
+func (e *IBFT) Stop() {
+	e.lock.Lock()
+	core := e.core
+	e.core = nil
+	e.lock.Unlock()
+
+	// The core signs under its own lock, so stop it without holding ours
+	if core != nil {
+		core.stop()
+	}
+}
+
+// HandleMessage processes a consensus message received from another validator.
+func (e *IBFT) HandleMessage(payload []byte) error {
+	e.lock.RLock()
+	core := e.core
+	e.lock.RUnlock()
+
+	if core == nil {
+		return errNotStarted
+	}
+	return core.handle(payload)
+}
+
+// Seal implements consensus.Engine, offering the block to the consensus rounds.
+// If the local validator is the proposer, the block is proposed to the others.
+// The block is returned through the results channel once committed by a quorum
+// of validators, possibly with a different round if a round change happened,
+// under the same seal hash. Nothing is returned if another validator's block
+// is committed instead.
+func (e *IBFT) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	e.lock.RLock()
+	core := e.core
+	e.lock.RUnlock()
+
+	if core == nil {
+		return errNotStarted
+	}
+	return core.seal(block, results, stop)
+}
+
+// SealHash returns the hash of a block prior to it being sealed, independent of
+// the round it ends up committed in.
+func (e *IBFT) SealHash(header *types.Header) common.Hash {
+	return SealHash(header)
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. In BFT consensus there
+// are no forks to choose from, so the difficulty is always 1.
+func (e *IBFT) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs. The
+// engine has none yet.
+func (e *IBFT) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return nil
+}
+
+// Close implements consensus.Engine, stopping the consensus rounds.
+func (e *IBFT) Close() error {
+	e.Stop()
+	return nil
+}
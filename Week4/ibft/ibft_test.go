@@ -0,0 +1,286 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// testNetwork asynchronously delivers the messages of a validator to all the
+// others.
+type testNetwork struct {
+	self    int
+	engines []*IBFT
+}
+
+func (n *testNetwork) Broadcast(payload []byte) error {
+	for i, engine := range n.engines {
+		if i != n.self && engine != nil {
+			go engine.HandleMessage(payload)
+		}
+	}
+	return nil
+}
+
+// signerFn creates a signing callback for the given key.
+func signerFn(key *ecdsa.PrivateKey) SignerFn {
+	return func(signer accounts.Account, mimeType string, message []byte) ([]byte, error) {
+		return crypto.Sign(crypto.Keccak256(message), key)
+	}
+}
+
+// newTestValidators creates n validator keys and a genesis header listing them.
+func newTestValidators(n int) ([]*ecdsa.PrivateKey, *types.Header) {
+	keys := make([]*ecdsa.PrivateKey, n)
+	validators := make([]common.Address, n)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		validators[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	genesis := headerbuilder.New(headerbuilder.WithTime(uint64(time.Now().Unix()) - 10))
+	writeExtra(genesis, &istanbulExtra{Validators: validators})
+	return keys, genesis
+}
+
+// newTestBlock assembles an unsealed block on top of the genesis.
+func newTestBlock(genesis *types.Header, opts ...headerbuilder.Option) *types.Block {
+	header := headerbuilder.New(append([]headerbuilder.Option{headerbuilder.WithParent(genesis), headerbuilder.WithTime(genesis.Time + 1)}, opts...)...)
+	extra, _ := extractExtra(genesis)
+	writeExtra(header, &istanbulExtra{Validators: extra.Validators})
+	return types.NewBlockWithHeader(header)
+}
+
+// Tests that the quorum is 2f+1 for 3f+1 validators.
+func TestQuorum(t *testing.T) {
+	tests := []struct {
+		validators int
+		quorum     int
+	}{
+		{1, 1}, {2, 2}, {3, 2}, {4, 3}, {5, 4}, {6, 4}, {7, 5}, {10, 7},
+	}
+	for i, tt := range tests {
+		if have := quorum(tt.validators); have != tt.quorum {
+			t.Errorf("test %d: quorum mismatch: have %d, want %d", i, have, tt.quorum)
+		}
+	}
+}
+
+// Tests that committed seals are only accepted from a quorum of distinct
+// validators, signed over the digest of the block.
+func TestVerifyCommittedSeals(t *testing.T) {
+	keys, genesis := newTestValidators(4)
+	header := newTestBlock(genesis).Header()
+	extra, _ := extractExtra(header)
+
+	digest := roundHash(header)
+	extra.Seal, _ = crypto.Sign(crypto.Keccak256(digest.Bytes()), keys[1]) // Proposer of block 1, round 0
+
+	seals := make([][]byte, len(keys))
+	for i, key := range keys {
+		seals[i], _ = crypto.Sign(crypto.Keccak256(commitData(digest)), key)
+	}
+	outsider, _ := crypto.GenerateKey()
+	foreign, _ := crypto.Sign(crypto.Keccak256(commitData(digest)), outsider)
+	proposal, _ := crypto.Sign(crypto.Keccak256(digest.Bytes()), keys[0])
+
+	tests := []struct {
+		seals [][]byte
+		err   error
+	}{
+		{seals[:3], nil},
+		{seals, nil},
+		{seals[:2], errInsufficientCommittedSeals},
+		{[][]byte{seals[0], seals[1], seals[0]}, errInvalidCommittedSeals},
+		{[][]byte{seals[0], seals[1], foreign}, errInvalidCommittedSeals},
+		{[][]byte{seals[0], seals[1], proposal}, errInvalidCommittedSeals},
+	}
+	for i, tt := range tests {
+		extra.CommittedSeal = tt.seals
+		if err := verifySeals(header, extra, true); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	// Proposals are verified without committed seals, but never without the
+	// seal of the round's proposer
+	extra.CommittedSeal = nil
+	if err := verifySeals(header, extra, false); err != nil {
+		t.Errorf("proposal rejected: %v", err)
+	}
+	extra.Round = 1
+	if err := verifySeals(header, extra, false); err != errInvalidProposer {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidProposer)
+	}
+}
+
+// Tests that a network of validators agrees on a block, changing rounds if the
+// proposer of the first round is offline.
+func TestConsensusRounds(t *testing.T) {
+	leakcheck.Check(t)
+
+	t.Run("online", func(t *testing.T) { testConsensusRounds(t, false) })
+	t.Run("offline-proposer", func(t *testing.T) { testConsensusRounds(t, true) })
+}
+
+func testConsensusRounds(t *testing.T, offline bool) {
+	keys, genesis := newTestValidators(4)
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+	block := newTestBlock(genesis)
+
+	engines := make([]*IBFT, len(keys))
+	for i, key := range keys {
+		if offline && i == 1 { // Proposer of block 1, round 0
+			continue
+		}
+		engines[i] = New(Config{RequestTimeout: 100 * time.Millisecond})
+		engines[i].Authorize(crypto.PubkeyToAddress(key.PublicKey), signerFn(key))
+	}
+	results := make(chan *types.Block, len(keys))
+	for i, engine := range engines {
+		if engine == nil {
+			continue
+		}
+		if err := engine.Start(chain, &testNetwork{self: i, engines: engines}); err != nil {
+			t.Fatalf("validator %d: failed to start: %v", i, err)
+		}
+		defer engine.Stop()
+	}
+	for i, engine := range engines {
+		if engine == nil {
+			continue
+		}
+		if err := engine.Seal(chain, block, results, nil); err != nil {
+			t.Fatalf("validator %d: failed to seal: %v", i, err)
+		}
+	}
+	select {
+	case sealed := <-results:
+		if err := engines[0].VerifyHeader(chain, sealed.Header(), true); err != nil {
+			t.Fatalf("committed block invalid: %v", err)
+		}
+		extra, _ := extractExtra(sealed.Header())
+		if offline && extra.Round == 0 {
+			t.Errorf("committed in round 0 without its proposer")
+		}
+		if SealHash(sealed.Header()) != SealHash(block.Header()) {
+			t.Errorf("committed block mismatch: have %x, want %x", SealHash(sealed.Header()), SealHash(block.Header()))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("block not committed")
+	}
+}
+
+// Tests that committed blocks are only returned to the sealer of the proposer,
+// under the seal hash of the block it offered, even across round changes. This
+// is how the miner matches results to its pending tasks.
+func TestSealTasks(t *testing.T) {
+	leakcheck.Check(t)
+
+	t.Run("online", func(t *testing.T) { testSealTasks(t, false) })
+	t.Run("offline-proposer", func(t *testing.T) { testSealTasks(t, true) })
+}
+
+func testSealTasks(t *testing.T, offline bool) {
+	keys, genesis := newTestValidators(4)
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+
+	engines := make([]*IBFT, len(keys))
+	for i, key := range keys {
+		if offline && i == 1 { // Proposer of block 1, round 0
+			continue
+		}
+		engines[i] = New(Config{RequestTimeout: 100 * time.Millisecond})
+		engines[i].Authorize(crypto.PubkeyToAddress(key.PublicKey), signerFn(key))
+	}
+	for i, engine := range engines {
+		if engine == nil {
+			continue
+		}
+		if err := engine.Start(chain, &testNetwork{self: i, engines: engines}); err != nil {
+			t.Fatalf("validator %d: failed to start: %v", i, err)
+		}
+		defer engine.Stop()
+	}
+	// Every validator offers its own block, remembering it by seal hash
+	results := make([]chan *types.Block, len(keys))
+	tasks := make([]common.Hash, len(keys))
+	for i, engine := range engines {
+		if engine == nil {
+			continue
+		}
+		block := newTestBlock(genesis, headerbuilder.WithCoinbase(crypto.PubkeyToAddress(keys[i].PublicKey)))
+		tasks[i], results[i] = engine.SealHash(block.Header()), make(chan *types.Block, 1)
+		if err := engine.Seal(chain, block, results[i], nil); err != nil {
+			t.Fatalf("validator %d: failed to seal: %v", i, err)
+		}
+	}
+	// Only the proposer of the committing round gets its block back
+	var (
+		sealed *types.Block
+		winner int
+	)
+	for deadline := time.Now().Add(5 * time.Second); sealed == nil; {
+		if time.Now().After(deadline) {
+			t.Fatalf("block not returned to its proposer")
+		}
+		for i, ch := range results {
+			if ch == nil {
+				continue
+			}
+			select {
+			case sealed = <-ch:
+				winner = i
+			default:
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	extra, _ := extractExtra(sealed.Header())
+	if have, want := crypto.PubkeyToAddress(keys[winner].PublicKey), proposer(extra.Validators, 1, extra.Round); have != want {
+		t.Errorf("sealed block returned to the wrong validator: have %x, want %x", have, want)
+	}
+	if offline && extra.Round == 0 {
+		t.Errorf("committed in round 0 without its proposer")
+	}
+	if have := engines[winner].SealHash(sealed.Header()); have != tasks[winner] {
+		t.Errorf("sealed block task mismatch: have %x, want %x", have, tasks[winner])
+	}
+	for i, ch := range results {
+		if ch == nil || i == winner {
+			continue
+		}
+		select {
+		case <-ch:
+			t.Errorf("validator %d: received a block it didn't propose", i)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package leakcheck detects goroutines leaked by tests.
+//
+// The consensus engines report results through channels filled by background
+// goroutines (sealing workers, batch verifiers, consensus state machines), which
+// are easily left running when a test forgets to abort or stop them. Check and
+// Main compare the goroutines running after a test with the ones before it.
+package leakcheck
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeout is how long goroutines are given to wind down before being reported.
+const timeout = 5 * time.Second
+
+// ignoredFrames are stack frames of goroutines that are expected to outlive
+// tests: the test framework itself and process wide background loops.
+var ignoredFrames = []string{
+	"testing.tRunner(",
+	"testing.(*M).",
+	"testing.runTests(",
+	"os/signal.signal_recv(",
+	"github.com/ethereum/go-ethereum/metrics.(*meterArbiter).tick(",
+}
+
+// goroutines returns the stacks of all running goroutines, keyed by their id.
+func goroutines() map[string]string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		fields := strings.SplitN(stack, " ", 3)
+		if len(fields) == 3 && fields[0] == "goroutine" {
+			stacks[fields[1]] = stack
+		}
+	}
+	return stacks
+}
+
+// leaks returns the stacks of the goroutines not running at the time of the
+// snapshot, apart from the ignored ones.
+func leaks(snapshot map[string]string, ignores []string) []string {
+	var leaked []string
+	for id, stack := range goroutines() {
+		if _, ok := snapshot[id]; ok || ignored(stack, ignores) {
+			continue
+		}
+		leaked = append(leaked, stack)
+	}
+	return leaked
+}
+
+// ignored reports whether a goroutine is expected to outlive tests.
+func ignored(stack string, ignores []string) bool {
+	for _, frame := range append(ignores, ignoredFrames...) {
+		if strings.Contains(stack, frame) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait gives the goroutines started since the snapshot time to terminate,
+// returning the stacks of the ones still running after the timeout.
+func wait(snapshot map[string]string, ignores []string) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		leaked := leaks(snapshot, ignores)
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			return leaked
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Check fails the test if goroutines started during it are still running once
+// it finished, including its deferred calls. Goroutines whose stack contains
+// any of the ignored substrings are not reported.
+func Check(t testing.TB, ignores ...string) {
+	snapshot := goroutines()
+	t.Cleanup(func() {
+		if leaked := wait(snapshot, ignores); len(leaked) > 0 {
+			t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+		}
+	})
+}
+
+// Main runs the tests of a package and fails the run if any goroutines started
+// by them are still running afterwards. It's meant to be called from TestMain.
+func Main(m *testing.M, ignores ...string) {
+	snapshot := goroutines()
+	code := m.Run()
+	if code == 0 {
+		if leaked := wait(snapshot, ignores); len(leaked) > 0 {
+			fmt.Fprintf(os.Stderr, "%d goroutines leaked:\n\n%s\n", len(leaked), strings.Join(leaked, "\n\n"))
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package leakcheck
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that goroutines started after a snapshot are reported until they exit.
+func TestLeaks(t *testing.T) {
+	snapshot := goroutines()
+
+	quit := make(chan struct{})
+	go func() { <-quit }()
+
+	if leaked := leaks(snapshot, nil); len(leaked) != 1 {
+		t.Fatalf("leak count mismatch: have %d, want %d", len(leaked), 1)
+	}
+	if leaked := leaks(snapshot, []string{"leakcheck.TestLeaks"}); len(leaked) != 0 {
+		t.Errorf("ignored goroutine reported: %v", leaked)
+	}
+	close(quit)
+	time.Sleep(10 * time.Millisecond)
+
+	if leaked := wait(snapshot, nil); len(leaked) != 0 {
+		t.Errorf("exited goroutine reported: %v", leaked)
+	}
+}
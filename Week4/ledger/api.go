@@ -0,0 +1,97 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ledger
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// API is a user facing RPC API to trace the balance mutations made by the
+// consensus engine along the canonical chain.
+type API struct {
+	chain  consensus.ChainHeaderReader
+	sealer Sealer
+	ledger *Ledger
+}
+
+// RPCEntry is the JSON representation of a ledger entry.
+type RPCEntry struct {
+	Number  hexutil.Uint64 `json:"number"`
+	Hash    common.Hash    `json:"hash"`
+	Account common.Address `json:"account"`
+	Kind    string         `json:"kind"`
+	Amount  *hexutil.Big   `json:"amount"`
+}
+
+// rpcEntries converts ledger entries into their JSON representation.
+func rpcEntries(entries []Entry) []RPCEntry {
+	converted := make([]RPCEntry, len(entries))
+	for i, entry := range entries {
+		converted[i] = RPCEntry{
+			Number:  hexutil.Uint64(entry.Number),
+			Hash:    entry.Hash,
+			Account: entry.Account,
+			Kind:    entry.Kind.String(),
+			Amount:  (*hexutil.Big)(entry.Amount),
+		}
+	}
+	return converted
+}
+
+// number resolves the requested block number (or the current if none requested).
+func (api *API) number(number *rpc.BlockNumber) (uint64, error) {
+	var header *types.Header
+	if number == nil || *number < 0 {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return 0, consensus.ErrUnknownBlock
+	}
+	return header.Number.Uint64(), nil
+}
+
+// Block retrieves the entries recorded for the specified canonical block.
+func (api *API) Block(number *rpc.BlockNumber) ([]RPCEntry, error) {
+	n, err := api.number(number)
+	if err != nil {
+		return nil, consensus.WithErrorCode(err)
+	}
+	return rpcEntries(api.ledger.Block(api.chain, api.sealer, n)), nil
+}
+
+// Account retrieves the entries affecting the given account between the
+// specified canonical blocks, inclusive. The range defaults to the whole
+// retention window up to the current block.
+func (api *API) Account(account common.Address, from *rpc.BlockNumber, to *rpc.BlockNumber) ([]RPCEntry, error) {
+	last, err := api.number(to)
+	if err != nil {
+		return nil, consensus.WithErrorCode(err)
+	}
+	var first uint64
+	if from != nil {
+		if first, err = api.number(from); err != nil {
+			return nil, consensus.WithErrorCode(err)
+		}
+	}
+	return rpcEntries(api.ledger.Account(api.chain, api.sealer, account, first, last)), nil
+}
@@ -0,0 +1,272 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ledger keeps an audit trail of the balance mutations made by the
+// consensus engines, such as block rewards, slashings and penalties, so that
+// the effect of consensus on any account can be traced back block by block.
+package ledger
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// defaultRetention is the number of most recent blocks kept by a ledger, if no
+// other limit is configured.
+const defaultRetention = 8192
+
+// Kind classifies a balance mutation.
+type Kind uint8
+
+const (
+	KindBlockReward    Kind = iota // Static reward credited to the author of a block
+	KindUncleReward                // Reward credited to the author of an included uncle
+	KindUncleInclusion             // Bonus credited to the author of a block for including an uncle
+	KindSlash                      // Stake destroyed as punishment for misbehaviour
+	KindPenalty                    // Non monetary penalty, such as missed slots
+	KindBurn                       // Funds destroyed by a protocol rule
+)
+
+// String implements fmt.Stringer.
+func (k Kind) String() string {
+	switch k {
+	case KindBlockReward:
+		return "block reward"
+	case KindUncleReward:
+		return "uncle reward"
+	case KindUncleInclusion:
+		return "uncle inclusion"
+	case KindSlash:
+		return "slash"
+	case KindPenalty:
+		return "penalty"
+	case KindBurn:
+		return "burn"
+	default:
+		return "unknown"
+	}
+}
+
+// Entry is a single balance mutation made by a consensus engine. The amount is
+// in wei for rewards, slashes and burns, and in the engine's own unit (e.g. the
+// number of missed slots) for penalties.
+type Entry struct {
+	Number  uint64         // Number of the block whose finalization made the mutation
+	Hash    common.Hash    // Hash of the canonical block, filled in by the queries
+	Account common.Address // Account whose balance or stake was changed
+	Kind    Kind           // Classification of the mutation
+	Amount  *big.Int       // Magnitude of the mutation, always non-negative
+}
+
+// Sealer derives the hash of a block prior to it being sealed. Every consensus
+// engine implements it.
+type Sealer interface {
+	SealHash(header *types.Header) common.Hash
+}
+
+// Ledger is an in-memory record of the balance mutations of the most recent
+// blocks. Engines record the entries of a block while finalizing it, which also
+// happens for blocks that never make it into the canonical chain, such as
+// mining candidates and side chain blocks. Entries are therefore keyed by the
+// seal hash of their block, which stays the same while a candidate is sealed,
+// and queries only return the ones of blocks on the canonical chain of the
+// given chain reader. Reorgs, including ones to a shorter chain, thus need no
+// notification.
+//
+// All methods are safe for concurrent use, and also on a nil ledger, where they
+// do nothing. This allows engines to record unconditionally.
+type Ledger struct {
+	retention uint64                                      // Number of most recent block numbers to keep
+	blocks    map[common.Hash][]Entry                     // Entries of each recorded block, by seal hash
+	numbers   map[uint64]map[common.Hash]struct{}         // Seal hashes of the blocks recorded at each number
+	accounts  map[common.Address]map[common.Hash]struct{} // Seal hashes of the blocks affecting each account
+	head      uint64                                      // Highest recorded block number
+
+	lock sync.RWMutex
+}
+
+// New creates a ledger keeping the entries of the given number of most recent
+// blocks, or of a default number if zero.
+func New(retention uint64) *Ledger {
+	if retention == 0 {
+		retention = defaultRetention
+	}
+	return &Ledger{
+		retention: retention,
+		blocks:    make(map[common.Hash][]Entry),
+		numbers:   make(map[uint64]map[common.Hash]struct{}),
+		accounts:  make(map[common.Address]map[common.Hash]struct{}),
+	}
+}
+
+// Record replaces the entries of the given block, which must be complete apart
+// from its seal. The numbers of the entries are set to the block's.
+func (l *Ledger) Record(sealer Sealer, header *types.Header, entries []Entry) {
+	if l == nil {
+		return
+	}
+	var (
+		number = header.Number.Uint64()
+		hash   = sealer.SealHash(header)
+	)
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Ignore blocks that have already fallen out of the retention window
+	if number+l.retention <= l.head {
+		return
+	}
+	l.drop(hash)
+	if len(entries) > 0 {
+		recorded := make([]Entry, len(entries))
+		for i, entry := range entries {
+			entry.Number, entry.Hash, entry.Amount = number, common.Hash{}, new(big.Int).Set(entry.Amount)
+			recorded[i] = entry
+
+			if l.accounts[entry.Account] == nil {
+				l.accounts[entry.Account] = make(map[common.Hash]struct{})
+			}
+			l.accounts[entry.Account][hash] = struct{}{}
+		}
+		l.blocks[hash] = recorded
+		if l.numbers[number] == nil {
+			l.numbers[number] = make(map[common.Hash]struct{})
+		}
+		l.numbers[number][hash] = struct{}{}
+	}
+	// Forget the blocks falling out of the retention window
+	if number > l.head {
+		l.head = number
+		for old, hashes := range l.numbers {
+			if old+l.retention <= l.head {
+				for hash := range hashes {
+					l.drop(hash)
+				}
+			}
+		}
+	}
+}
+
+// drop removes the entries of a block. The caller must hold the lock.
+func (l *Ledger) drop(hash common.Hash) {
+	entries := l.blocks[hash]
+	if len(entries) == 0 {
+		return
+	}
+	for _, entry := range entries {
+		if hashes := l.accounts[entry.Account]; hashes != nil {
+			delete(hashes, hash)
+			if len(hashes) == 0 {
+				delete(l.accounts, entry.Account)
+			}
+		}
+	}
+	number := entries[0].Number
+	if hashes := l.numbers[number]; hashes != nil {
+		delete(hashes, hash)
+		if len(hashes) == 0 {
+			delete(l.numbers, number)
+		}
+	}
+	delete(l.blocks, hash)
+}
+
+// Block returns the entries recorded for the canonical block with the given
+// number.
+func (l *Ledger) Block(chain consensus.ChainHeaderReader, sealer Sealer, number uint64) []Entry {
+	if l == nil {
+		return nil
+	}
+	header := chain.GetHeaderByNumber(number)
+	if header == nil {
+		return nil
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return copyEntries(l.blocks[sealer.SealHash(header)], header.Hash())
+}
+
+// Account returns the entries affecting the given account within the inclusive
+// range of canonical block numbers, ordered by block number.
+func (l *Ledger) Account(chain consensus.ChainHeaderReader, sealer Sealer, account common.Address, from, to uint64) []Entry {
+	if l == nil {
+		return nil
+	}
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	// Collect the recorded numbers first, only resolving those in the range
+	numbers := make(map[uint64]struct{})
+	for hash := range l.accounts[account] {
+		if number := l.blocks[hash][0].Number; number >= from && number <= to {
+			numbers[number] = struct{}{}
+		}
+	}
+	sorted := make([]uint64, 0, len(numbers))
+	for number := range numbers {
+		sorted = append(sorted, number)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var entries []Entry
+	for _, number := range sorted {
+		header := chain.GetHeaderByNumber(number)
+		if header == nil {
+			continue
+		}
+		for _, entry := range copyEntries(l.blocks[sealer.SealHash(header)], header.Hash()) {
+			if entry.Account == account {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// APIs returns the RPC APIs exposing the ledger, resolving the canonical chain
+// against the given reader. A nil ledger has none.
+func (l *Ledger) APIs(chain consensus.ChainHeaderReader, sealer Sealer) []rpc.API {
+	if l == nil {
+		return nil
+	}
+	return []rpc.API{{
+		Namespace: "ledger",
+		Version:   "1.0",
+		Service:   &API{chain: chain, sealer: sealer, ledger: l},
+		Public:    true,
+	}}
+}
+
+// copyEntries returns deep copies of the given entries of a canonical block, so
+// that callers can't modify the recorded amounts.
+func copyEntries(entries []Entry, hash common.Hash) []Entry {
+	if len(entries) == 0 {
+		return nil
+	}
+	copies := make([]Entry, len(entries))
+	for i, entry := range entries {
+		entry.Hash, entry.Amount = hash, new(big.Int).Set(entry.Amount)
+		copies[i] = entry
+	}
+	return copies
+}
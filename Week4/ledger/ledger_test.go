@@ -0,0 +1,181 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ledger_test
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	alice = common.HexToAddress("0x000000000000000000000000000000000000a11c")
+	bob   = common.HexToAddress("0x0000000000000000000000000000000000000b0b")
+)
+
+// sealer hashes headers without their nonce, standing in for the seal.
+type sealer struct{}
+
+func (sealer) SealHash(header *types.Header) common.Hash {
+	header = types.CopyHeader(header)
+	header.Nonce = types.BlockNonce{}
+	return header.Hash()
+}
+
+// newChain creates a canonical chain of the given length on top of a genesis,
+// tagging the branch with the given seed so that forks get distinct hashes. The
+// headers are sealed with a non-zero nonce.
+func newChain(genesis *types.Header, n int, seed byte) []*types.Header {
+	headers := []*types.Header{genesis}
+	for i := 0; i < n; i++ {
+		headers = append(headers, headerbuilder.New(headerbuilder.WithParent(headers[i]), headerbuilder.WithExtra([]byte{seed}), headerbuilder.WithNonce(types.EncodeNonce(1))))
+	}
+	return headers
+}
+
+// reward creates a block reward entry for the given account.
+func reward(account common.Address, amount int64) ledger.Entry {
+	return ledger.Entry{Account: account, Kind: ledger.KindBlockReward, Amount: big.NewInt(amount)}
+}
+
+// Tests that recorded entries can be queried by block and by account, and that
+// recording a block again replaces its entries.
+func TestRecord(t *testing.T) {
+	headers := newChain(headerbuilder.New(), 3, 0)
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, headers...)
+	l := ledger.New(0)
+
+	amount := big.NewInt(2)
+	l.Record(sealer{}, headers[1], []ledger.Entry{{Account: alice, Kind: ledger.KindBlockReward, Amount: amount}})
+	l.Record(sealer{}, headers[2], []ledger.Entry{reward(bob, 2), {Account: alice, Kind: ledger.KindUncleReward, Amount: big.NewInt(1)}})
+	l.Record(sealer{}, headers[3], []ledger.Entry{{Account: bob, Kind: ledger.KindSlash, Amount: big.NewInt(5)}})
+
+	// Mutating the recorded or returned amounts must not alter the ledger
+	amount.SetInt64(100)
+	l.Block(chain, sealer{}, 1)[0].Amount.SetInt64(100)
+
+	if have := l.Block(chain, sealer{}, 2); len(have) != 2 || have[0].Number != 2 || have[0].Hash != headers[2].Hash() || have[1].Kind != ledger.KindUncleReward {
+		t.Errorf("block entries mismatch: have %v", have)
+	}
+	have := l.Account(chain, sealer{}, alice, 0, 10)
+	if len(have) != 2 || have[0].Number != 1 || have[1].Number != 2 {
+		t.Fatalf("account entries mismatch: have %v", have)
+	}
+	if have[0].Amount.Int64() != 2 {
+		t.Errorf("recorded amount mutated: have %v, want %v", have[0].Amount, 2)
+	}
+	if have := l.Account(chain, sealer{}, bob, 3, 3); len(have) != 1 || have[0].Kind != ledger.KindSlash {
+		t.Errorf("ranged account entries mismatch: have %v", have)
+	}
+	// Record block 2 again, dropping alice's uncle reward
+	l.Record(sealer{}, headers[2], []ledger.Entry{reward(bob, 2)})
+	if have := l.Account(chain, sealer{}, alice, 0, 10); len(have) != 1 {
+		t.Errorf("replaced entries still recorded: have %v", have)
+	}
+	l.Record(sealer{}, headers[3], nil)
+	if have := l.Block(chain, sealer{}, 3); have != nil {
+		t.Errorf("cleared block still recorded: have %v", have)
+	}
+}
+
+// Tests that the entries of sealed candidates are found under the sealed block,
+// and that those of blocks off the canonical chain are never returned, even
+// after a reorg to a shorter chain.
+func TestCanonical(t *testing.T) {
+	genesis := headerbuilder.New()
+	long, short := newChain(genesis, 4, 1), newChain(genesis, 2, 2)
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, genesis)
+	l := ledger.New(0)
+
+	// Record a mining candidate that is never sealed, then the sealed chain
+	candidate := types.CopyHeader(long[1])
+	candidate.Time++
+	l.Record(sealer{}, candidate, []ledger.Entry{reward(bob, 2)})
+
+	for i, header := range long[1:] {
+		unsealed := types.CopyHeader(header)
+		unsealed.Nonce = types.BlockNonce{}
+		l.Record(sealer{}, unsealed, []ledger.Entry{reward(alice, int64(i+1))})
+	}
+	for i, header := range short[1:] {
+		l.Record(sealer{}, header, []ledger.Entry{reward(bob, int64(i+1))})
+	}
+	chain.Insert(long[1:]...)
+	if have := l.Account(chain, sealer{}, alice, 0, 10); len(have) != 4 || have[3].Hash != long[4].Hash() {
+		t.Errorf("canonical entries mismatch: have %v", have)
+	}
+	if have := l.Account(chain, sealer{}, bob, 0, 10); len(have) != 0 {
+		t.Errorf("side chain entries returned: have %v", have)
+	}
+	// Reorg to the shorter, but heavier chain
+	heavy := types.CopyHeader(short[2])
+	heavy.Difficulty = big.NewInt(1000)
+	l.Record(sealer{}, heavy, []ledger.Entry{reward(bob, 3)})
+	chain.Insert(short[1], heavy)
+
+	if have := l.Account(chain, sealer{}, alice, 0, 10); len(have) != 0 {
+		t.Errorf("reorged entries returned: have %v", have)
+	}
+	if have := l.Block(chain, sealer{}, 3); have != nil {
+		t.Errorf("entries above the new head returned: have %v", have)
+	}
+	if have := l.Account(chain, sealer{}, bob, 0, 10); len(have) != 2 || have[1].Amount.Int64() != 3 {
+		t.Errorf("new canonical entries mismatch: have %v", have)
+	}
+}
+
+// Tests that only the entries of the most recent blocks are retained.
+func TestRetention(t *testing.T) {
+	headers := newChain(headerbuilder.New(), 10, 0)
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, headers...)
+	l := ledger.New(4)
+	for _, header := range headers[1:] {
+		l.Record(sealer{}, header, []ledger.Entry{reward(alice, 2)})
+	}
+	if have := l.Account(chain, sealer{}, alice, 0, 10); len(have) != 4 || have[0].Number != 7 {
+		t.Errorf("retained entries mismatch: have %v", have)
+	}
+	// Blocks out of the window are ignored, blocks within it are replaced
+	l.Record(sealer{}, headers[5], []ledger.Entry{reward(bob, 2)})
+	l.Record(sealer{}, headers[8], []ledger.Entry{reward(bob, 2)})
+	if have := l.Account(chain, sealer{}, bob, 0, 10); len(have) != 1 || have[0].Number != 8 {
+		t.Errorf("stale entries recorded: have %v", have)
+	}
+}
+
+// Tests that a nil ledger can be used without recording anything.
+func TestNilLedger(t *testing.T) {
+	headers := newChain(headerbuilder.New(), 1, 0)
+	chain := headerbuilder.NewMemoryChain(params.TestChainConfig, headers...)
+
+	var l *ledger.Ledger
+	l.Record(sealer{}, headers[1], []ledger.Entry{reward(alice, 2)})
+	if have := l.Block(chain, sealer{}, 1); have != nil {
+		t.Errorf("nil ledger recorded entries: have %v", have)
+	}
+	if have := l.Account(chain, sealer{}, alice, 0, 10); have != nil {
+		t.Errorf("nil ledger recorded entries: have %v", have)
+	}
+	if have := l.APIs(chain, sealer{}); have != nil {
+		t.Errorf("nil ledger exposed APIs: have %v", have)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// The helpers below back the difficulty and reward calculations of the engines.
+// Consensus arithmetic must give bit for bit the same result on every platform,
+// so they only ever convert through fixed width integers: never int or uint,
+// whose size differs between 32 and 64 bit builds.
+
+// Exp2 returns 2^n.
+func Exp2(n uint64) *big.Int {
+	return new(big.Int).Exp(common.Big2, new(big.Int).SetUint64(n), nil)
+}
+
+// MulDiv returns x * num / den, truncated towards zero. The intermediate product
+// is not bounded, so unlike its uint64 equivalent it cannot overflow. It panics
+// if den is zero.
+func MulDiv(x *big.Int, num, den uint64) *big.Int {
+	z := new(big.Int).Mul(x, new(big.Int).SetUint64(num))
+	return z.Quo(z, new(big.Int).SetUint64(den))
+}
+
+// Clamp returns x bounded to the range [lower, upper]. Either bound may be nil
+// to leave that side open. The result never aliases x.
+func Clamp(x, lower, upper *big.Int) *big.Int {
+	switch {
+	case lower != nil && x.Cmp(lower) < 0:
+		return new(big.Int).Set(lower)
+	case upper != nil && x.Cmp(upper) > 0:
+		return new(big.Int).Set(upper)
+	default:
+		return new(big.Int).Set(x)
+	}
+}
+
+// AdjustDifficulty returns parent + parent / divisor * adjust, the bounded step
+// of the Homestead style difficulty adjustments. Division truncates towards
+// zero, as the formula is specified in the yellow paper.
+func AdjustDifficulty(parent, divisor, adjust *big.Int) *big.Int {
+	step := new(big.Int).Quo(parent, divisor)
+	step.Mul(step, adjust)
+	return step.Add(step, parent)
+}
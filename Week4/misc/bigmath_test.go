@@ -0,0 +1,187 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// The golden vectors below were computed independently and hold on every
+// platform. They deliberately cross the 32 and 64 bit boundaries, and so do the
+// differential tests at the bottom, which check the helpers against reference
+// results built from plain big.Int operations. Any arithmetic that silently
+// depends on the native word size breaks them even on 64 bit builds.
+
+func TestExp2(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "1"},
+		{1, "2"},
+		{31, "2147483648"},
+		{32, "4294967296"},
+		{63, "9223372036854775808"},
+		{64, "18446744073709551616"},
+		{100, "1267650600228229401496703205376"},
+	}
+	for i, tt := range tests {
+		if have := Exp2(tt.n); have.String() != tt.want {
+			t.Errorf("test %d: 2^%d mismatch: have %v, want %v", i, tt.n, have, tt.want)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	tests := []struct {
+		x        string
+		num, den uint64
+		want     string
+	}{
+		{"1000", 3, 4, "750"},
+		{"7", 1, 2, "3"},
+		{"-7", 1, 2, "-3"}, // Truncated towards zero
+		{"18446744073709551615", math.MaxUint64, math.MaxUint64, "18446744073709551615"},
+		{"4294967296", 4294967296, 2, "9223372036854775808"},
+		{"340282366920938463463374607431768211456", 1, 4294967297, "79228162495817593524129366015"},
+	}
+	for i, tt := range tests {
+		x, _ := new(big.Int).SetString(tt.x, 10)
+		if have := MulDiv(x, tt.num, tt.den); have.String() != tt.want {
+			t.Errorf("test %d: mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+func TestClamp(t *testing.T) {
+	lower, upper := big.NewInt(10), big.NewInt(20)
+	tests := []struct {
+		x, lower, upper *big.Int
+		want            int64
+	}{
+		{big.NewInt(5), lower, upper, 10},
+		{big.NewInt(15), lower, upper, 15},
+		{big.NewInt(25), lower, upper, 20},
+		{big.NewInt(5), nil, upper, 5},
+		{big.NewInt(25), lower, nil, 25},
+	}
+	for i, tt := range tests {
+		have := Clamp(tt.x, tt.lower, tt.upper)
+		if have.Int64() != tt.want {
+			t.Errorf("test %d: mismatch: have %v, want %v", i, have, tt.want)
+		}
+		if have == tt.x || have == tt.lower || have == tt.upper {
+			t.Errorf("test %d: result aliases an input", i)
+		}
+	}
+}
+
+func TestAdjustDifficulty(t *testing.T) {
+	tests := []struct {
+		parent string
+		adjust int64
+		want   string
+	}{
+		{"2048000", 1, "2049000"},
+		{"2048000", -99, "1949000"},
+		{"131072", 1, "131136"},
+		{"2047", 1, "2047"}, // Step truncates to zero
+		{"18446744073709551616", 1, "18455751272964292608"},
+		{"18446744073709551616", -99, "17555031347490193408"},
+	}
+	divisor := big.NewInt(2048)
+	for i, tt := range tests {
+		parent, _ := new(big.Int).SetString(tt.parent, 10)
+		if have := AdjustDifficulty(parent, divisor, big.NewInt(tt.adjust)); have.String() != tt.want {
+			t.Errorf("test %d: mismatch: have %v, want %v", i, have, tt.want)
+		}
+	}
+}
+
+// boundaries returns the values around 2^31, 2^32, 2^63, 2^64 and 2^128, with
+// both signs.
+func boundaries() []*big.Int {
+	var values []*big.Int
+	for _, n := range []uint{31, 32, 63, 64, 128} {
+		pow := new(big.Int).Lsh(big.NewInt(1), n)
+		for _, delta := range []int64{-1, 0, 1} {
+			value := new(big.Int).Add(pow, big.NewInt(delta))
+			values = append(values, value, new(big.Int).Neg(value))
+		}
+	}
+	return values
+}
+
+// quo divides x by y truncating towards zero, working on the magnitudes so the
+// result doesn't depend on the rounding of any big.Int division.
+func quo(x, y *big.Int) *big.Int {
+	z, _ := new(big.Int).DivMod(new(big.Int).Abs(x), new(big.Int).Abs(y), new(big.Int))
+	if x.Sign()*y.Sign() < 0 {
+		z.Neg(z)
+	}
+	return z
+}
+
+// Tests Exp2 against shifts at and around the word size boundaries.
+func TestExp2Boundaries(t *testing.T) {
+	for _, n := range []uint64{30, 31, 32, 33, 62, 63, 64, 65, 127, 128, 129} {
+		want := new(big.Int).Lsh(big.NewInt(1), uint(n))
+		if have := Exp2(n); have.Cmp(want) != 0 {
+			t.Errorf("2^%d mismatch: have %v, want %v", n, have, want)
+		}
+	}
+}
+
+// Tests MulDiv against the reference result for operands and factors at and
+// around the word size boundaries.
+func TestMulDivBoundaries(t *testing.T) {
+	factors := []uint64{1, 2, math.MaxUint32 - 1, math.MaxUint32, math.MaxUint32 + 1, math.MaxUint32 + 2, math.MaxInt64, math.MaxInt64 + 1, math.MaxUint64}
+	for _, x := range boundaries() {
+		for _, num := range factors {
+			for _, den := range factors {
+				want := quo(new(big.Int).Mul(x, new(big.Int).SetUint64(num)), new(big.Int).SetUint64(den))
+				if have := MulDiv(x, num, den); have.Cmp(want) != 0 {
+					t.Errorf("%v * %d / %d mismatch: have %v, want %v", x, num, den, have, want)
+				}
+			}
+		}
+	}
+}
+
+// Tests AdjustDifficulty against the reference result for parents and divisors
+// at and around the word size boundaries.
+func TestAdjustDifficultyBoundaries(t *testing.T) {
+	divisors := []*big.Int{big.NewInt(2048), new(big.Int).SetUint64(math.MaxUint32), new(big.Int).SetUint64(math.MaxUint32 + 1), new(big.Int).SetUint64(math.MaxUint64)}
+	for _, parent := range boundaries() {
+		if parent.Sign() < 0 {
+			continue // Difficulties are never negative
+		}
+		for _, divisor := range divisors {
+			for _, adjust := range []int64{-99, -1, 0, 1, 2} {
+				want := quo(parent, divisor)
+				want.Mul(want, big.NewInt(adjust))
+				want.Add(want, parent)
+
+				if have := AdjustDifficulty(parent, divisor, big.NewInt(adjust)); have.Cmp(want) != 0 {
+					t.Errorf("%v / %v * %d mismatch: have %v, want %v", parent, divisor, adjust, have, want)
+				}
+			}
+		}
+	}
+}
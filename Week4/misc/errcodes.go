@@ -0,0 +1,40 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the shared consensus rule errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeBadProDAOExtra  = -39051
+	codeBadNoDAOExtra   = -39052
+	codeTooManyUncles   = -39053
+	codeDuplicateUncle  = -39054
+	codeUncleIsAncestor = -39055
+	codeDanglingUncle   = -39056
+)
+
+// Register stable numeric codes for the shared consensus rule errors.
+func init() {
+	consensus.RegisterErrorCode(ErrBadProDAOExtra, codeBadProDAOExtra)
+	consensus.RegisterErrorCode(ErrBadNoDAOExtra, codeBadNoDAOExtra)
+	consensus.RegisterErrorCode(ErrTooManyUncles, codeTooManyUncles)
+	consensus.RegisterErrorCode(ErrDuplicateUncle, codeDuplicateUncle)
+	consensus.RegisterErrorCode(ErrUncleIsAncestor, codeUncleIsAncestor)
+	consensus.RegisterErrorCode(ErrDanglingUncle, codeDanglingUncle)
+}
@@ -0,0 +1,40 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// rejectionMarker is the account RejectBlock writes to. It has no known key.
+var rejectionMarker = common.BytesToAddress([]byte("consensus-rejected"))
+
+// RejectBlock makes the block of the given header fail its state root check. It
+// is meant for consensus rules that depend on the state, so can only be checked
+// in Finalize, which has no way of returning an error.
+//
+// The root claimed by the header is written into the state, so the root computed
+// afterwards, which block processing compares against the claimed one, depends
+// on the claim itself. Matching it would require a fixed point of the state trie
+// hash, so, unlike an ordinary penalty, the producer can't account for it ahead
+// of time.
+func RejectBlock(state *state.StateDB, header *types.Header) {
+	state.SetNonce(rejectionMarker, state.GetNonce(rejectionMarker)+1)
+	state.SetState(rejectionMarker, common.Hash{}, header.Root)
+}
@@ -0,0 +1,186 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"errors"
+	"math/big"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	MaxUncles     = 2 // Maximum number of uncles allowed in a single block
+	MaxUncleDepth = 7 // Maximum number of generations an uncle's parent may lag behind the including block
+)
+
+var (
+	// ErrTooManyUncles is returned if a block includes more than MaxUncles uncles.
+	ErrTooManyUncles = errors.New("too many uncles")
+
+	// ErrDuplicateUncle is returned if an uncle was already included in the block
+	// or in one of its recent ancestors.
+	ErrDuplicateUncle = errors.New("duplicate uncle")
+
+	// ErrUncleIsAncestor is returned if an uncle is an ancestor of the block.
+	ErrUncleIsAncestor = errors.New("uncle is ancestor")
+
+	// ErrDanglingUncle is returned if the parent of an uncle is not one of the
+	// recent ancestors of the block, or is the block's own parent.
+	ErrDanglingUncle = errors.New("uncle's parent is not ancestor")
+)
+
+// Some weird constants to avoid constant memory allocs for them.
+var (
+	big8  = big.NewInt(8)
+	big32 = big.NewInt(32)
+)
+
+// RewardStep is a block reward that takes effect at a given block number, such
+// as a hard-fork block.
+type RewardStep struct {
+	Block  *big.Int // Block number from which the reward applies
+	Reward *big.Int // Reward in wei for successfully mining a block
+}
+
+// RewardSchedule is a list of block rewards changing over the life of a chain.
+// The step with the highest activation block not above a block's number applies
+// to it; if several steps activate at the same block, the last one wins.
+type RewardSchedule []RewardStep
+
+// BlockReward returns the reward for mining the block with the given number, or
+// zero if no step of the schedule is active yet.
+func (s RewardSchedule) BlockReward(number *big.Int) *big.Int {
+	var active *RewardStep
+	for i := range s {
+		if s[i].Block.Cmp(number) > 0 {
+			continue
+		}
+		if active == nil || s[i].Block.Cmp(active.Block) >= 0 {
+			active = &s[i]
+		}
+	}
+	if active == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(active.Reward)
+}
+
+// UncleReward returns the reward credited to the miner of an uncle included in
+// the given header: (uncle + 8 - header) / 8 of the block reward, decreasing the
+// more generations the uncle lags behind.
+func UncleReward(header, uncle *types.Header, blockReward *big.Int) *big.Int {
+	r := new(big.Int).Add(uncle.Number, big8)
+	r.Sub(r, header.Number)
+	r.Mul(r, blockReward)
+	return r.Div(r, big8)
+}
+
+// InclusionReward returns the bonus credited to the miner of a block for each
+// uncle it includes, 1/32 of the block reward.
+func InclusionReward(blockReward *big.Int) *big.Int {
+	return new(big.Int).Div(blockReward, big32)
+}
+
+// AccumulateRewards credits the coinbase of the given block with the mining
+// reward. The total reward consists of the static block reward and rewards for
+// included uncles. The coinbase of each uncle block is also rewarded. The
+// credits made are returned for the audit ledger.
+func AccumulateRewards(state *state.StateDB, header *types.Header, uncles []*types.Header, blockReward *big.Int) []ledger.Entry {
+	entries := []ledger.Entry{{Account: header.Coinbase, Kind: ledger.KindBlockReward, Amount: blockReward}}
+
+	reward := new(big.Int).Set(blockReward)
+	for _, uncle := range uncles {
+		uncleReward, inclusion := UncleReward(header, uncle, blockReward), InclusionReward(blockReward)
+		state.AddBalance(uncle.Coinbase, uncleReward)
+		reward.Add(reward, inclusion)
+
+		entries = append(entries,
+			ledger.Entry{Account: uncle.Coinbase, Kind: ledger.KindUncleReward, Amount: uncleReward},
+			ledger.Entry{Account: header.Coinbase, Kind: ledger.KindUncleInclusion, Amount: inclusion},
+		)
+	}
+	state.AddBalance(header.Coinbase, reward)
+	return entries
+}
+
+// VerifyUncles verifies the inclusion rules of the given block's uncles: there
+// may be at most MaxUncles of them, each must be a sibling of one of the last
+// MaxUncleDepth ancestors (but not of the block itself), and no uncle may be
+// included twice within that window. The engine specific header rules of each
+// uncle are checked by the given callback, along with the uncle's parent.
+func VerifyUncles(chain consensus.ChainReader, block *types.Block, verify func(uncle, parent *types.Header) error) error {
+	// Verify that there are at most 2 uncles included in this block
+	if len(block.Uncles()) > MaxUncles {
+		return ErrTooManyUncles
+	}
+	if len(block.Uncles()) == 0 {
+		return nil
+	}
+	// Gather the set of past uncles and ancestors
+	uncles, ancestors := mapset.NewSet(), make(map[common.Hash]*types.Header)
+
+	number, parent := block.NumberU64()-1, block.ParentHash()
+	for i := 0; i < MaxUncleDepth; i++ {
+		ancestorHeader := chain.GetHeader(parent, number)
+		if ancestorHeader == nil {
+			break
+		}
+		ancestors[parent] = ancestorHeader
+		// If the ancestor doesn't have any uncles, we don't have to iterate them
+		if ancestorHeader.UncleHash != types.EmptyUncleHash {
+			// Need to add those uncles to the banned list too
+			ancestor := chain.GetBlock(parent, number)
+			if ancestor == nil {
+				break
+			}
+			for _, uncle := range ancestor.Uncles() {
+				uncles.Add(uncle.Hash())
+			}
+		}
+		parent, number = ancestorHeader.ParentHash, number-1
+	}
+	ancestors[block.Hash()] = block.Header()
+	uncles.Add(block.Hash())
+
+	// Verify each of the uncles that it's recent, but not an ancestor
+	for _, uncle := range block.Uncles() {
+		// Make sure every uncle is rewarded only once
+		hash := uncle.Hash()
+		if uncles.Contains(hash) {
+			return ErrDuplicateUncle
+		}
+		uncles.Add(hash)
+
+		// Make sure the uncle has a valid ancestry
+		if ancestors[hash] != nil {
+			return ErrUncleIsAncestor
+		}
+		if ancestors[uncle.ParentHash] == nil || uncle.ParentHash == block.ParentHash() {
+			return ErrDanglingUncle
+		}
+		if err := verify(uncle, ancestors[uncle.ParentHash]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
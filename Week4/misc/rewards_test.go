@@ -0,0 +1,130 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// Tests that the reward schedule picks the latest activated step, and that the
+// uncle rewards match the stock ethash values.
+func TestRewardSchedule(t *testing.T) {
+	schedule := misc.RewardSchedule{
+		{Block: big.NewInt(0), Reward: big.NewInt(5e+18)},
+		{Block: big.NewInt(100), Reward: big.NewInt(3e+18)},
+		{Block: big.NewInt(200), Reward: big.NewInt(1e+18)},
+		{Block: big.NewInt(200), Reward: big.NewInt(2e+18)}, // Same block, last one wins
+	}
+	tests := []struct {
+		number int64
+		reward *big.Int
+	}{
+		{0, big.NewInt(5e+18)},
+		{99, big.NewInt(5e+18)},
+		{100, big.NewInt(3e+18)},
+		{199, big.NewInt(3e+18)},
+		{200, big.NewInt(2e+18)},
+		{1000000, big.NewInt(2e+18)},
+	}
+	for i, tt := range tests {
+		if reward := schedule.BlockReward(big.NewInt(tt.number)); reward.Cmp(tt.reward) != 0 {
+			t.Errorf("test %d: reward mismatch: have %v, want %v", i, reward, tt.reward)
+		}
+	}
+	if reward := (misc.RewardSchedule{{Block: big.NewInt(10), Reward: big.NewInt(1)}}).BlockReward(big.NewInt(9)); reward.Sign() != 0 {
+		t.Errorf("inactive schedule reward mismatch: have %v, want 0", reward)
+	}
+	// Uncle rewards shrink by an eighth with every generation of lag
+	header := &types.Header{Number: big.NewInt(10)}
+	for i, want := range []int64{4375e+14, 375e+15, 3125e+14, 25e+16, 1875e+14, 125e+15, 625e+14} {
+		depth := i + 1
+		uncle := &types.Header{Number: big.NewInt(int64(10 - depth))}
+		if reward := misc.UncleReward(header, uncle, big.NewInt(5e+17)); reward.Int64() != want {
+			t.Errorf("depth %d: uncle reward mismatch: have %v, want %v", depth, reward, want)
+		}
+	}
+	if reward := misc.InclusionReward(big.NewInt(2e+18)); reward.Int64() != 625e+14 {
+		t.Errorf("inclusion reward mismatch: have %v, want %d", reward, int64(625e+14))
+	}
+}
+
+// Tests the uncle inclusion rules: count, depth, ancestry and duplication.
+func TestVerifyUncles(t *testing.T) {
+	// Assemble a canonical chain of ten blocks, with block 5 including a sibling
+	// of block 4 as an uncle
+	var (
+		chain   = headerbuilder.NewMemoryChain(params.TestChainConfig)
+		blocks  []*types.Block
+		sibling = func(parent *types.Block, tag byte) *types.Header {
+			return &types.Header{Number: new(big.Int).Add(parent.Number(), common.Big1), ParentHash: parent.Hash(), Extra: []byte{tag}}
+		}
+	)
+	for i := 0; i < 10; i++ {
+		header := &types.Header{Number: big.NewInt(int64(i))}
+		var uncles []*types.Header
+		if i > 0 {
+			header.ParentHash = blocks[i-1].Hash()
+		}
+		if i == 5 {
+			uncles = append(uncles, sibling(blocks[3], 0xff))
+		}
+		block := types.NewBlock(header, nil, uncles, nil, trie.NewStackTrie(nil))
+		chain.InsertBlocks(block)
+		blocks = append(blocks, block)
+	}
+	head := blocks[9]
+	errVerify := errors.New("verify")
+
+	tests := []struct {
+		uncles []*types.Header
+		verify error
+		err    error
+	}{
+		{nil, nil, nil},
+		{[]*types.Header{sibling(blocks[7], 1)}, nil, nil},
+		{[]*types.Header{sibling(blocks[3], 1), sibling(blocks[8], 1)}, nil, nil},
+		{[]*types.Header{sibling(blocks[7], 1), sibling(blocks[7], 2), sibling(blocks[7], 3)}, nil, misc.ErrTooManyUncles},
+		{[]*types.Header{sibling(blocks[7], 1), sibling(blocks[7], 1)}, nil, misc.ErrDuplicateUncle},
+		{[]*types.Header{sibling(blocks[3], 0xff)}, nil, misc.ErrDuplicateUncle}, // Already included by block 5
+		{[]*types.Header{blocks[6].Header()}, nil, misc.ErrUncleIsAncestor},
+		{[]*types.Header{sibling(blocks[9], 1)}, nil, misc.ErrDanglingUncle}, // Sibling of the block itself
+		{[]*types.Header{sibling(blocks[2], 1)}, nil, misc.ErrDanglingUncle}, // Deeper than MaxUncleDepth
+		{[]*types.Header{sibling(blocks[7], 1)}, errVerify, errVerify},
+	}
+	for i, tt := range tests {
+		block := types.NewBlock(&types.Header{Number: big.NewInt(10), ParentHash: head.Hash()}, nil, tt.uncles, nil, trie.NewStackTrie(nil))
+
+		err := misc.VerifyUncles(chain, block, func(uncle, parent *types.Header) error {
+			if parent.Hash() != uncle.ParentHash {
+				t.Errorf("test %d: parent mismatch: have %x, want %x", i, parent.Hash(), uncle.ParentHash)
+			}
+			return tt.verify
+		})
+		if err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
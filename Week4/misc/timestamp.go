@@ -0,0 +1,63 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// MedianTimeSpan is the number of most recent ancestors whose timestamps are
+// considered by the median-time-past rule.
+const MedianTimeSpan = 11
+
+// MedianTimePast returns the median timestamp of the last MedianTimeSpan headers
+// ending with (and including) the given parent. Close to genesis, where fewer
+// ancestors exist, the median of all available ones is used.
+func MedianTimePast(chain consensus.ChainHeaderReader, parent *types.Header) (uint64, error) {
+	times := make([]uint64, 0, MedianTimeSpan)
+	for header := parent; ; {
+		times = append(times, header.Time)
+		if len(times) == MedianTimeSpan || header.Number.Sign() == 0 {
+			break
+		}
+		header = chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+		if header == nil {
+			return 0, consensus.ErrUnknownAncestor
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2], nil
+}
+
+// VerifyMedianTimePast verifies that the header's timestamp is strictly greater
+// than the median timestamp of its recent ancestors, as per Bitcoin's MTP rule.
+// Unlike the parent+1 rule, this allows individual blocks to be timestamped
+// before their parent, while still bounding how far back the clock may go.
+func VerifyMedianTimePast(chain consensus.ChainHeaderReader, header, parent *types.Header) error {
+	median, err := MedianTimePast(chain, parent)
+	if err != nil {
+		return err
+	}
+	if header.Time <= median {
+		return fmt.Errorf("timestamp not after median time past: have %d, median %d", header.Time, median)
+	}
+	return nil
+}
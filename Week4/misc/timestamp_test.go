@@ -0,0 +1,90 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package misc
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// timeChain assembles a header chain with the given timestamps, returning the
+// headers along with a reader that can resolve all of them.
+func timeChain(times ...uint64) ([]*types.Header, consensus.ChainHeaderReader) {
+	headers := make([]*types.Header, len(times))
+	for i, time := range times {
+		headers[i] = &types.Header{Number: big.NewInt(int64(i)), Time: time}
+		if i > 0 {
+			headers[i].ParentHash = headers[i-1].Hash()
+		}
+	}
+	return headers, consensus.WithHeaders(nil, headers)
+}
+
+// Tests that the median time past is taken over the last 11 headers only, and
+// over all available ones close to genesis.
+func TestMedianTimePast(t *testing.T) {
+	tests := []struct {
+		times  []uint64
+		median uint64
+	}{
+		{[]uint64{100}, 100},
+		{[]uint64{100, 110, 105}, 105},
+		{[]uint64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110}, 60},
+		// The oldest headers fall out of the window
+		{[]uint64{1000, 1000, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110}, 60},
+		// Out of order timestamps are sorted first
+		{[]uint64{110, 10, 100, 20, 90, 30, 80, 40, 70, 50, 60}, 60},
+	}
+	for i, tt := range tests {
+		headers, chain := timeChain(tt.times...)
+		median, err := MedianTimePast(chain, headers[len(headers)-1])
+		if err != nil {
+			t.Errorf("test %d: failed to calculate median: %v", i, err)
+			continue
+		}
+		if median != tt.median {
+			t.Errorf("test %d: median mismatch: have %d, want %d", i, median, tt.median)
+		}
+	}
+}
+
+// Tests that the median-time-past rule accepts timestamps older than the parent
+// as long as they are newer than the median.
+func TestVerifyMedianTimePast(t *testing.T) {
+	headers, chain := timeChain(10, 20, 30, 40, 50)
+	parent := headers[len(headers)-1]
+
+	tests := []struct {
+		time uint64
+		ok   bool
+	}{
+		{29, false},
+		{30, false},
+		{31, true},
+		{45, true}, // Older than the parent, but still valid
+		{60, true},
+	}
+	for i, tt := range tests {
+		header := &types.Header{Number: big.NewInt(5), ParentHash: parent.Hash(), Time: tt.time}
+		if err := VerifyMedianTimePast(chain, header, parent); (err == nil) != tt.ok {
+			t.Errorf("test %d: validity mismatch: have %v, want ok=%v", i, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package poet
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the poet specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeUnknownBlock       = -39601
+	codeMissingVanity      = -39602
+	codeInvalidExtra       = -39603
+	codeInvalidNonce       = -39604
+	codeInvalidMixDigest   = -39605
+	codeInvalidUncleHash   = -39606
+	codeWrongDifficulty    = -39607
+	codeEarlyBlock         = -39608
+	codeUnauthorizedSealer = -39609
+	codeMissingKey         = -39610
+)
+
+// Register stable numeric codes for the poet specific errors.
+func init() {
+	consensus.RegisterErrorCode(errUnknownBlock, codeUnknownBlock)
+	consensus.RegisterErrorCode(errMissingVanity, codeMissingVanity)
+	consensus.RegisterErrorCode(errInvalidExtra, codeInvalidExtra)
+	consensus.RegisterErrorCode(errInvalidNonce, codeInvalidNonce)
+	consensus.RegisterErrorCode(errInvalidMixDigest, codeInvalidMixDigest)
+	consensus.RegisterErrorCode(errInvalidUncleHash, codeInvalidUncleHash)
+	consensus.RegisterErrorCode(errWrongDifficulty, codeWrongDifficulty)
+	consensus.RegisterErrorCode(errEarlyBlock, codeEarlyBlock)
+	consensus.RegisterErrorCode(errUnauthorizedSealer, codeUnauthorizedSealer)
+	consensus.RegisterErrorCode(errMissingKey, codeMissingKey)
+}
@@ -0,0 +1,457 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package poet implements a proof-of-elapsed-time style lottery engine.
+//
+// For every block, each sealer draws a random wait time from a verifiable random
+// function evaluated on the parent's VRF output and the block number. The sealer with the shortest wait may
+// seal first, and its block carries the highest difficulty, so it wins any race
+// against sealers with longer waits. The VRF proof is stored in the header, so
+// everyone can check that the wait was drawn fairly instead of made up. Chaining
+// the draws on the previous VRF outputs rather than on the parent hash keeps the
+// sealer of a block from grinding its contents for a favourable next draw.
+package poet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+
+	defaultPeriod  = 1  // Default minimum number of seconds between blocks
+	defaultMaxWait = 10 // Default upper bound of the drawn wait times in seconds
+)
+
+// Lottery protocol constants.
+var (
+	extraVanity = 32                     // Fixed number of extra-data prefix bytes reserved for sealer vanity
+	extraSeal   = crypto.SignatureLength // Fixed number of extra-data suffix bytes reserved for the seal
+
+	nilUncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of sealers is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errInvalidExtra is returned if a block's extra-data section doesn't consist
+	// of the vanity, the VRF proof and the seal.
+	errInvalidExtra = errors.New("invalid extra-data length")
+
+	// errInvalidNonce is returned if a block's nonce is non-zero.
+	errInvalidNonce = errors.New("non-zero nonce")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errWrongDifficulty is returned if a block's difficulty doesn't match the
+	// wait time drawn by its sealer.
+	errWrongDifficulty = errors.New("wrong difficulty")
+
+	// errEarlyBlock is returned if a block is sealed before its sealer's wait
+	// time elapsed.
+	errEarlyBlock = errors.New("block sealed before wait time elapsed")
+
+	// errUnauthorizedSealer is returned if a header is signed by an account
+	// outside of the sealer set.
+	errUnauthorizedSealer = errors.New("unauthorized sealer")
+
+	// errMissingKey is returned if the local node is asked to seal without a key.
+	errMissingKey = errors.New("no sealing key authorized")
+)
+
+// Config are the configuration parameters of the lottery engine.
+type Config struct {
+	Period  uint64           // Minimum number of seconds between blocks
+	MaxWait uint64           // Upper bound of the drawn wait times in seconds
+	Sealers []common.Address // Accounts allowed to take part in the lottery
+}
+
+// PoET is the proof-of-elapsed-time style lottery engine.
+type PoET struct {
+	config     Config
+	signatures *lru.ARCCache // Public keys of the sealers of recent blocks
+
+	key  *ecdsa.PrivateKey // Sealing key, also used to evaluate the VRF
+	lock sync.RWMutex      // Protects the key field
+}
+
+// New creates a lottery consensus engine.
+func New(config Config) *PoET {
+	if config.Period == 0 {
+		config.Period = defaultPeriod
+	}
+	if config.MaxWait == 0 {
+		config.MaxWait = defaultMaxWait
+	}
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	return &PoET{
+		config:     config,
+		signatures: signatures,
+	}
+}
+
+// ecrecover extracts the public key of the sealer from a signed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (*ecdsa.PublicKey, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if pubkey, known := sigcache.Get(hash); known {
+		return pubkey.(*ecdsa.PublicKey), nil
+	}
+	// Retrieve the signature from the header extra-data
+	if len(header.Extra) < extraSeal {
+		return nil, errInvalidExtra
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	pubkey, err := crypto.SigToPub(clique.SealHash(header).Bytes(), signature)
+	if err != nil {
+		return nil, err
+	}
+	sigcache.Add(hash, pubkey)
+	return pubkey, nil
+}
+
+// vrfInput returns the VRF input of the lottery for the child of the parent:
+// the parent's VRF output, or the genesis hash, chained with the block number.
+// The output of a sealer is fixed by its key and its own input, so unlike the
+// parent hash, nothing in the parent block can be varied to influence the draw.
+func vrfInput(parent *types.Header) []byte {
+	seed := parent.Hash()
+	if parent.Number.Sign() > 0 && len(parent.Extra) == extraVanity+vrfProofLength+extraSeal {
+		seed = vrfOutput(parent.Extra[extraVanity : extraVanity+vrfGammaLength])
+	}
+	number := make([]byte, 8)
+	binary.BigEndian.PutUint64(number, parent.Number.Uint64()+1)
+	return crypto.Keccak256(seed.Bytes(), number)
+}
+
+// wait maps a VRF output to a wait time in [0, MaxWait) seconds.
+func (p *PoET) wait(output common.Hash) uint64 {
+	wait := new(big.Int).SetBytes(output[:])
+	return wait.Mod(wait, new(big.Int).SetUint64(p.config.MaxWait)).Uint64()
+}
+
+// difficulty returns the difficulty of a block sealed after the given wait time,
+// which is higher for shorter waits.
+func (p *PoET) difficulty(wait uint64) *big.Int {
+	return new(big.Int).SetUint64(p.config.MaxWait - wait)
+}
+
+// isSealer reports whether the account takes part in the lottery.
+func (p *PoET) isSealer(address common.Address) bool {
+	for _, sealer := range p.config.Sealers {
+		if sealer == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Author implements consensus.Engine, returning the Ethereum address recovered
+// from the signature in the header's extra-data section.
+func (p *PoET) Author(header *types.Header) (common.Address, error) {
+	pubkey, err := ecrecover(header, p.signatures)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (p *PoET) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return p.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (p *PoET) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	return consensus.VerifyHeadersSequential(headers, func(i int) error {
+		return p.verifyHeader(chain, headers[i], headers[:i])
+	})
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (p *PoET) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	// Ensure the fields meaningless for the lottery are left empty
+	if header.Nonce != (types.BlockNonce{}) {
+		return errInvalidNonce
+	}
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	if header.UncleHash != nilUncleHash {
+		return errInvalidUncleHash
+	}
+	// Verify that the gas limit is <= 2^63-1
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, params.MaxGasLimit)
+	}
+	// The genesis block is the always valid dead-end
+	if number == 0 {
+		return nil
+	}
+	if len(header.Extra) < extraVanity {
+		return errMissingVanity
+	}
+	if len(header.Extra) != extraVanity+vrfProofLength+extraSeal {
+		return errInvalidExtra
+	}
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, want <nil>", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// All basic checks passed, verify the lottery draw
+	return p.verifySeal(header, parent)
+}
+
+// verifySeal checks that the header is signed by a sealer, that the embedded
+// VRF proof of the sealer's wait time is valid, and that the block honours the
+// wait time both in its timestamp and its difficulty.
+func (p *PoET) verifySeal(header, parent *types.Header) error {
+	pubkey, err := ecrecover(header, p.signatures)
+	if err != nil {
+		return err
+	}
+	if !p.isSealer(crypto.PubkeyToAddress(*pubkey)) {
+		return errUnauthorizedSealer
+	}
+	proof := header.Extra[extraVanity : extraVanity+vrfProofLength]
+	output, err := vrfVerify(pubkey, vrfInput(parent), proof)
+	if err != nil {
+		return err
+	}
+	wait := p.wait(output)
+	if header.Time < parent.Time+p.config.Period+wait {
+		return errEarlyBlock
+	}
+	if header.Difficulty == nil || header.Difficulty.Cmp(p.difficulty(wait)) != 0 {
+		return errWrongDifficulty
+	}
+	return nil
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (p *PoET) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, drawing the local sealer's wait time and
+// preparing all the consensus fields of the header accordingly.
+func (p *PoET) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	p.lock.RLock()
+	key := p.key
+	p.lock.RUnlock()
+
+	if key == nil {
+		return errMissingKey
+	}
+	proof, output := vrfProve(key, vrfInput(parent))
+	wait := p.wait(output)
+
+	header.Difficulty = p.difficulty(wait)
+	header.Time = parent.Time + p.config.Period + wait
+	if header.Time < uint64(time.Now().Unix()) {
+		header.Time = uint64(time.Now().Unix())
+	}
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	header.Extra = append(append(header.Extra[:extraVanity], proof...), make([]byte, extraSeal)...)
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given.
+func (p *PoET) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	// No block rewards in the lottery, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = nilUncleHash
+}
+
+// FinalizeAndAssemble implements consensus.Engine, ensuring no uncles are set,
+// nor block rewards given, and returns the final block.
+func (p *PoET) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// Finalize block
+	p.Finalize(chain, header, state, txs, uncles)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+// Authorize injects the private key into the consensus engine to draw wait
+// times and seal blocks with. Unlike signature based engines, the raw key is
+// needed, as an external signer can't evaluate the VRF.
+func (p *PoET) Authorize(key *ecdsa.PrivateKey) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.key = key
+}
+
+// Seal implements consensus.Engine, signing the block and releasing it once the
+// drawn wait time elapsed.
+func (p *PoET) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	if header.Number.Uint64() == 0 {
+		return errUnknownBlock
+	}
+	p.lock.RLock()
+	key := p.key
+	p.lock.RUnlock()
+
+	if key == nil {
+		return errMissingKey
+	}
+	if !p.isSealer(crypto.PubkeyToAddress(key.PublicKey)) {
+		return errUnauthorizedSealer
+	}
+	if len(header.Extra) != extraVanity+vrfProofLength+extraSeal {
+		return errInvalidExtra
+	}
+	sighash, err := crypto.Sign(clique.SealHash(header).Bytes(), key)
+	if err != nil {
+		return err
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
+
+	delay := time.Until(time.Unix(int64(header.Time), 0))
+	log.Trace("Waiting for drawn time to elapse", "delay", common.PrettyDuration(delay))
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		select {
+		case results <- block.WithSeal(header):
+		default:
+			log.Warn("Sealing result is not read by miner", "sealhash", clique.SealHash(header))
+		}
+	}()
+	return nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (p *PoET) SealHash(header *types.Header) common.Hash {
+	return clique.SealHash(header)
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// of a block sealed by the local sealer, higher for shorter drawn wait times.
+func (p *PoET) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	p.lock.RLock()
+	key := p.key
+	p.lock.RUnlock()
+
+	if key == nil {
+		return big.NewInt(1)
+	}
+	_, output := vrfProve(key, vrfInput(parent))
+	return p.difficulty(p.wait(output))
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs. The
+// engine has none yet.
+func (p *PoET) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return nil
+}
+
+// Close implements consensus.Engine. It's a noop as there are no background threads.
+func (p *PoET) Close() error {
+	return nil
+}
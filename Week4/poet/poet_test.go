@@ -0,0 +1,185 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package poet
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// Tests that VRF proofs verify for the proving key and input only, and that the
+// output is unique.
+func TestVRF(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	alpha := []byte("parent hash")
+
+	proof, output := vrfProve(key, alpha)
+	if have, err := vrfVerify(&key.PublicKey, alpha, proof); err != nil || have != output {
+		t.Fatalf("valid proof rejected: have %x (%v), want %x", have, err, output)
+	}
+	if again, output2 := vrfProve(key, alpha); output2 != output || string(again) != string(proof) {
+		t.Errorf("output not deterministic: have %x, want %x", output2, output)
+	}
+	if _, err := vrfVerify(&other.PublicKey, alpha, proof); err != errInvalidVRFProof {
+		t.Errorf("proof verified for another key: %v", err)
+	}
+	if _, err := vrfVerify(&key.PublicKey, []byte("other input"), proof); err != errInvalidVRFProof {
+		t.Errorf("proof verified for another input: %v", err)
+	}
+	for _, i := range []int{10, 70, 100} {
+		tampered := common.CopyBytes(proof)
+		tampered[i] ^= 0x01
+		if _, err := vrfVerify(&key.PublicKey, alpha, tampered); err != errInvalidVRFProof {
+			t.Errorf("proof tampered at byte %d verified: %v", i, err)
+		}
+	}
+}
+
+// Tests that the lottery input of a block is chained on the VRF output of its
+// parent, so the parent's sealer can't grind its block for a favourable draw.
+func TestVRFInput(t *testing.T) {
+	sealer, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+
+	genesis := headerbuilder.New()
+	proof, _ := vrfProve(sealer, vrfInput(genesis))
+	otherProof, _ := vrfProve(other, vrfInput(genesis))
+
+	parent := func(proof []byte, opts ...headerbuilder.Option) *types.Header {
+		opts = append([]headerbuilder.Option{
+			headerbuilder.WithParent(genesis),
+			headerbuilder.WithExtra(append(make([]byte, extraVanity), proof...)),
+		}, opts...)
+		return headerbuilder.New(append(opts, headerbuilder.SignedBy(sealer, clique.SealHash))...)
+	}
+	input := vrfInput(parent(proof))
+
+	tests := []struct {
+		parent *types.Header
+		same   bool
+	}{
+		// The contents of the parent don't influence the draw
+		{parent(proof, headerbuilder.WithTime(genesis.Time+7)), true},
+		{parent(proof, headerbuilder.WithCoinbase(common.Address{0x01})), true},
+		{parent(proof, headerbuilder.WithDifficulty(big.NewInt(3))), true},
+
+		// The sealer of the parent and the height do
+		{parent(otherProof), false},
+		{headerbuilder.New(headerbuilder.WithParent(parent(proof)), headerbuilder.WithExtra(append(make([]byte, extraVanity), proof...))), false},
+	}
+	for i, tt := range tests {
+		if have := vrfInput(tt.parent); (string(have) == string(input)) != tt.same {
+			t.Errorf("test %d: input equality mismatch: have %x, want equal %v to %x", i, have, tt.same, input)
+		}
+	}
+}
+
+// Tests that blocks must carry a valid lottery draw of their sealer, and honour
+// the drawn wait time in both timestamp and difficulty.
+func TestVerifyLottery(t *testing.T) {
+	sealer, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	outsider, _ := crypto.GenerateKey()
+
+	genesis := headerbuilder.New(headerbuilder.WithTime(uint64(time.Now().Unix()) - 1000))
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+	engine := New(Config{Sealers: []common.Address{
+		crypto.PubkeyToAddress(sealer.PublicKey),
+		crypto.PubkeyToAddress(other.PublicKey),
+	}})
+	proof, output := vrfProve(sealer, vrfInput(genesis))
+	wait := engine.wait(output)
+
+	otherProof, _ := vrfProve(other, vrfInput(genesis))
+	staleProof, _ := vrfProve(sealer, genesis.Hash().Bytes())
+	outsiderProof, outsiderOutput := vrfProve(outsider, vrfInput(genesis))
+
+	tests := []struct {
+		key   *ecdsa.PrivateKey
+		proof []byte
+		delay uint64
+		diff  *big.Int
+		err   error
+	}{
+		{sealer, proof, 1 + wait, engine.difficulty(wait), nil},
+		{sealer, proof, 1 + wait + 5, engine.difficulty(wait), nil},
+		{sealer, proof, wait, engine.difficulty(wait), errEarlyBlock},
+		{sealer, proof, 1 + wait, new(big.Int).Add(engine.difficulty(wait), common.Big1), errWrongDifficulty},
+		{sealer, otherProof, 1 + wait, engine.difficulty(wait), errInvalidVRFProof},
+		{sealer, staleProof, 1 + wait, engine.difficulty(wait), errInvalidVRFProof},
+		{outsider, outsiderProof, 1 + engine.wait(outsiderOutput), engine.difficulty(engine.wait(outsiderOutput)), errUnauthorizedSealer},
+	}
+	for i, tt := range tests {
+		header := headerbuilder.New(
+			headerbuilder.WithParent(genesis),
+			headerbuilder.WithTime(genesis.Time+tt.delay),
+			headerbuilder.WithDifficulty(tt.diff),
+			headerbuilder.WithExtra(append(make([]byte, extraVanity), tt.proof...)),
+			headerbuilder.SignedBy(tt.key, clique.SealHash),
+		)
+		if err := engine.VerifyHeader(chain, header, true); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that a block prepared and sealed by the engine passes verification.
+func TestSealAndVerify(t *testing.T) {
+	leakcheck.Check(t)
+
+	key, _ := crypto.GenerateKey()
+	genesis := headerbuilder.New(headerbuilder.WithTime(uint64(time.Now().Unix()) - 1000))
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+
+	engine := New(Config{Sealers: []common.Address{crypto.PubkeyToAddress(key.PublicKey)}})
+	engine.Authorize(key)
+
+	header := headerbuilder.New(headerbuilder.WithParent(genesis))
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("failed to prepare header: %v", err)
+	}
+	if have, want := engine.CalcDifficulty(chain, header.Time, genesis), header.Difficulty; have.Cmp(want) != 0 {
+		t.Errorf("difficulty mismatch: have %v, want %v", have, want)
+	}
+	results := make(chan *types.Block, 1)
+	if err := engine.Seal(chain, types.NewBlockWithHeader(header), results, nil); err != nil {
+		t.Fatalf("failed to seal block: %v", err)
+	}
+	select {
+	case block := <-results:
+		if err := engine.VerifyHeader(chain, block.Header(), true); err != nil {
+			t.Errorf("sealed block invalid: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("block not sealed")
+	}
+}
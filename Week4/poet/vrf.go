@@ -0,0 +1,159 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package poet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// The lottery is drawn with a verifiable random function: a sealer evaluates it
+// on the chained output of the parent (see vrfInput) with its private key, and anyone can check the result with
+// the sealer's public key. Unlike a signature, the output is unique for a key
+// and input, so sealers can't grind for a shorter wait.
+//
+// The construction follows ECVRF over secp256k1 with try-and-increment hashing
+// to the curve. A proof is the point Gamma = x*H(pk, alpha) along with a Schnorr
+// style proof (c, s) that Gamma and the public key share the discrete log x.
+
+const (
+	vrfGammaLength = 65 // Size of the uncompressed Gamma point leading a VRF proof
+
+	// vrfProofLength is the size of a VRF proof: the Gamma point followed by two
+	// 32 byte scalars.
+	vrfProofLength = vrfGammaLength + 32 + 32
+)
+
+// errInvalidVRFProof is returned if a VRF proof doesn't verify.
+var errInvalidVRFProof = errors.New("invalid vrf proof")
+
+// curveB is the constant of the secp256k1 curve equation y^2 = x^3 + 7.
+var curveB = big.NewInt(7)
+
+// hashToCurve deterministically maps the public key and input to a curve point.
+func hashToCurve(pub *ecdsa.PublicKey, alpha []byte) (*big.Int, *big.Int) {
+	var (
+		curve = crypto.S256()
+		p     = curve.Params().P
+		seed  = append(crypto.CompressPubkey(pub), alpha...)
+	)
+	for ctr := uint32(0); ; ctr++ {
+		x := new(big.Int).SetBytes(crypto.Keccak256(seed, []byte{byte(ctr >> 24), byte(ctr >> 16), byte(ctr >> 8), byte(ctr)}))
+		if x.Cmp(p) >= 0 {
+			continue
+		}
+		rhs := new(big.Int).Exp(x, big.NewInt(3), p)
+		rhs.Add(rhs, curveB).Mod(rhs, p)
+
+		y := new(big.Int).ModSqrt(rhs, p)
+		if y == nil {
+			continue
+		}
+		if y.Bit(0) == 1 {
+			y.Sub(p, y)
+		}
+		return x, y
+	}
+}
+
+// scalar serializes a scalar for point multiplication.
+func scalar(k *big.Int) []byte {
+	return common.LeftPadBytes(k.Bytes(), 32)
+}
+
+// hashPoints derives the challenge scalar from a list of points.
+func hashPoints(points ...*big.Int) *big.Int {
+	var data []byte
+	for i := 0; i < len(points); i += 2 {
+		data = append(data, elliptic.Marshal(crypto.S256(), points[i], points[i+1])...)
+	}
+	c := new(big.Int).SetBytes(crypto.Keccak256(data))
+	return c.Mod(c, crypto.S256().Params().N)
+}
+
+// vrfOutput derives the random output from the Gamma point of a proof.
+func vrfOutput(gamma []byte) common.Hash {
+	return crypto.Keccak256Hash(gamma)
+}
+
+// vrfProve evaluates the VRF on the input, returning the proof and the output.
+func vrfProve(key *ecdsa.PrivateKey, alpha []byte) ([]byte, common.Hash) {
+	var (
+		curve = crypto.S256()
+		n     = curve.Params().N
+	)
+	hx, hy := hashToCurve(&key.PublicKey, alpha)
+	gx, gy := curve.ScalarMult(hx, hy, scalar(key.D))
+
+	// Derive the nonce deterministically from the key and the input point
+	k := new(big.Int).SetBytes(crypto.Keccak256(scalar(key.D), elliptic.Marshal(curve, hx, hy)))
+	if k.Mod(k, n).Sign() == 0 {
+		k.SetInt64(1)
+	}
+	ux, uy := curve.ScalarBaseMult(scalar(k))
+	vx, vy := curve.ScalarMult(hx, hy, scalar(k))
+
+	c := hashPoints(hx, hy, gx, gy, ux, uy, vx, vy)
+	s := new(big.Int).Mul(c, key.D)
+	s.Add(s, k).Mod(s, n)
+
+	gamma := elliptic.Marshal(curve, gx, gy)
+	proof := append(append(gamma, scalar(c)...), scalar(s)...)
+	return proof, vrfOutput(gamma)
+}
+
+// vrfVerify checks a VRF proof of the given public key on the input, returning
+// the output if valid.
+func vrfVerify(pub *ecdsa.PublicKey, alpha []byte, proof []byte) (common.Hash, error) {
+	if len(proof) != vrfProofLength {
+		return common.Hash{}, errInvalidVRFProof
+	}
+	var (
+		curve = crypto.S256()
+		n     = curve.Params().N
+	)
+	gx, gy := elliptic.Unmarshal(curve, proof[:vrfGammaLength])
+	if gx == nil {
+		return common.Hash{}, errInvalidVRFProof
+	}
+	c := new(big.Int).SetBytes(proof[vrfGammaLength : vrfGammaLength+32])
+	s := new(big.Int).SetBytes(proof[vrfGammaLength+32:])
+	if c.Sign() == 0 || c.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return common.Hash{}, errInvalidVRFProof
+	}
+	negc := new(big.Int).Sub(n, c)
+	hx, hy := hashToCurve(pub, alpha)
+
+	// U = s*G - c*pk and V = s*H - c*Gamma must reproduce the prover's nonce points
+	sgx, sgy := curve.ScalarBaseMult(scalar(s))
+	cpx, cpy := curve.ScalarMult(pub.X, pub.Y, scalar(negc))
+	ux, uy := curve.Add(sgx, sgy, cpx, cpy)
+
+	shx, shy := curve.ScalarMult(hx, hy, scalar(s))
+	cgx, cgy := curve.ScalarMult(gx, gy, scalar(negc))
+	vx, vy := curve.Add(shx, shy, cgx, cgy)
+
+	if hashPoints(hx, hy, gx, gy, ux, uy, vx, vy).Cmp(c) != 0 {
+		return common.Hash{}, errInvalidVRFProof
+	}
+	return vrfOutput(proof[:vrfGammaLength]), nil
+}
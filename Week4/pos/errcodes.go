@@ -0,0 +1,53 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pos
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the pos specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeUnknownBlock                    = -39401
+	codeMissingVanity                   = -39402
+	codeMissingSignature                = -39403
+	codeExtraValidators                 = -39404
+	codeMismatchingCheckpointValidators = -39405
+	codeInvalidMixDigest                = -39406
+	codeInvalidDifficulty               = -39407
+	codeWrongDifficulty                 = -39408
+	codeInvalidTimestamp                = -39409
+	codeEarlyBackup                     = -39410
+	codeUnauthorizedValidator           = -39411
+	codeInvalidEvidence                 = -39412
+	codeMissingState                    = -39413 // Retired, registrations are checked in Finalize
+)
+
+// Register stable numeric codes for the pos specific errors.
+func init() {
+	consensus.RegisterErrorCode(errUnknownBlock, codeUnknownBlock)
+	consensus.RegisterErrorCode(errMissingVanity, codeMissingVanity)
+	consensus.RegisterErrorCode(errMissingSignature, codeMissingSignature)
+	consensus.RegisterErrorCode(errExtraValidators, codeExtraValidators)
+	consensus.RegisterErrorCode(errMismatchingCheckpointValidators, codeMismatchingCheckpointValidators)
+	consensus.RegisterErrorCode(errInvalidMixDigest, codeInvalidMixDigest)
+	consensus.RegisterErrorCode(errInvalidDifficulty, codeInvalidDifficulty)
+	consensus.RegisterErrorCode(errWrongDifficulty, codeWrongDifficulty)
+	consensus.RegisterErrorCode(errInvalidTimestamp, codeInvalidTimestamp)
+	consensus.RegisterErrorCode(errEarlyBackup, codeEarlyBackup)
+	consensus.RegisterErrorCode(errUnauthorizedValidator, codeUnauthorizedValidator)
+	consensus.RegisterErrorCode(errInvalidEvidence, codeInvalidEvidence)
+}
@@ -0,0 +1,690 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package pos implements a stake-weighted proof-of-stake consensus engine.
+//
+// The validator set is committed to in the extra-data of every epoch checkpoint
+// block, so headers can be verified without access to the state. New validators
+// register in a registry account, and every checkpoint commits to the
+// registrations in its own state, after its transactions. As that state only
+// exists once the block is processed, the commitment is checked in Finalize,
+// which makes mismatching checkpoints fail their state root check.
+package pos
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/ledger"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemoryValidatorSets = 128  // Number of recent validator sets to keep in memory
+	inmemorySignatures    = 4096 // Number of recent block signatures to keep in memory
+
+	defaultPeriod = 5   // Default minimum number of seconds between blocks
+	defaultEpoch  = 100 // Default number of blocks after which to checkpoint the validator set
+
+	maxEvidence = 2 // Maximum number of equivocation evidences in a single block
+)
+
+// Proof-of-stake protocol constants.
+var (
+	extraVanity = 32                     // Fixed number of extra-data prefix bytes reserved for vanity
+	extraSeal   = crypto.SignatureLength // Fixed number of extra-data suffix bytes reserved for the seal
+
+	diffProposer = big.NewInt(2) // Block difficulty for the selected proposer
+	diffBackup   = big.NewInt(1) // Block difficulty for backup proposers
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the validator set is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errMissingSignature is returned if a block's extra-data section doesn't seem
+	// to contain a 65 byte secp256k1 signature.
+	errMissingSignature = errors.New("extra-data 65 byte signature suffix missing")
+
+	// errExtraValidators is returned if a non-checkpoint block contains validator
+	// data in its extra-data field.
+	errExtraValidators = errors.New("non-checkpoint block contains extra validator list")
+
+	// errMismatchingCheckpointValidators is returned if a checkpoint block carries
+	// a validator list different than the one the local node calculated.
+	errMismatchingCheckpointValidators = errors.New("mismatching validator list on checkpoint block")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is neither 1 or 2.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errWrongDifficulty is returned if the difficulty of a block doesn't match
+	// whether its signer was the selected proposer.
+	errWrongDifficulty = errors.New("wrong difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errEarlyBackup is returned if a backup proposer sealed a block before the
+	// selected proposer's slot expired.
+	errEarlyBackup = errors.New("backup proposer sealed too early")
+
+	// errUnauthorizedValidator is returned if a header is signed by an account
+	// outside of the validator set.
+	errUnauthorizedValidator = errors.New("unauthorized validator")
+
+	// errInvalidEvidence is returned if an uncle isn't a valid proof of a
+	// validator signing two different blocks at the same height.
+	errInvalidEvidence = errors.New("invalid equivocation evidence")
+)
+
+// Config are the configuration parameters of the proof-of-stake engine.
+type Config struct {
+	Period   uint64         // Minimum number of seconds between blocks
+	Epoch    uint64         // Number of blocks after which to checkpoint the validator set
+	Registry common.Address // Account holding the validator registrations (none if zero)
+
+	// When set, the stakes slashed by finalized blocks are recorded in it.
+	Ledger *ledger.Ledger `json:"-"`
+}
+
+// SignerFn hashes and signs the data to be signed by a backing account.
+type SignerFn func(signer accounts.Account, mimeType string, message []byte) ([]byte, error)
+
+// PoS is a proof-of-stake consensus engine. The proposer of every block is drawn
+// from the validator set weighted by stake; if it fails to show up, any other
+// validator may propose a lower difficulty block after an extra period.
+//
+// Equivocating validators, who sign two different blocks at the same height,
+// can be reported by including the header conflicting with the canonical one as
+// an uncle, upon which the offender's stake is slashed.
+type PoS struct {
+	config Config
+
+	sets       *lru.ARCCache // Validator sets of recent blocks, keyed by the parent hash
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer fields
+}
+
+// New creates a proof-of-stake consensus engine. The initial validator set is
+// taken from the extra-data of the genesis block.
+func New(config Config) *PoS {
+	if config.Period == 0 {
+		config.Period = defaultPeriod
+	}
+	if config.Epoch == 0 {
+		config.Epoch = defaultEpoch
+	}
+	sets, _ := lru.NewARC(inmemoryValidatorSets)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+
+	return &PoS{
+		config:     config,
+		sets:       sets,
+		signatures: signatures,
+	}
+}
+
+// ecrecover extracts the Ethereum account address from a signed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	// Retrieve the signature from the header extra-data
+	if len(header.Extra) < extraSeal {
+		return common.Address{}, errMissingSignature
+	}
+	signature := header.Extra[len(header.Extra)-extraSeal:]
+
+	// Recover the public key and the Ethereum address
+	pubkey, err := crypto.Ecrecover(clique.SealHash(header).Bytes(), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	var signer common.Address
+	copy(signer[:], crypto.Keccak256(pubkey[1:])[12:])
+
+	sigcache.Add(hash, signer)
+	return signer, nil
+}
+
+// Author implements consensus.Engine, returning the Ethereum address recovered
+// from the signature in the header's extra-data section.
+func (p *PoS) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, p.signatures)
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (p *PoS) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return p.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (p *PoS) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	return consensus.VerifyHeadersSequential(headers, func(i int) error {
+		return p.verifyHeader(chain, headers[i], headers[:i])
+	})
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (p *PoS) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	// Check that the extra-data contains the vanity, validators and signature
+	if len(header.Extra) < extraVanity {
+		return errMissingVanity
+	}
+	if len(header.Extra) < extraVanity+extraSeal {
+		return errMissingSignature
+	}
+	validators := header.Extra[extraVanity : len(header.Extra)-extraSeal]
+	checkpoint := number%p.config.Epoch == 0
+	if !checkpoint && len(validators) != 0 {
+		return errExtraValidators
+	}
+	if checkpoint {
+		if _, err := decodeValidators(validators); err != nil {
+			return err
+		}
+	}
+	// Ensure that the mix digest is zero as we don't have fork protection currently
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block's difficulty is meaningful (may not be correct at this point)
+	if number > 0 {
+		if header.Difficulty == nil || (header.Difficulty.Cmp(diffProposer) != 0 && header.Difficulty.Cmp(diffBackup) != 0) {
+			return errInvalidDifficulty
+		}
+	}
+	// Verify that the gas limit is <= 2^63-1
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, params.MaxGasLimit)
+	}
+	// All basic checks passed, verify cascading fields
+	return p.verifyCascadingFields(chain, header, parents)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers.
+func (p *PoS) verifyCascadingFields(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to its parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+p.config.Period > header.Time {
+		return errInvalidTimestamp
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, want <nil>", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// Retrieve the validator set governing this header
+	set, err := p.validators(chain, parent, parents)
+	if err != nil {
+		return err
+	}
+	// Without a registry, ensure checkpoints carry the current set over. With one
+	// they commit to the registrations, which are checked in Finalize.
+	if number%p.config.Epoch == 0 && p.config.Registry == (common.Address{}) {
+		if !bytes.Equal(header.Extra[extraVanity:len(header.Extra)-extraSeal], set.encode()) {
+			return errMismatchingCheckpointValidators
+		}
+	}
+	// All basic checks passed, verify the seal and return
+	return p.verifySeal(set, header, parent)
+}
+
+// validators retrieves the validator set in charge of proposing the child of the
+// given parent, as committed to by the most recent checkpoint at or before it.
+// The caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (p *PoS) validators(chain consensus.ChainHeaderReader, parent *types.Header, parents []*types.Header) (ValidatorSet, error) {
+	var (
+		header  = parent
+		visited []common.Hash
+	)
+	for {
+		// If a set is already known for this header, use that
+		if set, ok := p.sets.Get(header.Hash()); ok {
+			return p.cacheSet(set.(ValidatorSet), visited), nil
+		}
+		visited = append(visited, header.Hash())
+
+		// If we've reached a checkpoint, decode the set from it
+		number := header.Number.Uint64()
+		if number%p.config.Epoch == 0 {
+			if len(header.Extra) < extraVanity+extraSeal {
+				return nil, errMissingSignature
+			}
+			set, err := decodeValidators(header.Extra[extraVanity : len(header.Extra)-extraSeal])
+			if err != nil {
+				return nil, err
+			}
+			return p.cacheSet(set, visited), nil
+		}
+		// Otherwise step back to the parent, preferring the batch if available
+		for len(parents) > 0 && parents[len(parents)-1].Number.Uint64() >= number {
+			parents = parents[:len(parents)-1]
+		}
+		if len(parents) > 0 && parents[len(parents)-1].Hash() == header.ParentHash {
+			header = parents[len(parents)-1]
+		} else {
+			header = chain.GetHeader(header.ParentHash, number-1)
+		}
+		if header == nil {
+			return nil, consensus.ErrUnknownAncestor
+		}
+	}
+}
+
+// cacheSet remembers the validator set for all the given headers.
+func (p *PoS) cacheSet(set ValidatorSet, hashes []common.Hash) ValidatorSet {
+	for _, hash := range hashes {
+		p.sets.Add(hash, set)
+	}
+	return set
+}
+
+// VerifyUncles implements consensus.Engine. Uncles are only allowed as evidence
+// of equivocation: each must be signed by the same validator as the ancestor of
+// the block at the same height, but differ from it. The evidence has to be
+// included within an epoch of the offence.
+func (p *PoS) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	uncles := block.Uncles()
+	if len(uncles) > maxEvidence {
+		return fmt.Errorf("%w: too many uncles", errInvalidEvidence)
+	}
+	seen := make(map[common.Hash]bool)
+	for _, uncle := range uncles {
+		hash := uncle.Hash()
+		if seen[hash] {
+			return fmt.Errorf("%w: duplicate uncle", errInvalidEvidence)
+		}
+		seen[hash] = true
+
+		number := uncle.Number.Uint64()
+		if number == 0 || number >= block.NumberU64() || block.NumberU64()-number > p.config.Epoch {
+			return fmt.Errorf("%w: uncle #%d out of range", errInvalidEvidence, number)
+		}
+		// Find the canonical counterpart of the uncle among the ancestors
+		ancestor := chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+		for ancestor != nil && ancestor.Number.Uint64() > number {
+			ancestor = chain.GetHeader(ancestor.ParentHash, ancestor.Number.Uint64()-1)
+		}
+		if ancestor == nil {
+			return consensus.ErrUnknownAncestor
+		}
+		if ancestor.Hash() == hash {
+			return fmt.Errorf("%w: uncle is ancestor", errInvalidEvidence)
+		}
+		// Both headers must be signed by the same validator
+		offender, err := ecrecover(uncle, p.signatures)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errInvalidEvidence, err)
+		}
+		signer, err := ecrecover(ancestor, p.signatures)
+		if err != nil {
+			return err
+		}
+		if offender != signer {
+			return fmt.Errorf("%w: different signers", errInvalidEvidence)
+		}
+	}
+	return nil
+}
+
+// verifySeal checks whether the signature contained in the header belongs to a
+// validator, and that its difficulty and timing match its proposer role.
+func (p *PoS) verifySeal(set ValidatorSet, header, parent *types.Header) error {
+	signer, err := ecrecover(header, p.signatures)
+	if err != nil {
+		return err
+	}
+	if set.stake(signer) == nil {
+		return errUnauthorizedValidator
+	}
+	if set.proposer(header.ParentHash) == signer {
+		if header.Difficulty.Cmp(diffProposer) != 0 {
+			return errWrongDifficulty
+		}
+		return nil
+	}
+	if header.Difficulty.Cmp(diffBackup) != 0 {
+		return errWrongDifficulty
+	}
+	if header.Time < parent.Time+2*p.config.Period {
+		return errEarlyBackup
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (p *PoS) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	set, err := p.validators(chain, parent, nil)
+	if err != nil {
+		return err
+	}
+	p.lock.RLock()
+	signer := p.signer
+	p.lock.RUnlock()
+
+	// Set the correct difficulty and the earliest time the signer may seal at
+	header.Difficulty = calcDifficulty(set, parent.Hash(), signer)
+	header.Time = parent.Time + p.config.Period
+	if header.Difficulty.Cmp(diffBackup) == 0 {
+		header.Time += p.config.Period
+	}
+	if header.Time < uint64(time.Now().Unix()) {
+		header.Time = uint64(time.Now().Unix())
+	}
+	// Ensure the extra data has all its components, the validator list of
+	// checkpoints is filled in once the state is known
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	header.Extra = append(header.Extra[:extraVanity], make([]byte, extraSeal)...)
+	return nil
+}
+
+// checkpointValidators returns the validator set the given checkpoint must
+// commit to: the validators registered in its state, or the current set if
+// there's no registry or nobody registered.
+func (p *PoS) checkpointValidators(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB) (ValidatorSet, error) {
+	if p.config.Registry != (common.Address{}) {
+		if set := readRegistry(state, p.config.Registry); len(set) > 0 {
+			return set, nil
+		}
+	}
+	parent := chain.GetHeader(header.ParentHash, header.Number.Uint64()-1)
+	if parent == nil {
+		return nil, consensus.ErrUnknownAncestor
+	}
+	return p.validators(chain, parent, nil)
+}
+
+// Finalize implements consensus.Engine, slashing the validators proven to have
+// equivocated. With a registry, checkpoints not committing to its registrations
+// are made to fail their state root check. No block rewards are given.
+func (p *PoS) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	entries := p.finalize(chain, header, state, uncles)
+	p.config.Ledger.Record(p, header, entries)
+}
+
+// finalize checks the checkpoint commitment, applies the slashings and commits
+// the final state root, returning the ledger entries of the slashings. They are
+// only recorded once the header is complete, as they are keyed by its seal hash.
+func (p *PoS) finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, uncles []*types.Header) []ledger.Entry {
+	var entries []ledger.Entry
+	if registry := p.config.Registry; registry != (common.Address{}) {
+		// Reject checkpoints not committing to the registrations
+		if header.Number.Uint64()%p.config.Epoch == 0 {
+			want, err := p.checkpointValidators(chain, header, state)
+			if err != nil || len(header.Extra) < extraVanity+extraSeal || !bytes.Equal(header.Extra[extraVanity:len(header.Extra)-extraSeal], want.encode()) {
+				misc.RejectBlock(state, header)
+			}
+		}
+		// Slash everyone caught signing conflicting blocks (verified in VerifyUncles)
+		for _, uncle := range uncles {
+			offender, err := ecrecover(uncle, p.signatures)
+			if err != nil {
+				continue
+			}
+			if stake := slash(state, registry, offender); stake.Sign() > 0 {
+				entries = append(entries, ledger.Entry{Account: offender, Kind: ledger.KindSlash, Amount: stake})
+			}
+		}
+	}
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	return entries
+}
+
+// FinalizeAndAssemble implements consensus.Engine, committing to the validator
+// set on checkpoint blocks and returns the final block.
+func (p *PoS) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	if header.Number.Uint64()%p.config.Epoch == 0 {
+		set, err := p.checkpointValidators(chain, header, state)
+		if err != nil {
+			return nil, err
+		}
+		extra := append(common.CopyBytes(header.Extra[:extraVanity]), set.encode()...)
+		header.Extra = append(extra, make([]byte, extraSeal)...)
+	}
+	// Finalize block
+	entries := p.finalize(chain, header, state, uncles)
+
+	// Assemble and return the final block for sealing
+	block := types.NewBlock(header, txs, uncles, receipts, trie.NewStackTrie(nil))
+	p.config.Ledger.Record(p, block.Header(), entries)
+	return block, nil
+}
+
+// Authorize injects a private key into the consensus engine to mint new blocks
+// with.
+func (p *PoS) Authorize(signer common.Address, signFn SignerFn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.signer = signer
+	p.signFn = signFn
+}
+
+// Seal implements consensus.Engine, attempting to create a sealed block using
+// the local signing credentials.
+func (p *PoS) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	// Don't hold the signer fields for the entire sealing procedure
+	p.lock.RLock()
+	signer, signFn := p.signer, p.signFn
+	p.lock.RUnlock()
+
+	// Bail out if we're not a validator
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	set, err := p.validators(chain, parent, nil)
+	if err != nil {
+		return err
+	}
+	if set.stake(signer) == nil {
+		return errUnauthorizedValidator
+	}
+	// Sign the header and wait for the slot to begin
+	sighash, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeClique, clique.CliqueRLP(header))
+	if err != nil {
+		return err
+	}
+	copy(header.Extra[len(header.Extra)-extraSeal:], sighash)
+
+	delay := time.Until(time.Unix(int64(header.Time), 0))
+	log.Trace("Waiting for slot to sign and propagate", "delay", common.PrettyDuration(delay))
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		select {
+		case results <- block.WithSeal(header):
+		default:
+			log.Warn("Sealing result is not read by miner", "sealhash", clique.SealHash(header))
+		}
+	}()
+	return nil
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// that a new block should have:
+// * DIFF_PROPOSER(2) if the local signer is the selected proposer
+// * DIFF_BACKUP(1) otherwise
+func (p *PoS) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	set, err := p.validators(chain, parent, nil)
+	if err != nil {
+		return nil
+	}
+	p.lock.RLock()
+	signer := p.signer
+	p.lock.RUnlock()
+
+	return calcDifficulty(set, parent.Hash(), signer)
+}
+
+func calcDifficulty(set ValidatorSet, parent common.Hash, signer common.Address) *big.Int {
+	if set.proposer(parent) == signer {
+		return new(big.Int).Set(diffProposer)
+	}
+	return new(big.Int).Set(diffBackup)
+}
+
+// Finalized implements consensus.Finalizer. Every block is taken as an
+// attestation of all its ancestors by its proposer, so the newest block whose
+// descendants were proposed by validators holding more than two thirds of the
+// stake is final. Only the last epoch worth of blocks is considered.
+func (p *PoS) Finalized(chain consensus.ChainHeaderReader) *types.Header {
+	head := chain.CurrentHeader()
+	if head == nil {
+		return nil
+	}
+	set, err := p.validators(chain, head, nil)
+	if err != nil {
+		return nil
+	}
+	var (
+		threshold = new(big.Int).Mul(set.total(), big.NewInt(2))
+		attested  = new(big.Int)
+		seen      = make(map[common.Address]bool)
+	)
+	for header := head; header != nil; {
+		if new(big.Int).Mul(attested, big.NewInt(3)).Cmp(threshold) > 0 {
+			return header
+		}
+		number := header.Number.Uint64()
+		if number == 0 {
+			return header // Genesis is always final
+		}
+		if head.Number.Uint64()-number >= p.config.Epoch {
+			return nil
+		}
+		if signer, err := ecrecover(header, p.signatures); err == nil && !seen[signer] {
+			if stake := set.stake(signer); stake != nil {
+				attested.Add(attested, stake)
+			}
+			seen[signer] = true
+		}
+		header = chain.GetHeader(header.ParentHash, number-1)
+	}
+	return nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (p *PoS) SealHash(header *types.Header) common.Hash {
+	return clique.SealHash(header)
+}
+
+// Close implements consensus.Engine. It's a noop as there are no background threads.
+func (p *PoS) Close() error {
+	return nil
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs. The
+// engine only exposes its ledger, if one is configured.
+func (p *PoS) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return p.config.Ledger.APIs(chain, p)
+}
@@ -0,0 +1,215 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pos
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/clique"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// Tests that validator sets survive an encoding round trip, and that malformed
+// lists are rejected.
+func TestValidatorEncoding(t *testing.T) {
+	set := newValidatorSet([]Validator{
+		{Address: common.Address{0x02}, Stake: big.NewInt(20)},
+		{Address: common.Address{0x01}, Stake: big.NewInt(10)},
+		{Address: common.Address{0x03}, Stake: big.NewInt(0)},
+		{Address: common.Address{0x01}, Stake: big.NewInt(30)},
+	})
+	if len(set) != 2 || set[0].Address != (common.Address{0x01}) || set[0].Stake.Int64() != 10 {
+		t.Fatalf("set not normalized: %v", set)
+	}
+	decoded, err := decodeValidators(set.encode())
+	if err != nil {
+		t.Fatalf("failed to decode validators: %v", err)
+	}
+	if !bytes.Equal(decoded.encode(), set.encode()) {
+		t.Errorf("round trip mismatch: have %x, want %x", decoded.encode(), set.encode())
+	}
+	unsorted := append(set[1:].encode(), set[:1].encode()...)
+	for i, data := range [][]byte{nil, set.encode()[1:], unsorted, make([]byte, validatorLength)} {
+		if _, err := decodeValidators(data); err != errInvalidValidators {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, errInvalidValidators)
+		}
+	}
+}
+
+// Tests that proposers are selected proportionally to their stake.
+func TestProposerWeighting(t *testing.T) {
+	set := newValidatorSet([]Validator{
+		{Address: common.Address{0x01}, Stake: big.NewInt(3)},
+		{Address: common.Address{0x02}, Stake: big.NewInt(1)},
+	})
+	var picked int
+	for i := 0; i < 4000; i++ {
+		if set.proposer(common.BigToHash(big.NewInt(int64(i)))) == (common.Address{0x01}) {
+			picked++
+		}
+	}
+	if picked < 2800 || picked > 3200 {
+		t.Errorf("stake weighting off: picked %d of 4000, want about 3000", picked)
+	}
+}
+
+// Tests that only the selected proposer may seal right away, backups only after
+// an extra period, and outsiders never.
+func TestVerifySeal(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	validators := make([]Validator, 2)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		if i < len(validators) {
+			validators[i] = Validator{Address: crypto.PubkeyToAddress(keys[i].PublicKey), Stake: big.NewInt(1)}
+		}
+	}
+	set := newValidatorSet(validators)
+
+	extra := append(make([]byte, extraVanity), set.encode()...)
+	genesis := headerbuilder.New(
+		headerbuilder.WithTime(uint64(time.Now().Unix())-1000),
+		headerbuilder.WithExtra(append(extra, make([]byte, extraSeal)...)),
+	)
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+	engine := New(Config{Period: 5})
+
+	// Map the validators to their role for the first block
+	proposer, backup := keys[0], keys[1]
+	if set.proposer(genesis.Hash()) != crypto.PubkeyToAddress(proposer.PublicKey) {
+		proposer, backup = backup, proposer
+	}
+	tests := []struct {
+		key   *ecdsa.PrivateKey
+		delay uint64
+		diff  *big.Int
+		err   error
+	}{
+		{proposer, 5, diffProposer, nil},
+		{proposer, 5, diffBackup, errWrongDifficulty},
+		{proposer, 4, diffProposer, errInvalidTimestamp},
+		{backup, 5, diffBackup, errEarlyBackup},
+		{backup, 10, diffBackup, nil},
+		{backup, 10, diffProposer, errWrongDifficulty},
+		{keys[2], 10, diffBackup, errUnauthorizedValidator},
+	}
+	for i, tt := range tests {
+		header := headerbuilder.New(
+			headerbuilder.WithParent(genesis),
+			headerbuilder.WithTime(genesis.Time+tt.delay),
+			headerbuilder.WithDifficulty(tt.diff),
+			headerbuilder.SignedBy(tt.key, clique.SealHash),
+		)
+		if err := engine.VerifyHeader(chain, header, true); !errors.Is(err, tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// Tests that checkpoints must commit to the current validator set without a
+// registry, which is checked on the header, and to the registrations in their
+// state with one, which is checked in Finalize through the state root.
+func TestVerifyCheckpoint(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 2)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	var (
+		current    = newValidatorSet([]Validator{{Address: crypto.PubkeyToAddress(keys[0].PublicKey), Stake: big.NewInt(1)}})
+		registered = newValidatorSet([]Validator{{Address: crypto.PubkeyToAddress(keys[1].PublicKey), Stake: big.NewInt(5)}})
+		registry   = common.Address{0xaa}
+	)
+	// Create a genesis state registering the second validator
+	db := state.NewDatabase(rawdb.NewMemoryDatabase())
+	statedb, _ := state.New(common.Hash{}, db, nil)
+	statedb.SetState(registry, common.Hash{}, common.BigToHash(big.NewInt(1)))
+	statedb.SetState(registry, common.BigToHash(big.NewInt(1)), common.BytesToHash(registered[0].Address.Bytes()))
+	statedb.SetState(registry, registrySlot(registered[0].Address), common.BigToHash(registered[0].Stake))
+	root, _ := statedb.Commit(false)
+
+	extra := append(make([]byte, extraVanity), current.encode()...)
+	genesis := headerbuilder.New(
+		headerbuilder.WithTime(uint64(time.Now().Unix())-1000),
+		headerbuilder.WithExtra(append(extra, make([]byte, extraSeal)...)),
+	)
+	genesis.Root = root
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+
+	tests := []struct {
+		registry common.Address
+		commit   ValidatorSet
+		err      error // Header verification result
+		valid    bool  // Whether the state root survives Finalize
+	}{
+		{common.Address{}, current, nil, true},
+		{common.Address{}, registered, errMismatchingCheckpointValidators, true},
+		{registry, registered, nil, true},
+		{registry, current, nil, false},
+		{common.Address{0xbb}, current, nil, true}, // Nobody registered, the set is kept
+		{common.Address{0xbb}, registered, nil, false},
+	}
+	for i, tt := range tests {
+		engine := New(Config{Period: 5, Epoch: 1, Registry: tt.registry})
+
+		header := headerbuilder.New(
+			headerbuilder.WithParent(genesis),
+			headerbuilder.WithTime(genesis.Time+5),
+			headerbuilder.WithDifficulty(diffProposer),
+			headerbuilder.WithExtra(append(make([]byte, extraVanity), tt.commit.encode()...)),
+			headerbuilder.SignedBy(keys[0], clique.SealHash),
+		)
+		if err := engine.VerifyHeader(chain, header, true); !errors.Is(err, tt.err) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		// The block leaves the state untouched, so its root is the genesis one
+		header.Root = root
+		statedb, _ := state.New(root, db, nil)
+		engine.Finalize(chain, header, statedb, nil, nil)
+		if valid := header.Root == root; valid != tt.valid {
+			t.Errorf("test %d: state root validity mismatch: have %v, want %v", i, valid, tt.valid)
+		}
+	}
+	// Checkpoints assembled by the engine commit to the registrations
+	engine := New(Config{Period: 5, Epoch: 1, Registry: registry})
+	header := headerbuilder.New(headerbuilder.WithParent(genesis), headerbuilder.WithExtra(make([]byte, extraVanity)))
+	statedb, _ = state.New(root, db, nil)
+	block, err := engine.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to assemble checkpoint: %v", err)
+	}
+	if have := block.Extra()[extraVanity : len(block.Extra())-extraSeal]; !bytes.Equal(have, registered.encode()) {
+		t.Errorf("assembled validators mismatch: have %x, want %x", have, registered.encode())
+	}
+	if block.Root() != root {
+		t.Errorf("assembled root mismatch: have %x, want %x", block.Root(), root)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package pos
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// validatorLength is the size of a single validator entry in the extra-data
+	// of a checkpoint block: the address followed by the 32 byte stake.
+	validatorLength = common.AddressLength + common.HashLength
+
+	// maxValidators is the maximum number of validators read from the registry.
+	maxValidators = 256
+)
+
+// errInvalidValidators is returned if a validator list cannot be decoded.
+var errInvalidValidators = errors.New("invalid validator list")
+
+// Validator is a member of the validator set along with its stake.
+type Validator struct {
+	Address common.Address `json:"address"`
+	Stake   *big.Int       `json:"stake"`
+}
+
+// ValidatorSet is a list of validators with a positive stake, sorted by address.
+type ValidatorSet []Validator
+
+// newValidatorSet creates a validator set from an arbitrary list of validators,
+// dropping duplicates and the ones without stake.
+func newValidatorSet(validators []Validator) ValidatorSet {
+	seen := make(map[common.Address]bool)
+	set := make(ValidatorSet, 0, len(validators))
+	for _, v := range validators {
+		if v.Stake != nil && v.Stake.Sign() > 0 && !seen[v.Address] {
+			set = append(set, Validator{Address: v.Address, Stake: new(big.Int).Set(v.Stake)})
+			seen[v.Address] = true
+		}
+	}
+	sort.Slice(set, func(i, j int) bool {
+		return bytes.Compare(set[i].Address[:], set[j].Address[:]) < 0
+	})
+	return set
+}
+
+// decodeValidators parses the validator list embedded in a checkpoint header.
+func decodeValidators(data []byte) (ValidatorSet, error) {
+	if len(data) == 0 || len(data)%validatorLength != 0 {
+		return nil, errInvalidValidators
+	}
+	set := make(ValidatorSet, len(data)/validatorLength)
+	for i := range set {
+		entry := data[i*validatorLength:]
+		set[i] = Validator{
+			Address: common.BytesToAddress(entry[:common.AddressLength]),
+			Stake:   new(big.Int).SetBytes(entry[common.AddressLength:validatorLength]),
+		}
+		if set[i].Stake.Sign() == 0 {
+			return nil, errInvalidValidators
+		}
+		if i > 0 && bytes.Compare(set[i-1].Address[:], set[i].Address[:]) >= 0 {
+			return nil, errInvalidValidators
+		}
+	}
+	return set, nil
+}
+
+// encode serializes the validator set for embedding into a checkpoint header.
+func (s ValidatorSet) encode() []byte {
+	data := make([]byte, 0, len(s)*validatorLength)
+	for _, v := range s {
+		data = append(data, v.Address[:]...)
+		data = append(data, common.BigToHash(v.Stake).Bytes()...)
+	}
+	return data
+}
+
+// total returns the sum of all stakes in the set.
+func (s ValidatorSet) total() *big.Int {
+	total := new(big.Int)
+	for _, v := range s {
+		total.Add(total, v.Stake)
+	}
+	return total
+}
+
+// stake returns the stake of the given validator, or nil if it is not a member
+// of the set.
+func (s ValidatorSet) stake(address common.Address) *big.Int {
+	for _, v := range s {
+		if v.Address == address {
+			return v.Stake
+		}
+	}
+	return nil
+}
+
+// proposer selects the validator entitled to propose the child of the given
+// parent. The selection is stake weighted: the parent hash is hashed again into
+// a seed in [0, total stake) and the validator whose cumulative stake range
+// covers the seed is chosen.
+func (s ValidatorSet) proposer(parent common.Hash) common.Address {
+	total := s.total()
+	if total.Sign() == 0 {
+		return common.Address{}
+	}
+	seed := new(big.Int).SetBytes(crypto.Keccak256(parent[:]))
+	seed.Mod(seed, total)
+
+	cumulative := new(big.Int)
+	for _, v := range s {
+		if cumulative.Add(cumulative, v.Stake); seed.Cmp(cumulative) < 0 {
+			return v.Address
+		}
+	}
+	return common.Address{} // Unreachable
+}
+
+// The validator registry is an account whose storage holds the validators that
+// registered for the next epoch, typically managed by a deposit contract:
+//
+//	slot 0               number of registered validators n
+//	slot 1..n            address of the i-th validator
+//	slot keccak(address) stake of the validator
+//
+// registrySlot returns the storage slot holding the stake of a validator.
+func registrySlot(address common.Address) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(address[:], common.HashLength))
+}
+
+// readRegistry retrieves the validators registered in the given account.
+func readRegistry(state *state.StateDB, registry common.Address) ValidatorSet {
+	count := state.GetState(registry, common.Hash{}).Big()
+	if count.Cmp(big.NewInt(maxValidators)) > 0 {
+		count.SetInt64(maxValidators)
+	}
+	validators := make([]Validator, 0, count.Uint64())
+	for i := uint64(1); i <= count.Uint64(); i++ {
+		address := common.BytesToAddress(state.GetState(registry, common.BigToHash(new(big.Int).SetUint64(i))).Bytes())
+		validators = append(validators, Validator{
+			Address: address,
+			Stake:   state.GetState(registry, registrySlot(address)).Big(),
+		})
+	}
+	return newValidatorSet(validators)
+}
+
+// slash burns the entire stake of a validator in the registry, returning the
+// amount burnt.
+func slash(state *state.StateDB, registry common.Address, validator common.Address) *big.Int {
+	stake := state.GetState(registry, registrySlot(validator)).Big()
+	state.SetState(registry, registrySlot(validator), common.Hash{})
+	return stake
+}
@@ -0,0 +1,334 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package raft
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// messageCode identifies the kind of an election message.
+type messageCode uint8
+
+const (
+	msgRequestVote messageCode = iota // Candidate asks for votes in a term
+	msgVote                           // Member votes for a candidate
+	msgHeartbeat                      // Leader asserts its leadership
+)
+
+// role is the part a member plays in the current term.
+type role uint8
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+var (
+	// errStaleTerm is returned for messages of a past term.
+	errStaleTerm = errors.New("stale term")
+
+	// errUnknownMessage is returned for messages with an unknown code.
+	errUnknownMessage = errors.New("unknown message code")
+)
+
+// message is a signed election message exchanged between members.
+type message struct {
+	Code       uint8
+	Term       uint64
+	Candidate  common.Address // Candidate asking for or receiving the vote
+	HeadTerm   uint64         // Term of the candidate's chain head
+	HeadNumber uint64         // Number of the candidate's chain head
+	Signature  []byte         // Sender's signature over all the fields above
+}
+
+// signingData returns the payload the sender's signature is made over.
+func (m *message) signingData() []byte {
+	data, _ := rlp.EncodeToBytes([]interface{}{m.Code, m.Term, m.Candidate, m.HeadTerm, m.HeadNumber})
+	return data
+}
+
+// majority returns the number of votes needed to win an election.
+func majority(members int) int {
+	return members/2 + 1
+}
+
+// node is the Raft election state machine of the local member.
+type node struct {
+	engine  *Raft
+	chain   consensus.ChainHeaderReader
+	network Broadcaster
+	address common.Address   // Address of the local member
+	members []common.Address // Members of the cluster
+
+	term     uint64                    // Current term
+	role     role                      // Part played in the current term
+	votedFor common.Address            // Candidate voted for in the current term
+	leader   common.Address            // Leader of the current term, if known
+	votes    map[common.Address][]byte // Signatures of the votes received as a candidate
+
+	timer *time.Timer // Election timeout, or heartbeat interval of a leader
+	quit  chan struct{}
+
+	lock sync.Mutex
+}
+
+// newNode creates an election state machine starting as a follower in the term
+// of the chain head.
+func newNode(engine *Raft, chain consensus.ChainHeaderReader, network Broadcaster) (*node, error) {
+	extra, err := extractExtra(chain.CurrentHeader())
+	if err != nil {
+		return nil, err
+	}
+	n := &node{
+		engine:  engine,
+		chain:   chain,
+		network: network,
+		address: engine.signer,
+		members: extra.Members,
+		term:    extra.Term,
+		quit:    make(chan struct{}),
+	}
+	n.lock.Lock()
+	n.resetTimer()
+	n.lock.Unlock()
+	return n, nil
+}
+
+// stop terminates the election timer.
+func (n *node) stop() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	close(n.quit)
+	n.timer.Stop()
+}
+
+// currentTerm returns the current term of the local member.
+func (n *node) currentTerm() uint64 {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.syncHead()
+	return n.term
+}
+
+// currentLeader returns the leader of the current term, if known.
+func (n *node) currentLeader() common.Address {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	return n.leader
+}
+
+// leads reports whether the local member is the leader of the given term.
+func (n *node) leads(term uint64) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.syncHead()
+	return n.role == leader && n.term == term
+}
+
+// election returns the signatures of the votes that elected the local member in
+// the given term, ordered as the members, or nil if it doesn't lead the term.
+func (n *node) election(term uint64) [][]byte {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.role != leader || n.term != term {
+		return nil
+	}
+	var votes [][]byte
+	for _, member := range n.members {
+		if sig, ok := n.votes[member]; ok {
+			votes = append(votes, sig)
+		}
+	}
+	return votes
+}
+
+// head returns the term and number of the local chain head.
+func (n *node) head() (uint64, uint64) {
+	header := n.chain.CurrentHeader()
+	extra, err := extractExtra(header)
+	if err != nil {
+		return 0, header.Number.Uint64()
+	}
+	return extra.Term, header.Number.Uint64()
+}
+
+// syncHead catches up with the term of the chain head, in case the election of
+// its leader was missed.
+func (n *node) syncHead() {
+	if term, _ := n.head(); term > n.term {
+		n.stepDown(term)
+	}
+}
+
+// stepDown moves to a newer term as a follower.
+func (n *node) stepDown(term uint64) {
+	n.term, n.role, n.votedFor, n.leader = term, follower, common.Address{}, common.Address{}
+	n.resetTimer()
+}
+
+// resetTimer (re)arms the timer: the heartbeat interval for a leader, and a
+// randomized election timeout for everyone else.
+func (n *node) resetTimer() {
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+	timeout := n.engine.config.HeartbeatInterval
+	if n.role != leader {
+		timeout = n.engine.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.engine.config.ElectionTimeout)))
+	}
+	n.timer = time.AfterFunc(timeout, n.tick)
+}
+
+// tick sends a heartbeat if the local member leads, or stands for election
+// otherwise.
+func (n *node) tick() {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	select {
+	case <-n.quit:
+		return
+	default:
+	}
+	n.syncHead()
+	if n.role == leader {
+		n.broadcast(&message{Code: uint8(msgHeartbeat), Term: n.term})
+		n.resetTimer()
+		return
+	}
+	n.term, n.role, n.votedFor, n.leader = n.term+1, candidate, n.address, common.Address{}
+	log.Debug("Standing for Raft election", "term", n.term)
+
+	// Vote for ourselves, signing it like the others to prove the election
+	vote := &message{Code: uint8(msgVote), Term: n.term, Candidate: n.address}
+	sig, err := n.engine.sign(vote.signingData())
+	if err != nil {
+		log.Error("Failed to sign own vote", "err", err)
+		n.resetTimer()
+		return
+	}
+	n.votes = map[common.Address][]byte{n.address: sig}
+
+	headTerm, headNumber := n.head()
+	n.broadcast(&message{Code: uint8(msgRequestVote), Term: n.term, Candidate: n.address, HeadTerm: headTerm, HeadNumber: headNumber})
+	n.resetTimer()
+	n.countVotes()
+}
+
+// countVotes takes the lead if a majority voted for the local candidate.
+func (n *node) countVotes() {
+	if n.role != candidate || len(n.votes) < majority(len(n.members)) {
+		return
+	}
+	n.role, n.leader = leader, n.address
+	log.Info("Elected Raft leader", "term", n.term)
+
+	n.broadcast(&message{Code: uint8(msgHeartbeat), Term: n.term})
+	n.resetTimer()
+}
+
+// broadcast signs a message and sends it to the other members.
+func (n *node) broadcast(msg *message) {
+	sig, err := n.engine.sign(msg.signingData())
+	if err != nil {
+		log.Error("Failed to sign election message", "err", err)
+		return
+	}
+	msg.Signature = sig
+
+	payload, err := rlp.EncodeToBytes(msg)
+	if err != nil {
+		log.Error("Failed to encode election message", "err", err)
+		return
+	}
+	if err := n.network.Broadcast(payload); err != nil {
+		log.Warn("Failed to broadcast election message", "err", err)
+	}
+}
+
+// handle decodes, authenticates and processes a message of another member.
+func (n *node) handle(payload []byte) error {
+	msg := new(message)
+	if err := rlp.DecodeBytes(payload, msg); err != nil {
+		return err
+	}
+	sender, err := recoverSigner(msg.signingData(), msg.Signature)
+	if err != nil {
+		return err
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if !contains(n.members, sender) {
+		return errUnauthorizedMember
+	}
+	n.syncHead()
+	if msg.Term > n.term {
+		n.stepDown(msg.Term)
+	}
+	if msg.Term < n.term {
+		return errStaleTerm
+	}
+	switch messageCode(msg.Code) {
+	case msgRequestVote:
+		// Grant a single vote per term, and only to candidates that are at least
+		// as up to date as ourselves
+		if n.votedFor != (common.Address{}) && n.votedFor != sender {
+			return nil
+		}
+		headTerm, headNumber := n.head()
+		if msg.HeadTerm < headTerm || (msg.HeadTerm == headTerm && msg.HeadNumber < headNumber) {
+			return nil
+		}
+		n.votedFor = sender
+		n.resetTimer()
+		n.broadcast(&message{Code: uint8(msgVote), Term: n.term, Candidate: sender})
+
+	case msgVote:
+		// Votes carry no head, so that their signatures can prove the election
+		if n.role == candidate && msg.Candidate == n.address && msg.HeadTerm == 0 && msg.HeadNumber == 0 {
+			n.votes[sender] = msg.Signature
+			n.countVotes()
+		}
+
+	case msgHeartbeat:
+		if n.role == leader {
+			log.Warn("Conflicting Raft leader", "term", n.term, "leader", sender)
+			return nil
+		}
+		n.role, n.leader = follower, sender
+		n.resetTimer()
+
+	default:
+		return errUnknownMessage
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package raft
+
+import "github.com/ethereum/go-ethereum/consensus"
+
+// Stable numeric codes of the raft specific errors. The codes are part of the RPC
+// interface: never renumber them, give new errors the next free code instead.
+const (
+	codeStaleTerm          = -39801
+	codeUnknownMessage     = -39802
+	codeUnknownBlock       = -39803
+	codeInvalidExtra       = -39804
+	codeInvalidNonce       = -39805
+	codeInvalidUncleHash   = -39806
+	codeInvalidDifficulty  = -39807
+	codeInvalidTimestamp   = -39808
+	codeMismatchingMembers = -39809
+	codeInvalidTerm        = -39810
+	codeMultipleLeaders    = -39811
+	codeUnauthorizedMember = -39812
+	codeMissingSigner      = -39813
+	codeNotStarted         = -39814
+	codeNotLeader          = -39815
+	codeInvalidVote        = -39816
+	codeMissingQuorum      = -39817
+)
+
+// Register stable numeric codes for the raft specific errors.
+func init() {
+	consensus.RegisterErrorCode(errStaleTerm, codeStaleTerm)
+	consensus.RegisterErrorCode(errUnknownMessage, codeUnknownMessage)
+	consensus.RegisterErrorCode(errUnknownBlock, codeUnknownBlock)
+	consensus.RegisterErrorCode(errInvalidExtra, codeInvalidExtra)
+	consensus.RegisterErrorCode(errInvalidNonce, codeInvalidNonce)
+	consensus.RegisterErrorCode(errInvalidUncleHash, codeInvalidUncleHash)
+	consensus.RegisterErrorCode(errInvalidDifficulty, codeInvalidDifficulty)
+	consensus.RegisterErrorCode(errInvalidTimestamp, codeInvalidTimestamp)
+	consensus.RegisterErrorCode(errMismatchingMembers, codeMismatchingMembers)
+	consensus.RegisterErrorCode(errInvalidTerm, codeInvalidTerm)
+	consensus.RegisterErrorCode(errMultipleLeaders, codeMultipleLeaders)
+	consensus.RegisterErrorCode(errUnauthorizedMember, codeUnauthorizedMember)
+	consensus.RegisterErrorCode(errMissingSigner, codeMissingSigner)
+	consensus.RegisterErrorCode(errNotStarted, codeNotStarted)
+	consensus.RegisterErrorCode(errNotLeader, codeNotLeader)
+	consensus.RegisterErrorCode(errInvalidVote, codeInvalidVote)
+	consensus.RegisterErrorCode(errMissingQuorum, codeMissingQuorum)
+}
@@ -0,0 +1,644 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package raft implements a leader based consensus engine for development
+// networks, where the leader elected through the Raft protocol seals blocks at
+// sub-second intervals.
+//
+// Members run Raft leader elections among themselves. The term of every block
+// is recorded in its header and only the leader elected for a term may seal
+// blocks in it, so terms never decrease along the chain and all the blocks of a
+// term are sealed by the same member. The first block of every term carries the
+// signed votes of the majority that elected its sealer, so the election can be
+// verified by anyone following the chain. The chain itself plays the role of the
+// replicated Raft log: a member only votes for candidates whose chain head is at
+// least as recent as its own.
+//
+// Blocks are not acknowledged by the other members before being sealed, so a
+// leader may lose its term with blocks nobody else has seen, and the next leader
+// may build on a shorter head. Fork choice then follows the term rather than the
+// length: the difficulty of the first block of a term outweighs any number of
+// blocks of earlier terms, so every member switches to the chain of the latest
+// term, dropping the unreplicated blocks. Blocks a later term builds on can't be
+// reverted anymore.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+
+	extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for member vanity
+
+	defaultPeriod            = 500 * time.Millisecond // Default minimum time between blocks
+	defaultElectionTimeout   = time.Second            // Default time without a leader before standing for election
+	defaultHeartbeatInterval = 200 * time.Millisecond // Default interval of the leader's heartbeats
+
+	// mimetypeRaft is the mime type of the data signed by members.
+	mimetypeRaft = "application/x-raft"
+)
+
+// Raft protocol constants.
+var (
+	defaultDifficulty = big.NewInt(1)                     // Difficulty of blocks continuing the term of their parent
+	termDifficulty    = new(big.Int).Lsh(common.Big1, 64) // Extra difficulty of every term started, outweighing any chain of earlier terms
+	nilUncleHash      = types.CalcUncleHash(nil)          // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
+)
+
+// Various error messages to mark blocks invalid. These should be private to
+// prevent engine specific errors from being referenced in the remainder of the
+// codebase, inherently breaking if the engine is swapped out. Please put common
+// error types into the consensus package.
+var (
+	// errUnknownBlock is returned when the list of members is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidExtra is returned if a header's extra-data cannot be decoded.
+	errInvalidExtra = errors.New("invalid raft extra-data")
+
+	// errInvalidNonce is returned if a block's nonce is non-zero.
+	errInvalidNonce = errors.New("non-zero nonce")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block doesn't match
+	// the number of terms started since its parent.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errMismatchingMembers is returned if a block's member list differs from
+	// its parent's.
+	errMismatchingMembers = errors.New("mismatching member list")
+
+	// errInvalidTerm is returned if a block's term is lower than its parent's, or
+	// if the first block isn't in a newer term than the genesis.
+	errInvalidTerm = errors.New("invalid term")
+
+	// errInvalidVote is returned if a block carries an election vote that is not
+	// signed by a member for the block's sealer and term, a duplicate vote, or
+	// votes at all without starting a new term.
+	errInvalidVote = errors.New("invalid election vote")
+
+	// errMissingQuorum is returned if the first block of a term doesn't carry
+	// the votes of a majority of the members.
+	errMissingQuorum = errors.New("election without quorum")
+
+	// errMultipleLeaders is returned if a block is sealed by a different member
+	// than its parent of the same term.
+	errMultipleLeaders = errors.New("multiple leaders in term")
+
+	// errUnauthorizedMember is returned if a block or message is signed by an
+	// account outside of the cluster.
+	errUnauthorizedMember = errors.New("unauthorized member")
+
+	// errMissingSigner is returned if the local node is asked to take part in the
+	// consensus without a signing key.
+	errMissingSigner = errors.New("no signer authorized")
+
+	// errNotStarted is returned if the engine is asked to seal or handle messages
+	// before being connected to the network.
+	errNotStarted = errors.New("consensus engine not started")
+
+	// errNotLeader is returned if the local member is asked to seal a block of a
+	// term it doesn't lead.
+	errNotLeader = errors.New("not the leader of the term")
+)
+
+// Config are the configuration parameters of the Raft engine.
+type Config struct {
+	Period            time.Duration // Minimum time between blocks, may be below a second
+	ElectionTimeout   time.Duration // Time without a leader before standing for election, randomized up to twice this
+	HeartbeatInterval time.Duration // Interval of the leader's heartbeats, well below the election timeout
+}
+
+// Broadcaster delivers consensus messages to all other members. Delivery must be
+// asynchronous, as the engine broadcasts while processing messages itself.
+type Broadcaster interface {
+	Broadcast(payload []byte) error
+}
+
+// SignerFn hashes and signs the data to be signed by a backing account.
+type SignerFn func(signer accounts.Account, mimeType string, message []byte) ([]byte, error)
+
+// raftExtra is the consensus data stored in the extra-data of every header,
+// after the vanity prefix.
+type raftExtra struct {
+	Members []common.Address // Cluster members, fixed at genesis
+	Term    uint64           // Raft term the block was sealed in
+	Seal    []byte           // Leader's signature over the seal hash
+	Votes   [][]byte         `rlp:"optional"` // Votes electing the leader, only in the first block of a term
+}
+
+// extractExtra decodes the raft specific part of a header's extra-data.
+func extractExtra(header *types.Header) (*raftExtra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errInvalidExtra
+	}
+	extra := new(raftExtra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, errInvalidExtra
+	}
+	return extra, nil
+}
+
+// writeExtra replaces the raft specific part of a header's extra-data, keeping
+// (and padding if needed) the vanity.
+func writeExtra(header *types.Header, extra *raftExtra) error {
+	payload, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return err
+	}
+	vanity := make([]byte, extraVanity)
+	copy(vanity, header.Extra)
+	header.Extra = append(vanity, payload...)
+	return nil
+}
+
+// SealHash returns the hash of a header prior to it being sealed, which is the
+// hash of the header with the leader's seal stripped.
+func SealHash(header *types.Header) common.Hash {
+	filtered := types.CopyHeader(header)
+	if extra, err := extractExtra(filtered); err == nil {
+		extra.Seal = nil
+		writeExtra(filtered, extra)
+	}
+	return filtered.Hash()
+}
+
+// recoverSigner extracts the address that produced a signature over the hash of
+// the given data.
+func recoverSigner(data []byte, sig []byte) (common.Address, error) {
+	pubkey, err := crypto.SigToPub(crypto.Keccak256(data), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// contains reports whether the address is among the members.
+func contains(members []common.Address, address common.Address) bool {
+	for _, member := range members {
+		if member == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Raft is the leader based consensus engine of development networks.
+type Raft struct {
+	config     Config
+	signatures *lru.ARCCache // Leaders of recent blocks to speed up author lookups
+
+	signer common.Address // Ethereum address of the signing key
+	signFn SignerFn       // Signer function to authorize hashes with
+	lock   sync.RWMutex   // Protects the signer and node fields
+
+	node *node // Election state machine, running once started
+}
+
+// New creates a Raft consensus engine. The cluster members are taken from the
+// extra-data of the genesis block.
+func New(config Config) *Raft {
+	if config.Period == 0 {
+		config.Period = defaultPeriod
+	}
+	if config.ElectionTimeout == 0 {
+		config.ElectionTimeout = defaultElectionTimeout
+	}
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	return &Raft{
+		config:     config,
+		signatures: signatures,
+	}
+}
+
+// Author implements consensus.Engine, returning the address of the leader that
+// sealed the block.
+func (r *Raft) Author(header *types.Header) (common.Address, error) {
+	hash := header.Hash()
+	if address, known := r.signatures.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	signer, err := recoverSigner(SealHash(header).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	r.signatures.Add(hash, signer)
+	return signer, nil
+}
+
+// VerifyHeader checks whether a header conforms to the consensus rules.
+func (r *Raft) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return r.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// method returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (r *Raft) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	return consensus.VerifyHeadersSequential(headers, func(i int) error {
+		return r.verifyHeader(chain, headers[i], headers[:i])
+	})
+}
+
+// verifyHeader checks whether a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (r *Raft) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	// Ensure the fields meaningless for leader based consensus are left empty
+	if header.Nonce != (types.BlockNonce{}) {
+		return errInvalidNonce
+	}
+	if header.UncleHash != nilUncleHash {
+		return errInvalidUncleHash
+	}
+	number := header.Number.Uint64()
+	// Verify that the gas limit is <= 2^63-1
+	if header.GasLimit > params.MaxGasLimit {
+		return fmt.Errorf("invalid gasLimit: have %v, max %v", header.GasLimit, params.MaxGasLimit)
+	}
+	// The genesis block is the always valid dead-end
+	if number == 0 {
+		return nil
+	}
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	// Blocks come faster than a second, so timestamps may repeat but not go back
+	if header.Time < parent.Time {
+		return errInvalidTimestamp
+	}
+	// Verify that the gasUsed is <= gasLimit
+	if header.GasUsed > header.GasLimit {
+		return fmt.Errorf("invalid gasUsed: have %d, gasLimit %d", header.GasUsed, header.GasLimit)
+	}
+	if !chain.Config().IsLondon(header.Number) {
+		// Verify BaseFee not present before EIP-1559 fork.
+		if header.BaseFee != nil {
+			return fmt.Errorf("invalid baseFee before fork: have %d, want <nil>", header.BaseFee)
+		}
+		if err := misc.VerifyGaslimit(parent.GasLimit, header.GasLimit); err != nil {
+			return err
+		}
+	} else if err := misc.VerifyEip1559Header(chain.Config(), parent, header); err != nil {
+		// Verify the header's EIP-1559 attributes.
+		return err
+	}
+	// The cluster is static, so it must be carried over unchanged
+	parentExtra, err := extractExtra(parent)
+	if err != nil {
+		return err
+	}
+	if len(parentExtra.Members) != len(extra.Members) {
+		return errMismatchingMembers
+	}
+	for i, member := range parentExtra.Members {
+		if extra.Members[i] != member {
+			return errMismatchingMembers
+		}
+	}
+	// Nobody is elected in the genesis term, so the first block must start a new one
+	if extra.Term < parentExtra.Term || (extra.Term == parentExtra.Term && number == 1) {
+		return errInvalidTerm
+	}
+	if header.Difficulty == nil || header.Difficulty.Cmp(calcDifficulty(parentExtra.Term, extra.Term)) != 0 {
+		return errInvalidDifficulty
+	}
+	// Ensure the block is sealed by a member, the same one for a whole term
+	signer, err := r.Author(header)
+	if err != nil {
+		return err
+	}
+	if !contains(extra.Members, signer) {
+		return errUnauthorizedMember
+	}
+	if extra.Term > parentExtra.Term {
+		return verifyElection(extra, signer)
+	}
+	if len(extra.Votes) > 0 {
+		return errInvalidVote
+	}
+	leader, err := r.Author(parent)
+	if err != nil {
+		return err
+	}
+	if leader != signer {
+		return errMultipleLeaders
+	}
+	return nil
+}
+
+// verifyElection checks that the votes carried by the first block of a term were
+// cast for its sealer in that term by a majority of the members.
+func verifyElection(extra *raftExtra, leader common.Address) error {
+	vote := &message{Code: uint8(msgVote), Term: extra.Term, Candidate: leader}
+	data := vote.signingData()
+
+	voters := make(map[common.Address]bool)
+	for _, sig := range extra.Votes {
+		voter, err := recoverSigner(data, sig)
+		if err != nil || !contains(extra.Members, voter) || voters[voter] {
+			return errInvalidVote
+		}
+		voters[voter] = true
+	}
+	if len(voters) < majority(len(extra.Members)) {
+		return errMissingQuorum
+	}
+	return nil
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (r *Raft) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (r *Raft) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	header.Nonce = types.BlockNonce{}
+	header.MixDigest = common.Hash{}
+
+	number := header.Number.Uint64()
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	parentExtra, err := extractExtra(parent)
+	if err != nil {
+		return err
+	}
+	header.Time = uint64(time.Now().Unix())
+	if header.Time < parent.Time {
+		header.Time = parent.Time
+	}
+	// Stamp the block with the local term, sealing will fail if we don't lead it
+	r.lock.RLock()
+	node := r.node
+	r.lock.RUnlock()
+
+	// The first block of a term carries the votes electing its leader
+	var (
+		term  = parentExtra.Term
+		votes [][]byte
+	)
+	if node != nil {
+		if current := node.currentTerm(); current > term {
+			term, votes = current, node.election(current)
+		}
+	}
+	header.Difficulty = calcDifficulty(parentExtra.Term, term)
+	return writeExtra(header, &raftExtra{Members: parentExtra.Members, Term: term, Votes: votes})
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given.
+func (r *Raft) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	// No block rewards on development networks, so the state remains as is and uncles are dropped
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = nilUncleHash
+}
+
+// FinalizeAndAssemble implements consensus.Engine, ensuring no uncles are set,
+// nor block rewards given, and returns the final block.
+func (r *Raft) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	// Finalize block
+	r.Finalize(chain, header, state, txs, uncles)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts, trie.NewStackTrie(nil)), nil
+}
+
+// Authorize injects a private key into the consensus engine to take part in the
+// elections and seal blocks with.
+func (r *Raft) Authorize(signer common.Address, signFn SignerFn) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.signer = signer
+	r.signFn = signFn
+}
+
+// sign signs the given data with the local member key.
+func (r *Raft) sign(data []byte) ([]byte, error) {
+	r.lock.RLock()
+	signer, signFn := r.signer, r.signFn
+	r.lock.RUnlock()
+
+	if signFn == nil {
+		return nil, errMissingSigner
+	}
+	return signFn(accounts.Account{Address: signer}, mimetypeRaft, data)
+}
+
+// Start connects the engine to the other members, after which it takes part in
+// the leader elections. Incoming consensus messages must be passed to
+// HandleMessage.
+func (r *Raft) Start(chain consensus.ChainHeaderReader, network Broadcaster) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.signFn == nil {
+		return errMissingSigner
+	}
+	if r.node == nil {
+		node, err := newNode(r, chain, network)
+		if err != nil {
+			return err
+		}
+		r.node = node
+	}
+	return nil
+}
+
+// Stop disconnects the engine from the other members.
+func (r *Raft) Stop() {
+	r.lock.Lock()
+	node := r.node
+	r.node = nil
+	r.lock.Unlock()
+
+	// The node signs under its own lock, so stop it without holding ours
+	if node != nil {
+		node.stop()
+	}
+}
+
+// Leader returns the leader of the current term known to the local member, or
+// the zero address if there is none.
+func (r *Raft) Leader() common.Address {
+	r.lock.RLock()
+	node := r.node
+	r.lock.RUnlock()
+
+	if node == nil {
+		return common.Address{}
+	}
+	return node.currentLeader()
+}
+
+// HandleMessage processes a consensus message received from another member.
+func (r *Raft) HandleMessage(payload []byte) error {
+	r.lock.RLock()
+	node := r.node
+	r.lock.RUnlock()
+
+	if node == nil {
+		return errNotStarted
+	}
+	return node.handle(payload)
+}
+
+// Seal implements consensus.Engine, attempting to create a sealed block using
+// the local signing credentials. Only the leader of the block's term may seal,
+// and not sooner than the configured period.
+func (r *Raft) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	r.lock.RLock()
+	node := r.node
+	r.lock.RUnlock()
+
+	if node == nil {
+		return errNotStarted
+	}
+	header := block.Header()
+	extra, err := extractExtra(header)
+	if err != nil {
+		return err
+	}
+	if !node.leads(extra.Term) {
+		return errNotLeader
+	}
+	if extra.Seal, err = r.sign(SealHash(header).Bytes()); err != nil {
+		return err
+	}
+	if err := writeExtra(header, extra); err != nil {
+		return err
+	}
+	// Wait until sealing is terminated or the period elapses
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(r.config.Period):
+		}
+
+		select {
+		case results <- block.WithSeal(header):
+		default:
+			log.Warn("Sealing result is not read by miner", "sealhash", SealHash(header))
+		}
+	}()
+	return nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (r *Raft) SealHash(header *types.Header) common.Hash {
+	return SealHash(header)
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
+// that a new block sealed locally on top of parent should have, depending on
+// whether it starts a new term.
+func (r *Raft) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	extra, err := extractExtra(parent)
+	if err != nil {
+		return new(big.Int).Set(defaultDifficulty)
+	}
+	r.lock.RLock()
+	node := r.node
+	r.lock.RUnlock()
+
+	term := extra.Term
+	if node != nil && node.currentTerm() > term {
+		term = node.currentTerm()
+	}
+	return calcDifficulty(extra.Term, term)
+}
+
+// calcDifficulty returns the difficulty of a block in the given term, on top of
+// a parent in parentTerm. Total difficulties thus grow by termDifficulty with
+// every term and by one with every block, so the chain of the latest term is
+// always the heaviest, and the longest one among those of the same term.
+func calcDifficulty(parentTerm, term uint64) *big.Int {
+	diff := new(big.Int).SetUint64(term - parentTerm)
+	diff.Mul(diff, termDifficulty)
+	return diff.Add(diff, defaultDifficulty)
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC APIs.
+func (r *Raft) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return nil
+}
+
+// Close implements consensus.Engine, stopping the elections if running.
+func (r *Raft) Close() error {
+	r.Stop()
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package raft
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/headerbuilder"
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestMain(m *testing.M) {
+	leakcheck.Main(m)
+}
+
+// testNetwork asynchronously delivers the messages of a member to all the
+// others.
+type testNetwork struct {
+	self    int
+	engines []*Raft
+}
+
+func (n *testNetwork) Broadcast(payload []byte) error {
+	for i, engine := range n.engines {
+		if i != n.self {
+			go engine.HandleMessage(payload)
+		}
+	}
+	return nil
+}
+
+// newTestCluster creates n member keys and a genesis header listing them.
+func newTestCluster(n int) ([]*ecdsa.PrivateKey, *types.Header) {
+	keys := make([]*ecdsa.PrivateKey, n)
+	members := make([]common.Address, n)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		members[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	genesis := headerbuilder.New(headerbuilder.WithTime(uint64(time.Now().Unix()) - 10))
+	writeExtra(genesis, &raftExtra{Members: members})
+	return keys, genesis
+}
+
+// newTestHeader creates a header on top of parent in the given term, sealed by
+// the given key and carrying the votes of the given voters for it.
+func newTestHeader(parent *types.Header, term uint64, key *ecdsa.PrivateKey, voters ...*ecdsa.PrivateKey) *types.Header {
+	extra, _ := extractExtra(parent)
+	header := headerbuilder.New(
+		headerbuilder.WithParent(parent),
+		headerbuilder.WithTime(parent.Time),
+		headerbuilder.WithDifficulty(calcDifficulty(extra.Term, term)),
+	)
+	extra.Term, extra.Seal, extra.Votes = term, nil, nil
+
+	vote := &message{Code: uint8(msgVote), Term: term, Candidate: crypto.PubkeyToAddress(key.PublicKey)}
+	for _, voter := range voters {
+		sig, _ := crypto.Sign(crypto.Keccak256(vote.signingData()), voter)
+		extra.Votes = append(extra.Votes, sig)
+	}
+	writeExtra(header, extra)
+
+	extra.Seal, _ = crypto.Sign(crypto.Keccak256(SealHash(header).Bytes()), key)
+	writeExtra(header, extra)
+	return header
+}
+
+// Tests that terms never decrease along the chain, that all the blocks of a term
+// are sealed by the same member, and that the first block of a term proves the
+// election of its sealer.
+func TestVerifyTerms(t *testing.T) {
+	keys, genesis := newTestCluster(3)
+	outsider, _ := crypto.GenerateKey()
+
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+	engine := New(Config{})
+
+	if err := engine.VerifyHeader(chain, newTestHeader(genesis, 0, keys[0], keys[0], keys[1]), true); err != errInvalidTerm {
+		t.Errorf("genesis term block: error mismatch: have %v, want %v", err, errInvalidTerm)
+	}
+	parent := newTestHeader(genesis, 2, keys[0], keys[0], keys[1])
+	if err := engine.VerifyHeader(chain, parent, true); err != nil {
+		t.Fatalf("failed to verify first block: %v", err)
+	}
+	tests := []struct {
+		term   uint64
+		key    *ecdsa.PrivateKey
+		voters []*ecdsa.PrivateKey
+		err    error
+	}{
+		{2, keys[0], nil, nil},
+		{2, keys[1], nil, errMultipleLeaders},
+		{2, keys[0], []*ecdsa.PrivateKey{keys[0], keys[1]}, errInvalidVote}, // Votes without a new term
+		{1, keys[0], nil, errInvalidTerm},
+		{3, keys[1], []*ecdsa.PrivateKey{keys[1], keys[2]}, nil},
+		{3, keys[1], []*ecdsa.PrivateKey{keys[0], keys[1], keys[2]}, nil},
+		{3, keys[1], nil, errMissingQuorum},
+		{3, keys[1], []*ecdsa.PrivateKey{keys[1]}, errMissingQuorum},
+		{3, keys[1], []*ecdsa.PrivateKey{keys[1], keys[1]}, errInvalidVote}, // Duplicate vote
+		{3, keys[1], []*ecdsa.PrivateKey{keys[1], outsider}, errInvalidVote},
+		{3, outsider, []*ecdsa.PrivateKey{keys[1], keys[2]}, errUnauthorizedMember},
+	}
+	for i, tt := range tests {
+		header := newTestHeader(parent, tt.term, tt.key, tt.voters...)
+		if err := engine.verifyHeader(chain, header, []*types.Header{parent}); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	// The difficulty must account for the terms started
+	header := newTestHeader(parent, 2, keys[0])
+	header.Difficulty = calcDifficulty(1, 2)
+	extra, _ := extractExtra(header)
+	extra.Seal, _ = crypto.Sign(crypto.Keccak256(SealHash(header).Bytes()), keys[0])
+	writeExtra(header, extra)
+	if err := engine.verifyHeader(chain, header, []*types.Header{parent}); err != errInvalidDifficulty {
+		t.Errorf("difficulty: error mismatch: have %v, want %v", err, errInvalidDifficulty)
+	}
+	// Votes cast in another term or for another member don't prove the election
+	extra, _ = extractExtra(newTestHeader(parent, 3, keys[1], keys[1]))
+	for i, vote := range []*message{
+		{Code: uint8(msgVote), Term: 4, Candidate: crypto.PubkeyToAddress(keys[1].PublicKey)},
+		{Code: uint8(msgVote), Term: 3, Candidate: crypto.PubkeyToAddress(keys[0].PublicKey)},
+	} {
+		sig, _ := crypto.Sign(crypto.Keccak256(vote.signingData()), keys[2])
+		foreign := &raftExtra{Members: extra.Members, Term: 3, Votes: [][]byte{extra.Votes[0], sig}}
+		if err := verifyElection(foreign, crypto.PubkeyToAddress(keys[1].PublicKey)); err != errInvalidVote {
+			t.Errorf("foreign vote %d: error mismatch: have %v, want %v", i, err, errInvalidVote)
+		}
+	}
+}
+
+// Tests that fork choice follows the term: a new leader building on a shorter
+// head wins over the longer chain of the previous term.
+func TestTermForkChoice(t *testing.T) {
+	keys, genesis := newTestCluster(3)
+	first := newTestHeader(genesis, 1, keys[0], keys[0], keys[1])
+
+	// The leader of term 1 seals a long chain nobody else sees
+	unreplicated := []*types.Header{first}
+	for i := 0; i < 10; i++ {
+		unreplicated = append(unreplicated, newTestHeader(unreplicated[len(unreplicated)-1], 1, keys[0]))
+	}
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis, first)
+	chain.Insert(unreplicated[1:]...)
+
+	// The others elect a new leader on top of the first block
+	next := newTestHeader(first, 2, keys[1], keys[1], keys[2])
+	if err := New(Config{}).VerifyHeader(chain, next, true); err != nil {
+		t.Fatalf("failed to verify new term: %v", err)
+	}
+	chain.Insert(next)
+	if head := chain.CurrentHeader(); head.Hash() != next.Hash() {
+		t.Errorf("head mismatch: have #%d, want #%d of the new term", head.Number, next.Number)
+	}
+	// Within a term, the longer chain wins
+	chain.Insert(newTestHeader(next, 2, keys[1]))
+	if head := chain.CurrentHeader(); head.Number.Uint64() != 3 {
+		t.Errorf("head mismatch: have #%d, want #3", head.Number)
+	}
+}
+
+// Tests that a cluster elects a single leader, which is the only member able to
+// seal blocks.
+func TestElection(t *testing.T) {
+	leakcheck.Check(t)
+
+	keys, genesis := newTestCluster(3)
+	chain := headerbuilder.NewMemoryChain(&params.ChainConfig{ChainID: big.NewInt(1)}, genesis)
+
+	engines := make([]*Raft, len(keys))
+	for i, key := range keys {
+		key := key
+		engines[i] = New(Config{Period: time.Millisecond, ElectionTimeout: 50 * time.Millisecond, HeartbeatInterval: 10 * time.Millisecond})
+		engines[i].Authorize(crypto.PubkeyToAddress(key.PublicKey), func(signer accounts.Account, mimeType string, message []byte) ([]byte, error) {
+			return crypto.Sign(crypto.Keccak256(message), key)
+		})
+	}
+	for i, engine := range engines {
+		if err := engine.Start(chain, &testNetwork{self: i, engines: engines}); err != nil {
+			t.Fatalf("member %d: failed to start: %v", i, err)
+		}
+		defer engine.Stop()
+	}
+	// Wait until all members agree on a leader
+	var leader int
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if time.Now().After(deadline) {
+			t.Fatalf("no leader elected")
+		}
+		leader = -1
+		for i, key := range keys {
+			if engines[0].Leader() == crypto.PubkeyToAddress(key.PublicKey) {
+				leader = i
+			}
+		}
+		if leader >= 0 && engines[1].Leader() == engines[0].Leader() && engines[2].Leader() == engines[0].Leader() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Only the leader may seal blocks of its term
+	for i, engine := range engines {
+		header := headerbuilder.New(headerbuilder.WithParent(genesis))
+		if err := engine.Prepare(chain, header); err != nil {
+			t.Fatalf("member %d: failed to prepare header: %v", i, err)
+		}
+		results := make(chan *types.Block, 1)
+		err := engine.Seal(chain, types.NewBlockWithHeader(header), results, nil)
+		if i != leader {
+			if err != errNotLeader {
+				t.Errorf("member %d: error mismatch: have %v, want %v", i, err, errNotLeader)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("leader failed to seal: %v", err)
+		}
+		select {
+		case block := <-results:
+			if err := engine.VerifyHeader(chain, block.Header(), true); err != nil {
+				t.Errorf("sealed block invalid: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("leader didn't seal the block")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Factory creates a consensus engine from its JSON encoded configuration, as
+// found in the genesis or config file of a node. An empty configuration selects
+// the engine's defaults. The chain database is passed in for engines persisting
+// data of their own, such as signer snapshots.
+type Factory func(db ethdb.Database, config json.RawMessage) (Engine, error)
+
+// registry maps engine names to their factories.
+type registry struct {
+	factories map[string]Factory
+	lock      sync.RWMutex
+}
+
+// engines is the registry of the consensus engines available to nodes. The
+// engines of this tree are registered by the consensus/engines package.
+var engines = newRegistry()
+
+// newRegistry creates an empty engine registry.
+func newRegistry() *registry {
+	return &registry{factories: make(map[string]Factory)}
+}
+
+// Register makes a consensus engine available by name, for nodes to select it
+// at runtime. It is called from init. Registering a name twice or a nil factory
+// panics.
+func Register(name string, factory Factory) {
+	engines.register(name, factory)
+}
+
+// New creates the consensus engine registered under the given name from its
+// JSON encoded configuration. The package registering the engine must be
+// imported for it to be available.
+func New(name string, db ethdb.Database, config json.RawMessage) (Engine, error) {
+	return engines.new(name, db, config)
+}
+
+// Engines returns the names of all the registered consensus engines, sorted.
+func Engines() []string {
+	return engines.names()
+}
+
+// register implements Register on the registry.
+func (r *registry) register(name string, factory Factory) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if factory == nil {
+		panic(fmt.Sprintf("nil factory for consensus engine %q", name))
+	}
+	if _, ok := r.factories[name]; ok {
+		panic(fmt.Sprintf("duplicate consensus engine %q", name))
+	}
+	r.factories[name] = factory
+}
+
+// new implements New on the registry.
+func (r *registry) new(name string, db ethdb.Database, config json.RawMessage) (Engine, error) {
+	r.lock.RLock()
+	factory, ok := r.factories[name]
+	r.lock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownEngine, name)
+	}
+	if len(config) == 0 || string(config) == "null" {
+		config = json.RawMessage("{}")
+	}
+	engine, err := factory(db, config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s config: %w", name, err)
+	}
+	return engine, nil
+}
+
+// names implements Engines on the registry.
+func (r *registry) names() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,70 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// Tests that registered engines are created by name with their configuration,
+// and that unknown names and duplicate registrations are rejected.
+func TestRegistry(t *testing.T) {
+	var (
+		registry         = newRegistry()
+		errInvalidConfig = errors.New("invalid config")
+		configs          []string
+		db               = rawdb.NewMemoryDatabase()
+	)
+	registry.register("test", func(have ethdb.Database, config json.RawMessage) (Engine, error) {
+		if have != db {
+			t.Errorf("database not passed to factory")
+		}
+		configs = append(configs, string(config))
+		if string(config) == "{}" {
+			return nil, nil
+		}
+		return nil, errInvalidConfig
+	})
+	for i, config := range []json.RawMessage{nil, json.RawMessage("null"), json.RawMessage("{}")} {
+		if _, err := registry.new("test", db, config); err != nil {
+			t.Errorf("test %d: failed to create engine: %v", i, err)
+		}
+	}
+	if _, err := registry.new("test", db, json.RawMessage(`{"period":1}`)); !errors.Is(err, errInvalidConfig) {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidConfig)
+	}
+	if len(configs) != 4 || configs[0] != "{}" || configs[3] != `{"period":1}` {
+		t.Errorf("configs mismatch: have %q", configs)
+	}
+	if _, err := registry.new("missing", nil, nil); !errors.Is(err, ErrUnknownEngine) {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrUnknownEngine)
+	}
+	if names := registry.names(); len(names) != 1 || names[0] != "test" {
+		t.Errorf("registered engines mismatch: have %v, want [test]", names)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("duplicate registration accepted")
+		}
+	}()
+	registry.register("test", func(db ethdb.Database, config json.RawMessage) (Engine, error) { return nil, nil })
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"runtime"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// VerifyHeadersParallel runs the given verification function over a batch of
+// headers on GOMAXPROCS workers. Results are delivered in the order of the input
+// slice, regardless of the order in which the workers finish, so it can be used
+// as the body of an engine's VerifyHeaders. Closing the returned abort channel
+// stops the dispatch of further headers; results already computed may or may
+// not be delivered.
+//
+// The verify function is called with the index of the header to check and must
+// be safe for concurrent use. Headers of the batch may be verified before their
+// parents, so it should only depend on data that is not itself being verified.
+func VerifyHeadersParallel(headers []*types.Header, verify func(index int) error) (chan<- struct{}, <-chan error) {
+	return verifyHeaders(headers, runtime.GOMAXPROCS(0), verify)
+}
+
+// VerifyHeadersSequential is similar to VerifyHeadersParallel, but verifies the
+// headers one by one in the order of the input slice on a single background
+// thread. It suits engines whose verification of a header reuses what was
+// derived from its predecessors in the batch, such as signer snapshots.
+func VerifyHeadersSequential(headers []*types.Header, verify func(index int) error) (chan<- struct{}, <-chan error) {
+	return verifyHeaders(headers, 1, verify)
+}
+
+// verifyHeaders runs the given verification function over a batch of headers on
+// the given number of workers, delivering the results in order.
+func verifyHeaders(headers []*types.Header, workers int, verify func(index int) error) (chan<- struct{}, <-chan error) {
+	abort, results := make(chan struct{}), make(chan error, len(headers))
+	if len(headers) == 0 {
+		return abort, results
+	}
+	// Spawn as many workers as allowed, but no more than headers
+	if len(headers) < workers {
+		workers = len(headers)
+	}
+	var (
+		inputs = make(chan int)
+		done   = make(chan int, len(headers)) // Never blocks the workers, even after an abort
+		errs   = make([]error, len(headers))
+	)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for index := range inputs {
+				errs[index] = verify(index)
+				done <- index
+			}
+		}()
+	}
+	// Feed the workers and reorder their results
+	go func() {
+		defer close(inputs)
+		var (
+			in, out = 0, 0
+			checked = make([]bool, len(headers))
+			inputs  = inputs
+		)
+		for {
+			select {
+			case inputs <- in:
+				if in++; in == len(headers) {
+					// Reached end of headers. Stop sending to workers.
+					inputs = nil
+				}
+			case index := <-done:
+				for checked[index] = true; checked[out]; out++ {
+					results <- errs[out]
+					if out == len(headers)-1 {
+						return
+					}
+				}
+			case <-abort:
+				return
+			}
+		}
+	}()
+	return abort, results
+}
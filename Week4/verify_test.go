@@ -0,0 +1,119 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package consensus
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/leakcheck"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Tests that parallel verification delivers the results in input order, even
+// if the workers finish out of order.
+func TestVerifyHeadersParallelOrder(t *testing.T) {
+	leakcheck.Check(t)
+
+	headers := make([]*types.Header, 64)
+	for i := range headers {
+		headers[i] = &types.Header{Number: big.NewInt(int64(i))}
+	}
+	delays := make([]time.Duration, len(headers))
+	for i := range delays {
+		delays[i] = time.Duration(rand.Intn(1000)) * time.Microsecond
+	}
+	_, results := VerifyHeadersParallel(headers, func(index int) error {
+		time.Sleep(delays[index])
+		if index%3 == 0 {
+			return fmt.Errorf("header %d", index)
+		}
+		return nil
+	})
+	for i := range headers {
+		select {
+		case err := <-results:
+			if (i%3 == 0) != (err != nil) || (err != nil && err.Error() != fmt.Sprintf("header %d", i)) {
+				t.Fatalf("result %d mismatch: have %v", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("result %d timeout", i)
+		}
+	}
+}
+
+// Tests that aborting a parallel verification stops dispatching headers.
+func TestVerifyHeadersParallelAbort(t *testing.T) {
+	leakcheck.Check(t)
+
+	headers := make([]*types.Header, 1024)
+	for i := range headers {
+		headers[i] = &types.Header{Number: big.NewInt(int64(i))}
+	}
+	abort, results := VerifyHeadersParallel(headers, func(index int) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	close(abort)
+
+	// Give the dispatcher time to notice, then make sure it didn't keep going
+	time.Sleep(50 * time.Millisecond)
+	if n := len(results); n == len(headers) {
+		t.Errorf("verification not aborted: have %d results, want fewer than %d", n, len(headers))
+	}
+}
+
+// Tests that sequential verification checks the headers one by one in order.
+func TestVerifyHeadersSequential(t *testing.T) {
+	leakcheck.Check(t)
+
+	headers := make([]*types.Header, 64)
+	for i := range headers {
+		headers[i] = &types.Header{Number: big.NewInt(int64(i))}
+	}
+	var (
+		next    int
+		running int32
+	)
+	_, results := VerifyHeadersSequential(headers, func(index int) error {
+		if atomic.AddInt32(&running, 1) != 1 {
+			t.Errorf("header %d verified concurrently", index)
+		}
+		defer atomic.AddInt32(&running, -1)
+
+		if index != next {
+			t.Errorf("header verified out of order: have %d, want %d", index, next)
+		}
+		next++
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+		return nil
+	})
+	for i := range headers {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Fatalf("result %d mismatch: have %v, want nil", i, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("result %d timeout", i)
+		}
+	}
+}